@@ -8,7 +8,11 @@ require (
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/jinzhu/gorm v1.9.15
 	github.com/lib/pq v1.1.1
-	github.com/mattn/go-sqlite3 v1.14.0
+	github.com/mattn/go-sqlite3 v1.14.6 // >= v1.14.5 is required by gorm.io/driver/sqlite
 	github.com/neo4j/neo4j-go-driver v1.8.3
 	github.com/sirupsen/logrus v1.7.0
+	gopkg.in/yaml.v2 v2.4.0
+	gorm.io/driver/mysql v1.0.1
+	gorm.io/driver/sqlite v1.1.4
+	gorm.io/gorm v1.21.12
 )
@@ -0,0 +1,129 @@
+package redact
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Mask represents the replacement of redacted value.
+const Mask = "***"
+
+// Redactable represents a type whose values will always be redacted when logging.
+type Redactable interface {
+	Redacted()
+}
+
+// Rules represents some redaction rules, which match by name (column name, key name, parameter name) or by regexp on value.
+type Rules struct {
+	// names represents the name patterns compiled from glob syntax, see compileName.
+	names []*regexp.Regexp
+
+	// regexps represents the value regexps.
+	regexps []*regexp.Regexp
+}
+
+// AddNames adds some name patterns (in glob syntax, case-insensitive) to Rules, see compileName for details.
+func (r *Rules) AddNames(names ...string) {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			r.names = append(r.names, compileName(name)) // filter empty name
+		}
+	}
+}
+
+// compileName compiles given glob pattern to a case-insensitive regexp which matches the whole name. In the pattern, "*" matches any
+// sequence of characters, "?" matches any single character, "[...]" and "[^...]" match a character class, and "\" escapes the next
+// character. Note that unlike path.Match, "/" is not special, that is "*" also matches "/", and an invalid class is matched literally.
+func compileName(pattern string) *regexp.Regexp {
+	sb := strings.Builder{}
+	sb.WriteString("(?is)^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end <= 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if _, err := regexp.Compile("[" + class + "]"); err != nil {
+				sb.WriteString(`\[`)
+				continue
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// AddRegexps adds some value regexps to Rules.
+func (r *Rules) AddRegexps(regexps ...*regexp.Regexp) {
+	for _, re := range regexps {
+		if re != nil {
+			r.regexps = append(r.regexps, re) // filter nil regexp
+		}
+	}
+}
+
+// MatchName checks if given name matches any name pattern of Rules.
+func (r *Rules) MatchName(name string) bool {
+	if r == nil || name == "" {
+		return false
+	}
+	for _, pattern := range r.names {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchValue checks if given value is Redactable or matches any value regexp of Rules.
+func (r *Rules) MatchValue(value interface{}) bool {
+	indirectValue := reflect.Indirect(reflect.ValueOf(value))
+	if !indirectValue.IsValid() {
+		return false // nil
+	}
+	if _, ok := value.(Redactable); ok {
+		return true
+	}
+	value = indirectValue.Interface()
+	if _, ok := value.(Redactable); ok {
+		return true
+	}
+	if r == nil || len(r.regexps) == 0 {
+		return false
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	for _, re := range r.regexps {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
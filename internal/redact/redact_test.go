@@ -0,0 +1,64 @@
+package redact
+
+import (
+	"github.com/Aoi-hosizora/ahlib/xtesting"
+	"regexp"
+	"testing"
+)
+
+type secret string
+
+func (secret) Redacted() {}
+
+func TestRules(t *testing.T) {
+	rules := &Rules{}
+	rules.AddNames("password", " ", "*_TOKEN", "session:*", "user/?/pin", "key[0-9]", "a.b", `\*x`, "[z")
+	rules.AddRegexps(regexp.MustCompile(`^\$2[aby]\$`), nil)
+
+	for _, tc := range []struct {
+		give string
+		want bool
+	}{
+		{"", false},
+		{"password", true},
+		{"PassWord", true},
+		{"password2", false},
+		{"access_token", true},
+		{"token", false},
+		{"session:1", true},
+		{"session:user/1", true},
+		{"user/1/pin", true},
+		{"user/12/pin", false},
+		{"key1", true},
+		{"keya", false},
+		{"a.b", true},
+		{"axb", false},
+		{"*x", true},
+		{"ax", false},
+		{"[z", true},
+	} {
+		xtesting.Equal(t, rules.MatchName(tc.give), tc.want)
+	}
+
+	s := secret("x")
+	for _, tc := range []struct {
+		give interface{}
+		want bool
+	}{
+		{nil, false},
+		{"abc", false},
+		{"$2a$10$abcdefg", true},
+		{[]byte("$2b$10$abcdefg"), true},
+		{123, false},
+		{s, true},
+		{&s, true},
+		{(*secret)(nil), false},
+	} {
+		xtesting.Equal(t, rules.MatchValue(tc.give), tc.want)
+	}
+
+	var nilRules *Rules
+	xtesting.False(t, nilRules.MatchName("password"))
+	xtesting.False(t, nilRules.MatchValue("$2a$10$abcdefg"))
+	xtesting.True(t, nilRules.MatchValue(s))
+}
@@ -0,0 +1,291 @@
+package xgorm
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrAdvisoryLockHeld represents the advisory lock is already held by the AdvisoryLock itself, returned by AdvisoryLock's lock methods.
+	ErrAdvisoryLockHeld = errors.New("xgorm: advisory lock is already held")
+
+	// ErrAdvisoryLockNotHeld represents the advisory lock is not held by the AdvisoryLock, returned by AdvisoryLock's Unlock.
+	ErrAdvisoryLockNotHeld = errors.New("xgorm: advisory lock is not held")
+)
+
+const (
+	// DefaultAdvisoryLockTable is the default lock table name used by AdvisoryLock in SQLite.
+	DefaultAdvisoryLockTable = "xgorm_advisory_locks"
+
+	// mysqlMaxLockNameLength is the max length of MySQL's lock name in GET_LOCK.
+	mysqlMaxLockNameLength = 64
+)
+
+// advisoryLockOptions represents some options for AdvisoryLock, set by AdvisoryLockOption.
+type advisoryLockOptions struct {
+	table    string
+	interval time.Duration
+}
+
+// AdvisoryLockOption represents an option for AdvisoryLock, created by WithAdvisoryLockXXX functions.
+type AdvisoryLockOption func(*advisoryLockOptions)
+
+// WithAdvisoryLockTable returns an AdvisoryLockOption with lock table name, which is only used in SQLite, defaults to
+// DefaultAdvisoryLockTable.
+func WithAdvisoryLockTable(table string) AdvisoryLockOption {
+	return func(o *advisoryLockOptions) {
+		o.table = table
+	}
+}
+
+// WithAdvisoryLockInterval returns an AdvisoryLockOption with retry interval, which is used when waiting for the lock by polling in
+// PostgreSQL and SQLite, defaults to 100ms.
+func WithAdvisoryLockInterval(interval time.Duration) AdvisoryLockOption {
+	return func(o *advisoryLockOptions) {
+		o.interval = interval
+	}
+}
+
+// AdvisoryLock represents a named database-backed lock, which can be used for mutual exclusion across processes. It uses GET_LOCK and
+// RELEASE_LOCK in MySQL, pg_advisory_lock, pg_try_advisory_lock and pg_advisory_unlock (with the name hashed to int64 key) in PostgreSQL,
+// and a lock table in SQLite.
+//
+// For MySQL and PostgreSQL, the lock is bound to the database session, so it is acquired on a dedicated connection from the pool, which
+// is pinned until Unlock, and the lock is released automatically by the database when the connection is lost. For SQLite, the lock is a
+// row in the lock table, which will not be released if the process exits without calling Unlock.
+type AdvisoryLock struct {
+	db      *gorm.DB
+	name    string
+	options *advisoryLockOptions
+
+	mu    sync.Mutex
+	conn  *sql.Conn // mysql and postgres
+	owner string    // sqlite
+}
+
+// NewAdvisoryLock creates an AdvisoryLock with given gorm.DB, lock name and AdvisoryLockOption-s. Note that the gorm.DB must not be in a
+// transaction for MySQL and PostgreSQL.
+// Example:
+// 	lock := xgorm.NewAdvisoryLock(db, "job:daily-report")
+// 	ok, err := lock.TryLock(context.Background())
+// 	if err != nil || !ok {
+// 		return err
+// 	}
+// 	defer lock.Unlock(context.Background())
+func NewAdvisoryLock(db *gorm.DB, name string, options ...AdvisoryLockOption) *AdvisoryLock {
+	opt := &advisoryLockOptions{table: DefaultAdvisoryLockTable, interval: 100 * time.Millisecond}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if opt.interval <= 0 {
+		opt.interval = 100 * time.Millisecond
+	}
+	return &AdvisoryLock{db: db, name: name, options: opt}
+}
+
+// Name returns the lock name of AdvisoryLock.
+func (a *AdvisoryLock) Name() string {
+	return a.name
+}
+
+// TryLock tries to acquire the lock without waiting, and returns false if the lock is held by others.
+func (a *AdvisoryLock) TryLock(ctx context.Context) (bool, error) {
+	return a.acquire(ctx, 0)
+}
+
+// LockTimeout tries to acquire the lock in given timeout, and returns false if the lock is still held by others after the timeout.
+func (a *AdvisoryLock) LockTimeout(ctx context.Context, timeout time.Duration) (bool, error) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	return a.acquire(ctx, timeout)
+}
+
+// Lock acquires the lock, and blocks until the lock is acquired or the context is done.
+func (a *AdvisoryLock) Lock(ctx context.Context) error {
+	_, err := a.acquire(ctx, -1)
+	return err
+}
+
+// acquire acquires the lock in given timeout, and negative timeout means waiting until the context is done.
+func (a *AdvisoryLock) acquire(ctx context.Context, timeout time.Duration) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn != nil || a.owner != "" {
+		return false, ErrAdvisoryLockHeld
+	}
+
+	switch {
+	case IsMySQL(a.db):
+		return a.acquireMySQL(ctx, timeout)
+	case IsPostgreSQL(a.db):
+		return a.acquirePostgres(ctx, timeout)
+	default:
+		return a.acquireTable(ctx, timeout)
+	}
+}
+
+// dedicatedConn returns a dedicated connection from the primary sql.DB of gorm.DB.
+func (a *AdvisoryLock) dedicatedConn(ctx context.Context) (*sql.Conn, error) {
+	sqlDB := primarySQLDB(a.db)
+	if sqlDB == nil {
+		return nil, errors.New("xgorm: advisory lock requires a gorm.DB not in transaction")
+	}
+	return sqlDB.Conn(ctx)
+}
+
+// mysqlLockName returns the lock name used in MySQL, the name longer than 64 characters will be hashed.
+func (a *AdvisoryLock) mysqlLockName() string {
+	if len(a.name) <= mysqlMaxLockNameLength {
+		return a.name
+	}
+	return fmt.Sprintf("xgorm:%016x", uint64(hashLockName(a.name)))
+}
+
+// acquireMySQL acquires the lock by GET_LOCK in MySQL, which waits in the database.
+func (a *AdvisoryLock) acquireMySQL(ctx context.Context, timeout time.Duration) (bool, error) {
+	conn, err := a.dedicatedConn(ctx)
+	if err != nil {
+		return false, err
+	}
+	seconds := int64(-1) // infinite
+	if timeout >= 0 {
+		seconds = int64((timeout + time.Second - 1) / time.Second)
+	}
+	var result sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", a.mysqlLockName(), seconds).Scan(&result)
+	if err != nil || !result.Valid || result.Int64 != 1 {
+		closeLockConn(conn, err == nil) // the lock may have been acquired if failed to scan
+		if err == nil && !result.Valid {
+			err = errors.New("xgorm: failed to acquire advisory lock")
+		}
+		return false, err
+	}
+	a.conn = conn
+	return true, nil
+}
+
+// acquirePostgres acquires the lock by pg_advisory_lock or pg_try_advisory_lock in PostgreSQL.
+func (a *AdvisoryLock) acquirePostgres(ctx context.Context, timeout time.Duration) (bool, error) {
+	conn, err := a.dedicatedConn(ctx)
+	if err != nil {
+		return false, err
+	}
+	key := hashLockName(a.name)
+	if timeout < 0 {
+		if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			closeLockConn(conn, false)
+			return false, err
+		}
+		a.conn = conn
+		return true, nil
+	}
+
+	locked, err := pollLock(ctx, timeout, a.options.interval, func() (bool, error) {
+		locked := false
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+		return locked, err
+	})
+	if err != nil || !locked {
+		closeLockConn(conn, err == nil)
+		return false, err
+	}
+	a.conn = conn
+	return true, nil
+}
+
+// acquireTable acquires the lock by inserting a row into the lock table, which is used in SQLite.
+func (a *AdvisoryLock) acquireTable(ctx context.Context, timeout time.Duration) (bool, error) {
+	table := a.db.Dialect().Quote(a.options.table)
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name VARCHAR(255) NOT NULL PRIMARY KEY, owner VARCHAR(255) NOT NULL, locked_at TIMESTAMP NOT NULL)", table)
+	if err := a.db.Exec(query).Error; err != nil {
+		return false, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return false, err
+	}
+	owner := hex.EncodeToString(token)
+	query = fmt.Sprintf("INSERT OR IGNORE INTO %s (name, owner, locked_at) VALUES (?, ?, ?)", table)
+	locked, err := pollLock(ctx, timeout, a.options.interval, func() (bool, error) {
+		rdb := a.db.Exec(query, a.name, owner, time.Now())
+		return rdb.RowsAffected == 1, rdb.Error
+	})
+	if err != nil || !locked {
+		return false, err
+	}
+	a.owner = owner
+	return true, nil
+}
+
+// pollLock calls try function every interval until it returns true, the timeout exceeds or the context is done, and negative timeout
+// means no timeout.
+func pollLock(ctx context.Context, timeout, interval time.Duration, try func() (bool, error)) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := try()
+		if err != nil || locked {
+			return locked, err
+		}
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Unlock releases the lock, and returns ErrAdvisoryLockNotHeld if the lock is not held by the AdvisoryLock. For MySQL and PostgreSQL,
+// the lock is released in a non-cancellable context with a short timeout (rather than given context, so that a done context will not
+// leave the lock held), and the dedicated connection is returned to the pool only if the lock is released, otherwise it is discarded.
+func (a *AdvisoryLock) Unlock(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.owner != "" {
+		query := fmt.Sprintf("DELETE FROM %s WHERE name = ? AND owner = ?", a.db.Dialect().Quote(a.options.table))
+		rdb := a.db.Exec(query, a.name, a.owner)
+		if rdb.Error != nil {
+			return rdb.Error
+		}
+		a.owner = ""
+		if rdb.RowsAffected == 0 {
+			return ErrAdvisoryLockNotHeld // removed by others
+		}
+		return nil
+	}
+	if a.conn == nil {
+		return ErrAdvisoryLockNotHeld
+	}
+
+	releaseCtx, cancel := context.WithTimeout(context.Background(), sessionLockReleaseTimeout)
+	defer cancel()
+	var err error
+	released := false
+	if IsMySQL(a.db) {
+		var result sql.NullInt64
+		err = a.conn.QueryRowContext(releaseCtx, "SELECT RELEASE_LOCK(?)", a.mysqlLockName()).Scan(&result)
+		released = result.Valid && result.Int64 == 1
+	} else {
+		err = a.conn.QueryRowContext(releaseCtx, "SELECT pg_advisory_unlock($1)", hashLockName(a.name)).Scan(&released)
+	}
+	closeLockConn(a.conn, err == nil && released)
+	a.conn = nil
+	if err == nil && !released {
+		err = ErrAdvisoryLockNotHeld // connection has been reset
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package xgorm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"strings"
+)
+
+const (
+	// SQLiteMaxPlaceholders is the default max number of placeholders in a single statement of SQLite (SQLITE_MAX_VARIABLE_NUMBER).
+	SQLiteMaxPlaceholders = 999
+
+	// PostgreSQLMaxPlaceholders is the max number of placeholders in a single statement of PostgreSQL.
+	PostgreSQLMaxPlaceholders = 65535
+
+	// MySQLMaxPlaceholders is the max number of placeholders in a single prepared statement of MySQL.
+	MySQLMaxPlaceholders = 65535
+)
+
+// maxPlaceholders returns the max number of placeholders in a single statement of given gorm.DB's dialect.
+func maxPlaceholders(db *gorm.DB) int {
+	switch {
+	case IsSQLite(db):
+		return SQLiteMaxPlaceholders
+	case IsPostgreSQL(db):
+		return PostgreSQLMaxPlaceholders
+	default:
+		return MySQLMaxPlaceholders
+	}
+}
+
+// batchRow represents a row to be inserted in BatchCreate.
+type batchRow struct {
+	scope   *gorm.Scope
+	columns []string
+	values  []interface{}
+}
+
+// newBatchRow creates a batchRow from model value, sets the timestamps, and collects columns in the same way as gorm:create, that is,
+// blank fields with default value and blank primary key are skipped.
+func newBatchRow(db *gorm.DB, value interface{}) *batchRow {
+	scope := db.NewScope(value)
+	if !scope.HasError() {
+		scope.CallMethod("BeforeSave")
+	}
+	if !scope.HasError() {
+		scope.CallMethod("BeforeCreate")
+	}
+	now := gorm.NowFunc()
+	for _, name := range []string{"CreatedAt", "UpdatedAt"} {
+		if field, ok := scope.FieldByName(name); ok && field.IsBlank {
+			_ = field.Set(now)
+		}
+	}
+
+	row := &batchRow{scope: scope}
+	for _, field := range scope.Fields() {
+		if !field.IsNormal || field.IsIgnored || (field.IsBlank && (field.HasDefaultValue || field.IsPrimaryKey)) {
+			continue
+		}
+		row.columns = append(row.columns, field.DBName)
+		row.values = append(row.values, field.Field.Interface())
+	}
+	return row
+}
+
+// sameColumns checks if two batchRow-s have the same columns, only the rows with the same columns can be inserted in a single statement.
+func (b *batchRow) sameColumns(o *batchRow) bool {
+	if len(b.columns) != len(o.columns) {
+		return false
+	}
+	for i, column := range b.columns {
+		if o.columns[i] != column {
+			return false
+		}
+	}
+	return true
+}
+
+// BatchCreate inserts given slice of models (or pointer to slice) using multi-row INSERT statements in a transaction, and returns a
+// gorm.DB with the error and total rows affected, which can be checked by CreateErr. Here chunkSize is the max number of rows in a single
+// statement, and it will be reduced to stay under the placeholder limits (SQLite 999, PostgreSQL 65535 and MySQL 65535), zero or negative
+// value means no limit except the placeholder limits.
+//
+// Just like gorm's Create, the CreatedAt and UpdatedAt fields will be set if blank, the blank fields with default value (such as
+// GormTime.DeletedAt, which defaults to the soft-delete sentinel) will use database's default value, and BeforeSave, BeforeCreate,
+// AfterCreate and AfterSave methods will be invoked. But the default values will not be reloaded, and the callbacks of gorm's create
+// process will not be invoked.
+//
+// The blank auto-increment primary keys will be back-filled, by RETURNING in PostgreSQL, by last_insert_rowid() in SQLite, and by
+// LAST_INSERT_ID() in MySQL, which assumes that the auto-increment values in a statement are consecutive (innodb_autoinc_lock_mode is
+// 0 or 1, or there are no concurrent inserts on the table).
+// Example:
+// 	users := []*User{{Name: "user1"}, {Name: "user2"}}
+// 	status, err := xgorm.CreateErr(xgorm.BatchCreate(db, users, 500))
+func BatchCreate(db *gorm.DB, slice interface{}, chunkSize int) *gorm.DB {
+	rdb := db.New()
+	val := reflect.Indirect(reflect.ValueOf(slice))
+	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+		rdb.Error = errors.New("xgorm: BatchCreate only supports slice")
+		return rdb
+	}
+	if val.Len() == 0 {
+		return rdb
+	}
+
+	rows := make([]*batchRow, 0, val.Len())
+	for i := 0; i < val.Len(); i++ {
+		elem := val.Index(i)
+		for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct || !elem.CanAddr() {
+			rdb.Error = fmt.Errorf("xgorm: BatchCreate only supports slice of struct, got %s", elem.Kind().String())
+			return rdb
+		}
+		row := newBatchRow(db, elem.Addr().Interface())
+		if row.scope.HasError() {
+			rdb.Error = row.scope.DB().Error
+			return rdb
+		}
+		rows = append(rows, row)
+	}
+
+	affected := int64(0)
+	err := transaction(db, func(tx *gorm.DB) (err error) {
+		if affected, err = batchInsert(tx, rows, chunkSize); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			row.scope.CallMethod("AfterCreate")
+			if !row.scope.HasError() {
+				row.scope.CallMethod("AfterSave")
+			}
+			if row.scope.HasError() {
+				return row.scope.DB().Error
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		affected = 0
+	}
+	rdb.Error, rdb.RowsAffected = err, affected
+	return rdb
+}
+
+// transaction runs given function in a new transaction, or in the current transaction if given gorm.DB is already in a transaction.
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, inTx := db.CommonDB().(*sql.Tx); inTx {
+		return fn(db)
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// batchInsert splits rows into statements by columns, chunkSize and placeholder limits, and executes them.
+func batchInsert(tx *gorm.DB, rows []*batchRow, chunkSize int) (int64, error) {
+	limit := maxPlaceholders(tx)
+	affected := int64(0)
+	for start := 0; start < len(rows); {
+		end := start + 1
+		perRow := len(rows[start].columns)
+		for end < len(rows) && (chunkSize <= 0 || end-start < chunkSize) && (end-start+1)*perRow <= limit && rows[start].sameColumns(rows[end]) {
+			end++
+		}
+		count, err := batchInsertChunk(tx, rows[start:end])
+		if err != nil {
+			return 0, err
+		}
+		affected += count
+		start = end
+	}
+	return affected, nil
+}
+
+// batchInsertChunk executes a multi-row INSERT statement for rows with the same columns, and back-fills the auto-increment primary keys.
+func batchInsertChunk(tx *gorm.DB, rows []*batchRow) (int64, error) {
+	scope := rows[0].scope
+	values := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values = append(values, row.values)
+	}
+	query := fmt.Sprintf("INSERT INTO %s %s", scope.QuotedTableName(), tx.Dialect().DefaultValueStr())
+	if len(rows[0].columns) > 0 {
+		columns := make([]string, 0, len(rows[0].columns))
+		for _, column := range rows[0].columns {
+			columns = append(columns, scope.Quote(column))
+		}
+		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES ?", scope.QuotedTableName(), strings.Join(columns, ","))
+	} else if len(rows) > 1 {
+		// default values can not be inserted in multi-rows
+		affected := int64(0)
+		for _, row := range rows {
+			count, err := batchInsertChunk(tx, []*batchRow{row})
+			if err != nil {
+				return 0, err
+			}
+			affected += count
+		}
+		return affected, nil
+	}
+
+	primaryField := scope.PrimaryField()
+	backfill := primaryField != nil && primaryField.IsBlank
+	if backfill && IsPostgreSQL(tx) {
+		return batchInsertReturning(tx, query+" RETURNING "+scope.Quote(primaryField.DBName), values, rows)
+	}
+
+	var rdb *gorm.DB
+	if len(rows[0].columns) > 0 {
+		rdb = tx.Exec(query, values)
+	} else {
+		rdb = tx.Exec(query)
+	}
+	if rdb.Error != nil {
+		return 0, rdb.Error
+	}
+	if !backfill || (!IsMySQL(tx) && !IsSQLite(tx)) {
+		return rdb.RowsAffected, nil
+	}
+
+	id, err := lastInsertId(tx)
+	if err != nil {
+		return 0, err
+	}
+	if IsSQLite(tx) {
+		id -= int64(len(rows)) - 1 // the last id in sqlite, and the first id in mysql
+	}
+	for i, row := range rows {
+		if err := row.scope.PrimaryField().Set(id + int64(i)); err != nil {
+			return 0, err
+		}
+	}
+	return rdb.RowsAffected, nil
+}
+
+// lastInsertId returns the auto-increment id generated by the most recent INSERT statement in current connection, by LAST_INSERT_ID()
+// in MySQL and last_insert_rowid() in SQLite. Note that for multi-row INSERT, mysql returns the first id and sqlite returns the last id.
+func lastInsertId(tx *gorm.DB) (int64, error) {
+	query := "SELECT LAST_INSERT_ID()"
+	if IsSQLite(tx) {
+		query = "SELECT last_insert_rowid()"
+	}
+	var id int64
+	err := tx.Raw(query).Row().Scan(&id)
+	return id, err
+}
+
+// batchInsertReturning executes a multi-row INSERT statement with RETURNING clause, and back-fills the primary keys.
+func batchInsertReturning(tx *gorm.DB, query string, values [][]interface{}, rows []*batchRow) (int64, error) {
+	var sqlRows *sql.Rows
+	var err error
+	if len(rows[0].columns) > 0 {
+		sqlRows, err = tx.Raw(query, values).Rows()
+	} else {
+		sqlRows, err = tx.Raw(query).Rows()
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer sqlRows.Close()
+
+	affected := int64(0)
+	for sqlRows.Next() {
+		if int(affected) >= len(rows) {
+			break
+		}
+		primaryField := rows[affected].scope.PrimaryField()
+		if err = sqlRows.Scan(primaryField.Field.Addr().Interface()); err != nil {
+			return 0, err
+		}
+		primaryField.IsBlank = false
+		affected++
+	}
+	return affected, sqlRows.Err()
+}
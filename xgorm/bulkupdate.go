@@ -0,0 +1,259 @@
+package xgorm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// bulkUpdateRow represents a row to be updated in BulkUpdate.
+type bulkUpdateRow struct {
+	key    interface{}
+	values map[string]interface{} // column -> value
+}
+
+// BulkUpdate updates rows with different values by primary key, using chunked "UPDATE t SET col = CASE pk WHEN ? THEN ? ... ELSE col
+// END WHERE pk IN (?)" statements in a transaction, and returns the affected rows count of each chunk. Note that the count in MySQL
+// only contains the changed rows unless clientFoundRows is set in dsn.
+//
+// The model is used to get the table and primary key, and the values can be a slice of models, a slice of map[string]interface{} which
+// contains the primary key, or a map keyed by primary key whose values are map[string]interface{}. The columns are the column names or
+// field names to be updated, which defaults to all the fields except primary key, CreatedAt, UpdatedAt and DeletedAt for models, and
+// all the keys for maps. A map without some columns will keep these columns unchanged.
+//
+// The statements are executed by gorm's update process, so the conditions of HookDeletedAt will be added, and the UpdatedAt field will be
+// set to current time. Chunks are split by chunkSize and the placeholder limits, zero or negative chunkSize means no limit except the
+// placeholder limits.
+// Example:
+// 	counts, err := xgorm.BulkUpdate(db, &User{}, users, 500, "name", "age")
+// 	counts, err := xgorm.BulkUpdate(db, &User{}, map[int]map[string]interface{}{1: {"name": "a"}, 2: {"name": "b", "age": 18}}, 500)
+func BulkUpdate(db *gorm.DB, model interface{}, values interface{}, chunkSize int, columns ...string) ([]int64, error) {
+	scope := db.NewScope(model)
+	if scope.PrimaryField() == nil {
+		return nil, errors.New("xgorm: BulkUpdate without primary key")
+	}
+	dbNames := make([]string, 0, len(columns))
+	for _, column := range columns {
+		field, ok := scope.FieldByName(column)
+		if !ok || !field.IsNormal || field.IsIgnored {
+			return nil, fmt.Errorf("xgorm: unknown column %s", column)
+		}
+		dbNames = append(dbNames, field.DBName)
+	}
+	columns = dbNames
+
+	rows, err := parseBulkUpdateRows(scope, values, columns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return []int64{}, nil
+	}
+
+	// collect columns in order
+	if len(columns) == 0 {
+		exists := make(map[string]bool)
+		for _, row := range rows {
+			for column := range row.values {
+				if !exists[column] {
+					exists[column] = true
+					columns = append(columns, column)
+				}
+			}
+		}
+		sort.Strings(columns)
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("xgorm: BulkUpdate without column")
+	}
+
+	// postgres can not infer the type of parameters in CASE, so cast them to the column type
+	casts := make(map[string]string, len(columns))
+	if IsPostgreSQL(db) {
+		modelColumns, _ := parseModelColumns(scope)
+		for _, column := range modelColumns {
+			typ := strings.ToLower(column.rawType)
+			switch typ {
+			case "serial":
+				typ = "integer"
+			case "bigserial":
+				typ = "bigint"
+			}
+			casts[column.Name] = typ
+		}
+	}
+
+	size := (maxPlaceholders(db) - 1) / (2*len(columns) + 1) // 2 for each CASE, 1 for IN, and 1 for updated_at
+	if chunkSize > 0 && chunkSize < size {
+		size = chunkSize
+	}
+	if size < 1 {
+		size = 1
+	}
+	counts := make([]int64, 0, (len(rows)+size-1)/size)
+	err = transaction(db, func(tx *gorm.DB) error {
+		for start := 0; start < len(rows); start += size {
+			end := start + size
+			if end > len(rows) {
+				end = len(rows)
+			}
+			count, err := bulkUpdateChunk(tx, scope, rows[start:end], columns, casts)
+			if err != nil {
+				return err
+			}
+			counts = append(counts, count)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// parseBulkUpdateRows parses the values of BulkUpdate to bulkUpdateRow-s.
+func parseBulkUpdateRows(scope *gorm.Scope, values interface{}, columns []string) ([]*bulkUpdateRow, error) {
+	fromMap := func(key interface{}, m interface{}) (*bulkUpdateRow, error) {
+		val := reflect.ValueOf(m)
+		if val.Kind() != reflect.Map || val.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("xgorm: BulkUpdate with invalid map type %T", m)
+		}
+		row := &bulkUpdateRow{key: key, values: make(map[string]interface{}, val.Len())}
+		for _, k := range val.MapKeys() {
+			field, ok := scope.FieldByName(k.String())
+			if !ok || !field.IsNormal || field.IsIgnored {
+				return nil, fmt.Errorf("xgorm: unknown column %s", k.String())
+			}
+			if field.IsPrimaryKey {
+				if row.key == nil {
+					row.key = val.MapIndex(k).Interface()
+				}
+				continue
+			}
+			row.values[field.DBName] = val.MapIndex(k).Interface()
+		}
+		if row.key == nil {
+			return nil, errors.New("xgorm: BulkUpdate with map without primary key")
+		}
+		return row, nil
+	}
+	fromModel := func(elem reflect.Value) (*bulkUpdateRow, error) {
+		elemScope := scope.New(elem.Addr().Interface())
+		primary := elemScope.PrimaryField()
+		if primary == nil || primary.IsBlank {
+			return nil, errors.New("xgorm: BulkUpdate with model without primary key")
+		}
+		row := &bulkUpdateRow{key: primary.Field.Interface(), values: make(map[string]interface{})}
+		for _, field := range elemScope.Fields() {
+			if !field.IsNormal || field.IsIgnored || field.IsPrimaryKey {
+				continue
+			}
+			if len(columns) == 0 && (field.Name == "CreatedAt" || field.Name == "UpdatedAt" || field.Name == deletedAtFieldName) {
+				continue
+			}
+			row.values[field.DBName] = field.Field.Interface()
+		}
+		return row, nil
+	}
+
+	rows := make([]*bulkUpdateRow, 0)
+	val := reflect.Indirect(reflect.ValueOf(values))
+	switch val.Kind() {
+	case reflect.Map:
+		keys := val.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			row, err := fromMap(key.Interface(), val.MapIndex(key).Interface())
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < val.Len(); i++ {
+			elem := val.Index(i)
+			for elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface {
+				elem = elem.Elem()
+			}
+			var row *bulkUpdateRow
+			var err error
+			switch {
+			case elem.Kind() == reflect.Map:
+				row, err = fromMap(nil, elem.Interface())
+			case elem.Kind() == reflect.Struct && elem.CanAddr():
+				row, err = fromModel(elem)
+			default:
+				err = fmt.Errorf("xgorm: BulkUpdate with invalid element type %s", elem.Kind().String())
+			}
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, fmt.Errorf("xgorm: BulkUpdate with invalid values type %T", values)
+	}
+
+	if len(columns) > 0 {
+		for _, row := range rows {
+			for column := range row.values {
+				found := false
+				for _, c := range columns {
+					if c == column {
+						found = true
+						break
+					}
+				}
+				if !found {
+					delete(row.values, column)
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// bulkUpdateChunk executes a bulk update statement for a chunk of rows by gorm's Updates.
+func bulkUpdateChunk(tx *gorm.DB, scope *gorm.Scope, rows []*bulkUpdateRow, columns []string, casts map[string]string) (int64, error) {
+	primaryKey := scope.Quote(scope.PrimaryKey())
+	keys := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, row.key)
+	}
+
+	updates := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		placeholder := "?"
+		if typ, ok := casts[column]; ok {
+			placeholder = fmt.Sprintf("CAST(? AS %s)", typ)
+		}
+		sb := strings.Builder{}
+		args := make([]interface{}, 0, 2*len(rows))
+		sb.WriteString("CASE " + primaryKey)
+		for _, row := range rows {
+			value, ok := row.values[column]
+			if !ok {
+				continue
+			}
+			sb.WriteString(" WHEN ? THEN " + placeholder)
+			args = append(args, row.key, value)
+		}
+		if len(args) == 0 {
+			continue // no row updates this column
+		}
+		sb.WriteString(" ELSE " + scope.Quote(column) + " END")
+		updates[column] = gorm.Expr(sb.String(), args...)
+	}
+	if len(updates) == 0 {
+		return 0, nil
+	}
+
+	model := reflect.New(scope.GetModelStruct().ModelType).Interface()
+	rdb := tx.Model(model).Where(fmt.Sprintf("%s.%s IN (?)", scope.QuotedTableName(), primaryKey), keys).Updates(updates)
+	return rdb.RowsAffected, rdb.Error
+}
@@ -0,0 +1,652 @@
+package xgorm
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queryCacheTTLKey is the gorm.DB setting key for per-query cache ttl, set by WithCacheTTL.
+	queryCacheTTLKey = "xgorm:query_cache_ttl"
+
+	// queryCacheSkipKey is the gorm.DB setting key for bypassing the query cache, set by SkipCache.
+	queryCacheSkipKey = "xgorm:query_cache_skip"
+
+	// queryCacheTablesKey is the gorm.DB setting key for the tables changed in transaction, set by QueryCache.Transaction.
+	queryCacheTablesKey = "xgorm:query_cache_tables"
+)
+
+// _tableRegexp is used to extract the table names following FROM and JOIN keywords from a query.
+var _tableRegexp = regexp.MustCompile("(?i)\\b(?:from|join)\\s+((?:[`\"]?[\\w$]+[`\"]?\\.)?[`\"]?[\\w$]+[`\"]?)")
+
+// errQueryCapture is the error returned by queryCaptureCommon, used to stop the query after its sql is prepared.
+var errQueryCapture = errors.New("xgorm: query captured")
+
+// WithCacheTTL returns a new gorm.DB with given cache ttl for the queries, which overrides the default expiration of QueryCache.
+// Example:
+// 	xgorm.WithCacheTTL(db, time.Hour).Where("id = ?", 1).First(&user)
+func WithCacheTTL(db *gorm.DB, ttl time.Duration) *gorm.DB {
+	return db.Set(queryCacheTTLKey, ttl)
+}
+
+// SkipCache returns a new gorm.DB which bypasses the query cache, that is the queries are executed against the database directly, and the
+// results are not cached.
+// Example:
+// 	xgorm.SkipCache(db).Where("id = ?", 1).First(&user)
+func SkipCache(db *gorm.DB) *gorm.DB {
+	return db.Set(queryCacheSkipKey, true)
+}
+
+// queryCacheOptions represents some options for QueryCache, set by QueryCacheOption.
+type queryCacheOptions struct {
+	prefix       string
+	expiration   time.Duration
+	errorHandler func(error)
+}
+
+// QueryCacheOption represents an option for QueryCache, created by WithQueryCacheXXX functions.
+type QueryCacheOption func(*queryCacheOptions)
+
+// WithQueryCachePrefix returns a QueryCacheOption with the prefix of redis keys, defaults to "xgorm:cache:".
+func WithQueryCachePrefix(prefix string) QueryCacheOption {
+	return func(o *queryCacheOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithQueryCacheExpiration returns a QueryCacheOption with the default ttl of cached results, defaults to 1min.
+func WithQueryCacheExpiration(expiration time.Duration) QueryCacheOption {
+	return func(o *queryCacheOptions) {
+		o.expiration = expiration
+	}
+}
+
+// WithQueryCacheErrorHandler returns a QueryCacheOption with error handler, which will be invoked when redis operations or cache encoding
+// fail, defaults to nil. Note that these errors are not returned to gorm.DB, and the queries fall back to the database.
+func WithQueryCacheErrorHandler(handler func(error)) QueryCacheOption {
+	return func(o *queryCacheOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// QueryCache represents a query result cache in redis, which caches the rows of gorm queries keyed by the normalized sql and arguments,
+// and invalidates the cached results by table tags, see HookQueryCache for details.
+type QueryCache struct {
+	client  *redis.Client
+	options *queryCacheOptions
+
+	mu    sync.Mutex
+	calls map[string]*queryCacheCall // singleflight
+
+	scanner *sql.DB // serves bufferedRows as sql.Rows
+	rows    sync.Map
+	token   uint64
+}
+
+// queryCacheCall represents an in-flight or completed database query of QueryCache.
+type queryCacheCall struct {
+	wg   sync.WaitGroup
+	rows *bufferedRows
+	err  error
+}
+
+// NewQueryCache creates a new QueryCache with given redis client and QueryCacheOption-s, note that HookQueryCache must be invoked on the
+// gorm.DB before using this cache.
+// Example:
+// 	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+// 	cache := xgorm.NewQueryCache(client, xgorm.WithQueryCacheExpiration(5*time.Minute))
+// 	xgorm.HookQueryCache(db, cache)
+func NewQueryCache(client *redis.Client, options ...QueryCacheOption) *QueryCache {
+	opt := &queryCacheOptions{prefix: "xgorm:cache:", expiration: time.Minute}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if opt.expiration <= 0 {
+		opt.expiration = time.Minute
+	}
+	cache := &QueryCache{client: client, options: opt, calls: make(map[string]*queryCacheCall)}
+	cache.scanner = sql.OpenDB(&queryCacheConnector{cache: cache})
+	return cache
+}
+
+// Invalidate invalidates all the cached results which query the given tables, this is useful when the tables are changed by Exec or by
+// other applications, which are not tracked by the callbacks of HookQueryCache.
+func (q *QueryCache) Invalidate(ctx context.Context, tables ...string) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	pipe := q.client.TxPipeline()
+	for _, table := range tables {
+		pipe.Set(ctx, q.versionKey(table), newTableVersion(), 0)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Transaction runs given function in a transaction by gorm.DB's Transaction, and invalidates the tables changed in the transaction again
+// after committing, so that the rows read by concurrent queries before committing will not be cached under the new table versions. If
+// given gorm.DB is already in a transaction, the changed tables will be invalidated after the outermost QueryCache.Transaction commits.
+// Example:
+// 	err := cache.Transaction(db, func(tx *gorm.DB) error {
+// 		return tx.Model(&user).Update("name", "user2").Error
+// 	})
+func (q *QueryCache) Transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if _, ok := db.Get(queryCacheTablesKey); ok {
+		return db.Transaction(fn) // nested
+	}
+	tables := &queryCacheTables{set: make(map[string]bool)}
+	if err := db.Set(queryCacheTablesKey, tables).Transaction(fn); err != nil {
+		return err
+	}
+	q.handleError(q.Invalidate(context.Background(), tables.list()...))
+	return nil
+}
+
+// queryCacheTables represents the tables changed in a transaction, used in QueryCache.Transaction.
+type queryCacheTables struct {
+	mu  sync.Mutex
+	set map[string]bool
+}
+
+// add adds a changed table.
+func (q *queryCacheTables) add(table string) {
+	q.mu.Lock()
+	q.set[table] = true
+	q.mu.Unlock()
+}
+
+// list returns all the changed tables.
+func (q *queryCacheTables) list() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tables := make([]string, 0, len(q.set))
+	for table := range q.set {
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// versionKey returns the redis key of the version of given table, which is replaced when the table is changed.
+func (q *QueryCache) versionKey(table string) string {
+	return q.options.prefix + "table:" + table
+}
+
+// newTableVersion generates a new random table version, so the versions will never be reused even if the version keys are evicted.
+func newTableVersion() string {
+	bs := make([]byte, 8)
+	if _, err := rand.Read(bs); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(bs)
+}
+
+// versions returns the versions of given version keys, the missing versions (never changed or evicted) are initialized with new random
+// versions, so the results cached under the evicted versions will not be revalidated.
+func (q *QueryCache) versions(ctx context.Context, keys []string) ([]interface{}, error) {
+	versions, err := q.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := q.client.Pipeline()
+	missing := false
+	for i, version := range versions {
+		if version == nil {
+			missing = true
+			pipe.SetNX(ctx, keys[i], newTableVersion(), 0)
+		}
+	}
+	if !missing {
+		return versions, nil
+	}
+	if _, err = pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return q.client.MGet(ctx, keys...).Result() // the versions may be set by concurrent queries
+}
+
+// queryKey returns the redis key of the cached result, which is composed of the fingerprint of the normalized sql, and the hash of the
+// sql, arguments and table versions.
+func (q *QueryCache) queryKey(query, dialect string, vars []interface{}, versions []interface{}) string {
+	h := sha1.New()
+	_, _ = h.Write([]byte(query))
+	for _, v := range vars {
+		bs, _ := json.Marshal(encodeRecordValue(v))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(bs)
+	}
+	for _, v := range versions {
+		version, _ := v.(string)
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(version))
+	}
+	return q.options.prefix + "query:" + FingerprintDialectSQL(query, dialect) + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// handleError invokes the error handler if exists.
+func (q *QueryCache) handleError(err error) {
+	if err != nil && q.options.errorHandler != nil {
+		q.options.errorHandler(err)
+	}
+}
+
+// do executes fn once for the same key at the same time, and shares the result with the concurrent callers.
+func (q *QueryCache) do(key string, fn func() (*bufferedRows, error)) (*bufferedRows, error) {
+	q.mu.Lock()
+	if call, ok := q.calls[key]; ok {
+		q.mu.Unlock()
+		call.wg.Wait()
+		return call.rows, call.err
+	}
+	call := &queryCacheCall{}
+	call.wg.Add(1)
+	q.calls[key] = call
+	q.mu.Unlock()
+
+	call.rows, call.err = fn()
+	call.wg.Done()
+	q.mu.Lock()
+	delete(q.calls, key)
+	q.mu.Unlock()
+	return call.rows, call.err
+}
+
+// cachedRows represents the rows stored in redis.
+type cachedRows struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]recordValue `json:"rows,omitempty"`
+}
+
+// encodeRows encodes bufferedRows to cached data.
+func encodeRows(rows *bufferedRows) ([]byte, error) {
+	cached := &cachedRows{Columns: rows.columns, Rows: make([][]recordValue, 0, len(rows.values))}
+	for _, values := range rows.values {
+		row := make([]recordValue, len(values))
+		for i, value := range values {
+			row[i] = encodeRecordValue(value)
+		}
+		cached.Rows = append(cached.Rows, row)
+	}
+	return json.Marshal(cached)
+}
+
+// decodeRows decodes cached data to bufferedRows.
+func decodeRows(data []byte) (*bufferedRows, error) {
+	cached := &cachedRows{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, err
+	}
+	rows := &bufferedRows{columns: cached.Columns, values: make([][]interface{}, 0, len(cached.Rows))}
+	for _, row := range cached.Rows {
+		values := make([]interface{}, len(row))
+		for i, value := range row {
+			var err error
+			if values[i], err = value.decode(); err != nil {
+				return nil, err
+			}
+		}
+		rows.values = append(rows.values, values)
+	}
+	return rows, nil
+}
+
+// sqlRows converts bufferedRows to sql.Rows, through the scanner sql.DB of QueryCache.
+func (q *QueryCache) sqlRows(rows *bufferedRows) (*sql.Rows, error) {
+	token := strconv.FormatUint(atomic.AddUint64(&q.token, 1), 10)
+	q.rows.Store(token, &bufferedRows{columns: rows.columns, values: rows.values}) // copy index
+	defer q.rows.Delete(token)
+	return q.scanner.Query(token)
+}
+
+// HookQueryCache hooks gorm.DB to cache the query results in redis by QueryCache, this should be invoked only once. The gorm:query
+// callback is replaced, and the rows are read from redis if cached, otherwise they are read from the database (only once for the
+// concurrent identical queries) and cached with the ttl. The cache key is composed of the normalized sql, arguments and the versions of
+// the queried tables (model's table, FROM and JOIN tables), and the version of a table is replaced by a new random version (that is, the
+// results are invalidated) after it is changed and committed by create, update and delete callbacks.
+//
+// The queries in transaction, with locking clause (see Lock) or with SkipCache are not cached. Note that the cached queries are not logged
+// by gorm's logger, and the tables changed by Exec should be invalidated by QueryCache.Invalidate manually. For the changes in explicit
+// transaction (such as DB.Begin and DB.Transaction), the tables are invalidated when changed, but a concurrent query may still read and
+// cache the old rows before committing, so use QueryCache.Transaction instead, which invalidates the changed tables again after committing.
+// Example:
+// 	cache := xgorm.NewQueryCache(client)
+// 	xgorm.HookQueryCache(db, cache)
+// 	db.Where("id = ?", 1).First(&user)                      // cached
+// 	xgorm.SkipCache(db).Where("id = ?", 1).First(&user)     // not cached
+// 	db.Model(&user).Update("name", "user2")                 // invalidated
+func HookQueryCache(db *gorm.DB, cache *QueryCache) *gorm.DB {
+	capture, err := gorm.Open(db.Dialect().GetName(), &queryCaptureCommon{})
+	if err != nil {
+		panic(err) // unreachable
+	}
+	hook := &queryCacheHook{cache: cache, query: db.Callback().Query().Get("gorm:query"), capture: capture.LogMode(false)}
+
+	// query
+	db.Callback().Query().Replace("gorm:query", hook.queryCallback)
+
+	// update
+	db.Callback().Update().
+		After("gorm:commit_or_rollback_transaction").
+		Register("query_cache_after_update_callback", hook.invalidateCallback)
+
+	// delete
+	db.Callback().Delete().
+		After("gorm:commit_or_rollback_transaction").
+		Register("query_cache_after_delete_callback", hook.invalidateCallback)
+
+	// create
+	db.Callback().Create().
+		After("gorm:commit_or_rollback_transaction").
+		Register("query_cache_after_create_callback", hook.invalidateCallback)
+
+	return db
+}
+
+// queryCacheHook represents the callbacks registered by HookQueryCache.
+type queryCacheHook struct {
+	cache   *QueryCache
+	query   func(*gorm.Scope) // original gorm:query
+	capture *gorm.DB          // used to prepare sql without executing
+}
+
+// bypass checks if the query of given scope should not be cached.
+func (h *queryCacheHook) bypass(scope *gorm.Scope) bool {
+	if skip, ok := scope.Get(queryCacheSkipKey); ok && skip == true {
+		return true
+	}
+	if _, ok := scope.Get("gorm:query_option"); ok {
+		return true // locking
+	}
+	if _, ok := scope.InstanceGet("gorm:skip_query_callback"); ok {
+		return true
+	}
+	if _, ok := scope.InstanceGet("gorm:only_preload"); ok {
+		return true
+	}
+	_, inTx := scope.SQLDB().(*sql.Tx)
+	return inTx
+}
+
+// prepare prepares the sql and vars of given scope by the original gorm:query callback, using the capture gorm.DB.
+func (h *queryCacheHook) prepare(scope *gorm.Scope) (string, []interface{}, bool) {
+	capture := h.capture
+	for _, key := range []string{"gorm:order_by_primary_key", "gorm:query_destination", "gorm:query_hint"} {
+		if value, ok := scope.Get(key); ok {
+			capture = capture.Set(key, value)
+		}
+	}
+	search := *scope.Search
+	captureScope := capture.NewScope(scope.Value)
+	captureScope.Search = &search
+	h.query(captureScope)
+	if captureScope.DB().Error != errQueryCapture || captureScope.SQL == "" {
+		return "", nil, false
+	}
+	return captureScope.SQL, captureScope.SQLVars, true
+}
+
+// queryTables returns the sorted tables queried by given scope and sql.
+func queryTables(scope *gorm.Scope, query string) []string {
+	set := make(map[string]bool)
+	if table := scope.TableName(); table != "" {
+		set[table] = true
+	}
+	for _, match := range _tableRegexp.FindAllStringSubmatch(query, -1) {
+		set[strings.NewReplacer("`", "", `"`, "").Replace(match[1])] = true
+	}
+	tables := make([]string, 0, len(set))
+	for table := range set {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// queryCallback is a callback replacing gorm:query used in HookQueryCache.
+func (h *queryCacheHook) queryCallback(scope *gorm.Scope) {
+	if scope.HasError() || h.bypass(scope) {
+		h.query(scope)
+		return
+	}
+	_ = scope.TableName() // compute table name of model by the original gorm.DB first
+	query, vars, ok := h.prepare(scope)
+	if !ok {
+		h.query(scope)
+		return
+	}
+
+	ctx := context.Background()
+	cache := h.cache
+	tables := queryTables(scope, query)
+	versionKeys := make([]string, 0, len(tables))
+	for _, table := range tables {
+		versionKeys = append(versionKeys, cache.versionKey(table))
+	}
+	var versions []interface{}
+	if len(versionKeys) > 0 {
+		var err error
+		if versions, err = cache.versions(ctx, versionKeys); err != nil {
+			cache.handleError(err)
+			h.query(scope)
+			return
+		}
+	}
+	key := cache.queryKey(query, scope.Dialect().GetName(), vars, versions)
+
+	var rows *bufferedRows
+	data, err := cache.client.Get(ctx, key).Bytes()
+	if err == nil {
+		if rows, err = decodeRows(data); err != nil {
+			cache.handleError(err)
+		}
+	} else if err != redis.Nil {
+		cache.handleError(err)
+	}
+	if rows == nil {
+		ttl := cache.options.expiration
+		if value, ok := scope.Get(queryCacheTTLKey); ok {
+			if t, ok := value.(time.Duration); ok && t > 0 {
+				ttl = t
+			}
+		}
+		rows, err = cache.do(key, func() (*bufferedRows, error) {
+			sqlRows, err := scope.SQLDB().Query(query, vars...)
+			if err != nil {
+				return nil, err
+			}
+			rows, err := readBufferedRows(sqlRows)
+			if err != nil {
+				return nil, err
+			}
+			data, err := encodeRows(rows)
+			if err == nil {
+				err = cache.client.Set(ctx, key, data, ttl).Err()
+			}
+			cache.handleError(err)
+			return rows, nil
+		})
+		if scope.Err(err) != nil {
+			return
+		}
+	}
+
+	scope.SQL, scope.SQLVars = query, vars
+	h.scan(scope, rows)
+}
+
+// scan scans the bufferedRows into the destination of given scope, in the same way of gorm:query.
+func (h *queryCacheHook) scan(scope *gorm.Scope, rows *bufferedRows) {
+	var (
+		isSlice, isPtr bool
+		resultType     reflect.Type
+		results        = scope.IndirectValue()
+	)
+	if value, ok := scope.Get("gorm:query_destination"); ok {
+		results = reflect.Indirect(reflect.ValueOf(value))
+	}
+	if kind := results.Kind(); kind == reflect.Slice {
+		isSlice = true
+		resultType = results.Type().Elem()
+		results.Set(reflect.MakeSlice(results.Type(), 0, 0))
+		if resultType.Kind() == reflect.Ptr {
+			isPtr = true
+			resultType = resultType.Elem()
+		}
+	} else if kind != reflect.Struct {
+		scope.Err(errors.New("unsupported destination, should be slice or struct"))
+		return
+	}
+
+	sqlRows, err := h.cache.sqlRows(rows)
+	if scope.Err(err) != nil {
+		return
+	}
+	defer sqlRows.Close()
+
+	db := scope.DB()
+	db.RowsAffected = 0
+	for sqlRows.Next() {
+		db.RowsAffected++
+		elem := results
+		if isSlice {
+			elem = reflect.New(resultType).Elem()
+		}
+		if scope.Err(scope.NewDB().ScanRows(sqlRows, elem.Addr().Interface())) != nil {
+			return
+		}
+		if isSlice {
+			if isPtr {
+				results.Set(reflect.Append(results, elem.Addr()))
+			} else {
+				results.Set(reflect.Append(results, elem))
+			}
+		}
+	}
+	if err := sqlRows.Err(); err != nil {
+		scope.Err(err)
+	} else if db.RowsAffected == 0 && !isSlice {
+		scope.Err(gorm.ErrRecordNotFound)
+	}
+}
+
+// invalidateCallback is a callback after gorm:commit_or_rollback_transaction of create, update and delete used in HookQueryCache. Note
+// that the statement has been committed here unless it is in an explicit transaction, in which case the table is recorded to be
+// invalidated again by QueryCache.Transaction.
+func (h *queryCacheHook) invalidateCallback(scope *gorm.Scope) {
+	if scope.HasError() || scope.DB().RowsAffected == 0 {
+		return
+	}
+	table := scope.TableName()
+	if table == "" {
+		return
+	}
+	h.cache.handleError(h.cache.Invalidate(context.Background(), table))
+	if _, inTx := scope.SQLDB().(*sql.Tx); inTx {
+		if tables, ok := scope.Get(queryCacheTablesKey); ok {
+			tables.(*queryCacheTables).add(table)
+		}
+	}
+}
+
+// queryCaptureCommon is a gorm.SQLCommon which fails all the statements with errQueryCapture, used to prepare query sql.
+type queryCaptureCommon struct{}
+
+var _ gorm.SQLCommon = &queryCaptureCommon{}
+
+// Exec implements gorm.SQLCommon.
+func (q *queryCaptureCommon) Exec(string, ...interface{}) (sql.Result, error) {
+	return nil, errQueryCapture
+}
+
+// Prepare implements gorm.SQLCommon.
+func (q *queryCaptureCommon) Prepare(string) (*sql.Stmt, error) {
+	return nil, errQueryCapture
+}
+
+// Query implements gorm.SQLCommon.
+func (q *queryCaptureCommon) Query(string, ...interface{}) (*sql.Rows, error) {
+	return nil, errQueryCapture
+}
+
+// QueryRow implements gorm.SQLCommon, note that this method should not be used.
+func (q *queryCaptureCommon) QueryRow(string, ...interface{}) *sql.Row {
+	return nil
+}
+
+// queryCacheConnector is a driver.Connector which serves the bufferedRows stored in QueryCache by token, used to convert bufferedRows
+// to sql.Rows.
+type queryCacheConnector struct {
+	cache *QueryCache
+}
+
+var (
+	_ driver.Connector      = &queryCacheConnector{}
+	_ driver.Driver         = &queryCacheConnector{}
+	_ driver.QueryerContext = &queryCacheConn{}
+)
+
+// Connect implements driver.Connector.
+func (q *queryCacheConnector) Connect(context.Context) (driver.Conn, error) {
+	return &queryCacheConn{cache: q.cache}, nil
+}
+
+// Driver implements driver.Connector.
+func (q *queryCacheConnector) Driver() driver.Driver {
+	return q
+}
+
+// Open implements driver.Driver.
+func (q *queryCacheConnector) Open(string) (driver.Conn, error) {
+	return &queryCacheConn{cache: q.cache}, nil
+}
+
+// queryCacheConn is a driver.Conn used in queryCacheConnector.
+type queryCacheConn struct {
+	cache *QueryCache
+}
+
+// Prepare implements driver.Conn.
+func (q *queryCacheConn) Prepare(query string) (driver.Stmt, error) {
+	return &unpreparedStmt{conn: q, query: query}, nil
+}
+
+// Close implements driver.Conn.
+func (q *queryCacheConn) Close() error {
+	return nil
+}
+
+// Begin implements driver.Conn.
+func (q *queryCacheConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("xgorm: query cache does not support transaction")
+}
+
+// ExecContext implements driver.ExecerContext.
+func (q *queryCacheConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, errors.New("xgorm: query cache does not support exec")
+}
+
+// QueryContext implements driver.QueryerContext, the query is the token of bufferedRows.
+func (q *queryCacheConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	rows, ok := q.cache.rows.Load(query)
+	if !ok {
+		return nil, errors.New("xgorm: query cache rows not found")
+	}
+	return rows.(*bufferedRows), nil
+}
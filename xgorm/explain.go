@@ -0,0 +1,357 @@
+package xgorm
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// explainTimeout is the timeout for executing EXPLAIN statement.
+	explainTimeout = 3 * time.Second
+
+	// explainWorkers is the maximum number of EXPLAIN statements executing concurrently for a logger.
+	explainWorkers = 1
+
+	// explainFingerprints is the maximum number of fingerprints remembered by a logger for rate limiting.
+	explainFingerprints = 1024
+)
+
+// ExplainRow represents a node of ExplainPlan, which is parsed from different dialects' EXPLAIN results.
+type ExplainRow struct {
+	Id     int     `json:"id"`     // node id, or select id in mysql
+	Parent int     `json:"parent"` // parent node id, 0 if the node has no parent
+	Table  string  `json:"table"`  // accessed table name
+	Type   string  `json:"type"`   // access type, such as "ALL" and "ref" in mysql, "Seq Scan" in postgres, "SCAN" and "SEARCH" in sqlite3
+	Key    string  `json:"key"`    // used index name
+	Rows   float64 `json:"rows"`   // estimated rows, not supported in sqlite3
+	Detail string  `json:"detail"` // extra detail message
+}
+
+// ExplainPlan represents a parsed query plan of a statement, captured by the logger configured with WithExplain.
+type ExplainPlan struct {
+	Dialect string        `json:"dialect"`
+	Rows    []*ExplainRow `json:"rows"`
+}
+
+// String returns the compact string of ExplainPlan, such as "tbl_a:ALL(rows=100), tbl_b:ref[idx_b](rows=1)".
+func (e *ExplainPlan) String() string {
+	sp := make([]string, 0, len(e.Rows))
+	for _, row := range e.Rows {
+		s := row.Type
+		if row.Table != "" {
+			s = row.Table + ":" + s
+		}
+		if row.Key != "" {
+			s += "[" + row.Key + "]"
+		}
+		if row.Rows > 0 {
+			s += fmt.Sprintf("(rows=%v)", row.Rows)
+		}
+		sp = append(sp, s)
+	}
+	return strings.Join(sp, ", ")
+}
+
+// explainer represents the EXPLAIN executor used by logger, which executes EXPLAIN asynchronously with bounded workers, and rate limits by
+// sql fingerprint.
+type explainer struct {
+	db        *sql.DB
+	dialect   string
+	threshold time.Duration
+	interval  time.Duration
+	workers   chan struct{}               // semaphore of running workers
+	report    func(e *explainedStatement) // set by logger, used to log the plan
+
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List               // list of *explainedEntry, the front is the most recently seen
+	explains map[string]*list.Element // fingerprint -> element in lru
+}
+
+// explainedEntry represents an entry of explainer's lru.
+type explainedEntry struct {
+	fingerprint string
+	time        time.Time
+}
+
+// explainedStatement represents a statement which has been explained, passed to explainer's report function.
+type explainedStatement struct {
+	sql         string // rendered sql
+	fingerprint string
+	duration    time.Duration
+	plan        *ExplainPlan
+}
+
+// WithExplain returns a LoggerOption to run EXPLAIN for the "SQL" message whose duration is over given threshold, and log the parsed
+// ExplainPlan as a separate "[Gorm] [explain]" message with the `explain` and `fingerprint` fields. The EXPLAIN statement is executed
+// asynchronously on a separate connection of given gorm.DB's sql.DB, at most one at a time (statements that come while the EXPLAIN is
+// running are skipped), and the same statement (by fingerprint) will be explained at most once in the given interval (0 means only once,
+// and only the most recent 1024 fingerprints are remembered). Note that this is only used in LogrusLogger, only mysql, postgres and sqlite3
+// are supported, and this option does nothing when the gorm.DB is not backed by sql.DB, such as Resolver and sql.Tx.
+// Example:
+// 	db.SetLogger(xgorm.NewLogrusLogger(l, xgorm.WithExplain(db, 200*time.Millisecond, time.Hour)))
+func WithExplain(db *gorm.DB, threshold time.Duration, interval time.Duration) LoggerOption {
+	return func(o *loggerOptions) {
+		o.explain = nil
+		if db == nil || threshold <= 0 {
+			return
+		}
+		sqlDB, ok := db.CommonDB().(*sql.DB)
+		if !ok || sqlDB == nil {
+			return // such as Resolver
+		}
+		o.explain = &explainer{
+			db:        sqlDB,
+			dialect:   db.Dialect().GetName(),
+			threshold: threshold,
+			interval:  interval,
+			workers:   make(chan struct{}, explainWorkers),
+			capacity:  explainFingerprints,
+			lru:       list.New(),
+			explains:  make(map[string]*list.Element),
+		}
+	}
+}
+
+// allow checks if the statement with given fingerprint can be explained now, and records the explained time. The least recently seen
+// fingerprint will be evicted when the number of fingerprints is over capacity.
+func (e *explainer) allow(fingerprint string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if elem, ok := e.explains[fingerprint]; ok {
+		e.lru.MoveToFront(elem)
+		entry := elem.Value.(*explainedEntry)
+		if e.interval <= 0 || now.Sub(entry.time) < e.interval {
+			return false
+		}
+		entry.time = now
+		return true
+	}
+	e.explains[fingerprint] = e.lru.PushFront(&explainedEntry{fingerprint: fingerprint, time: now})
+	for e.lru.Len() > e.capacity {
+		oldest := e.lru.Back()
+		e.lru.Remove(oldest)
+		delete(e.explains, oldest.Value.(*explainedEntry).fingerprint)
+	}
+	return true
+}
+
+// forget removes given fingerprint, used when the statement is skipped because of busy workers.
+func (e *explainer) forget(fingerprint string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if elem, ok := e.explains[fingerprint]; ok {
+		e.lru.Remove(elem)
+		delete(e.explains, fingerprint)
+	}
+}
+
+// submit checks if the statement needs to be explained, and executes EXPLAIN asynchronously if there is an idle worker, the result will be
+// passed to the report function. Returns true if the statement is submitted.
+func (e *explainer) submit(sql string, vars []interface{}, stmt *explainedStatement) bool {
+	if stmt.duration < e.threshold || !isExplainable(NormalizeSQL(sql)) || !e.allow(stmt.fingerprint) {
+		return false
+	}
+	select {
+	case e.workers <- struct{}{}:
+	default:
+		e.forget(stmt.fingerprint) // busy, can be explained next time
+		return false
+	}
+
+	vars = append(make([]interface{}, 0, len(vars)), vars...)
+	go func() {
+		defer func() { <-e.workers }()
+		if plan := e.explain(sql, vars); plan != nil && e.report != nil {
+			stmt.plan = plan
+			e.report(stmt)
+		}
+	}()
+	return true
+}
+
+// isExplainable checks if the normalized sql can be explained, that is dml statements.
+func isExplainable(normalized string) bool {
+	for _, prefix := range []string{"select ", "insert ", "update ", "delete ", "replace ", "with "} {
+		if strings.HasPrefix(normalized, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// explain executes EXPLAIN statement for given sql and vars, returns nil if the statement can't be explained.
+func (e *explainer) explain(sql string, vars []interface{}) *ExplainPlan {
+	var prefix string
+	switch e.dialect {
+	case "mysql":
+		prefix = "EXPLAIN "
+	case "postgres":
+		prefix = "EXPLAIN (FORMAT JSON) "
+	case "sqlite3":
+		prefix = "EXPLAIN QUERY PLAN "
+	default:
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+	rows, err := e.db.QueryContext(ctx, prefix+sql, vars...)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	records, err := scanStringRecords(rows)
+	if err != nil {
+		return nil
+	}
+
+	plan := &ExplainPlan{Dialect: e.dialect}
+	switch e.dialect {
+	case "mysql":
+		plan.Rows = parseMySQLExplain(records)
+	case "postgres":
+		plan.Rows = parsePostgresExplain(records)
+	case "sqlite3":
+		plan.Rows = parseSQLiteExplain(records)
+	}
+	return plan
+}
+
+// scanStringRecords scans all rows to string records, keyed by lower-cased column names.
+func scanStringRecords(rows *sql.Rows) ([]map[string]string, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, 0)
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		record := make(map[string]string, len(columns))
+		for i, col := range columns {
+			record[strings.ToLower(col)] = values[i].String
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// parseMySQLExplain parses mysql's traditional EXPLAIN result.
+func parseMySQLExplain(records []map[string]string) []*ExplainRow {
+	result := make([]*ExplainRow, 0, len(records))
+	for _, record := range records {
+		id, _ := strconv.Atoi(record["id"])
+		rows, _ := strconv.ParseFloat(record["rows"], 64)
+		result = append(result, &ExplainRow{
+			Id:     id,
+			Table:  record["table"],
+			Type:   record["type"],
+			Key:    record["key"],
+			Rows:   rows,
+			Detail: record["extra"],
+		})
+	}
+	return result
+}
+
+// postgresPlanNode represents a node of postgres's EXPLAIN (FORMAT JSON) result.
+type postgresPlanNode struct {
+	NodeType     string              `json:"Node Type"`
+	RelationName string              `json:"Relation Name"`
+	IndexName    string              `json:"Index Name"`
+	PlanRows     float64             `json:"Plan Rows"`
+	TotalCost    float64             `json:"Total Cost"`
+	Filter       string              `json:"Filter"`
+	Plans        []*postgresPlanNode `json:"Plans"`
+}
+
+// parsePostgresExplain parses postgres's EXPLAIN (FORMAT JSON) result, and flattens the plan tree in pre-order.
+func parsePostgresExplain(records []map[string]string) []*ExplainRow {
+	if len(records) == 0 {
+		return nil
+	}
+	var text string
+	for _, v := range records[0] {
+		text = v // only one column "QUERY PLAN"
+	}
+	plans := make([]struct {
+		Plan *postgresPlanNode `json:"Plan"`
+	}, 0)
+	if err := json.Unmarshal([]byte(text), &plans); err != nil || len(plans) == 0 || plans[0].Plan == nil {
+		return nil
+	}
+
+	result := make([]*ExplainRow, 0)
+	var walk func(node *postgresPlanNode, parent int)
+	walk = func(node *postgresPlanNode, parent int) {
+		row := &ExplainRow{
+			Id:     len(result) + 1,
+			Parent: parent,
+			Table:  node.RelationName,
+			Type:   node.NodeType,
+			Key:    node.IndexName,
+			Rows:   node.PlanRows,
+			Detail: fmt.Sprintf("cost=%v", node.TotalCost),
+		}
+		if node.Filter != "" {
+			row.Detail += fmt.Sprintf(" filter=%s", node.Filter)
+		}
+		result = append(result, row)
+		for _, child := range node.Plans {
+			walk(child, row.Id)
+		}
+	}
+	walk(plans[0].Plan, 0)
+	return result
+}
+
+// parseSQLiteExplain parses sqlite's EXPLAIN QUERY PLAN result, the detail is like "SEARCH users USING INDEX uk_name (name=?)" or "SCAN TABLE users".
+func parseSQLiteExplain(records []map[string]string) []*ExplainRow {
+	result := make([]*ExplainRow, 0, len(records))
+	for _, record := range records {
+		id, _ := strconv.Atoi(record["id"])
+		parent, _ := strconv.Atoi(record["parent"])
+		detail := record["detail"]
+		row := &ExplainRow{Id: id, Parent: parent, Detail: detail}
+
+		words := strings.Fields(detail)
+		if len(words) > 0 {
+			row.Type = words[0]
+		}
+		if len(words) > 1 && (row.Type == "SCAN" || row.Type == "SEARCH") {
+			row.Table = words[1]
+			if strings.EqualFold(row.Table, "TABLE") && len(words) > 2 {
+				row.Table = words[2] // old version format
+			}
+		}
+		for _, marker := range []string{"USING COVERING INDEX ", "USING INDEX "} {
+			if idx := strings.Index(detail, marker); idx != -1 {
+				if key := strings.Fields(detail[idx+len(marker):]); len(key) > 0 {
+					row.Key = key[0]
+				}
+				break
+			}
+		}
+		if row.Key == "" && strings.Contains(detail, "USING INTEGER PRIMARY KEY") {
+			row.Key = "PRIMARY KEY"
+		}
+		result = append(result, row)
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package xgorm
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+// some regexps used in NormalizeSQL.
+var (
+	_inListRegexp      = regexp.MustCompile(`\bin ?\(\?(?:, \?)*\)`)
+	_valuesListRegexp  = regexp.MustCompile(`(\(\?(?:, \?)*\))(?:, \(\?(?:, \?)*\))+`)
+	_spaceBeforeRegexp = regexp.MustCompile(` ([,)])`)
+	_spaceAfterRegexp  = regexp.MustCompile(`([(]) `)
+)
+
+// isIdentByte is a byte util function used in NormalizeSQL.
+func isIdentByte(b byte) bool {
+	return b == '_' || b == '$' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b >= 0x80
+}
+
+// isDigitByte is a byte util function used in NormalizeSQL.
+func isDigitByte(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// NormalizeSQL normalizes given sql string to a stable statement shape, that is:
+//
+// 1. "--" and "/* */" comments are removed, and each run of whitespaces is collapsed to a single space;
+//
+// 2. single-quoted string literals, hex literals (x'..' and 0x..) and number literals are replaced with "?", note that the sign of a
+// negative number is kept (such as "-?"), TRUE, FALSE and NULL are not replaced, and "''" is always an escaped quote in string literal,
+// while "\" is an escape character only in MySQL;
+//
+// 3. "$n" placeholders are replaced with "?";
+//
+// 4. all the words out of quotes (including keywords, identifiers and functions) are lower-cased, while "`" and '"' quoted parts are kept
+// unchanged, note that '"' quoted part is always regarded as an identifier, even if it is a string literal in MySQL;
+//
+// 5. spaces after "(" and before "," and ")" are removed, and one space is kept after ",", while spaces around operators are not changed;
+//
+// 6. "in (?, ?, ?)" is collapsed to "in (?+)", and a list of two or more "(?, ?)" tuples (such as multi-rows VALUES) is collapsed to
+// "(?, ?), ...", keeping the first tuple.
+//
+// The dialect is detected from the sql, that is "$n" placeholders for postgres, "`" quoted identifiers for mysql, and '"' quoted identifiers
+// with "?" placeholders for sqlite3, and the sql whose dialect can not be detected is regarded as standard sql, in which "\" is not an
+// escape character. Use NormalizeDialectSQL if the dialect is known.
+// Example:
+// 	NormalizeSQL("SELECT * FROM `tbl`  WHERE id IN (1, 2, 3) AND name = 'a'") // select * from `tbl` where id in (?+) and name = ?
+// 	NormalizeSQL(`SELECT * FROM "tbl" WHERE id = $1 AND age > $2`)            // select * from "tbl" where id = ? and age > ?
+func NormalizeSQL(sql string) string {
+	return NormalizeDialectSQL(sql, detectDialect(sql))
+}
+
+// NormalizeDialectSQL normalizes given sql string of given dialect to a stable statement shape, see NormalizeSQL for details, note that "\"
+// in string literal is regarded as an escape character only for mysql dialect.
+func NormalizeDialectSQL(sql, dialect string) string {
+	backslash := dialect == "mysql"
+	sb := strings.Builder{}
+	sb.Grow(len(sql))
+	space := false // pending whitespace
+	write := func(s string) {
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteString(s)
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			// whitespace
+			space = true
+			i++
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			// block comment
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += 2 + end + 2
+			}
+			space = true
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			// line comment
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end + 1
+			}
+			space = true
+		case c == '\'':
+			// string literal, with '' escaped, and \' escaped in mysql
+			i++
+			for i < len(sql) {
+				if backslash && sql[i] == '\\' {
+					i += 2
+					continue
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			write("?")
+		case c == '"' || c == '`':
+			// quoted identifier
+			end := strings.IndexByte(sql[i+1:], c)
+			if end == -1 {
+				end = len(sql) - i - 1
+			} else {
+				end++
+			}
+			write(sql[i : i+end+1])
+			i += end + 1
+		case c == '$' && i+1 < len(sql) && isDigitByte(sql[i+1]) && (i == 0 || !isIdentByte(sql[i-1])):
+			// numeric placeholder
+			i++
+			for i < len(sql) && isDigitByte(sql[i]) {
+				i++
+			}
+			write("?")
+		case isDigitByte(c) || (c == '.' && i+1 < len(sql) && isDigitByte(sql[i+1])):
+			// number literal, including hex, decimal and exponent
+			if c == '0' && i+1 < len(sql) && (sql[i+1] == 'x' || sql[i+1] == 'X') {
+				i += 2
+			}
+			for i < len(sql) && (isIdentByte(sql[i]) || sql[i] == '.') {
+				if (sql[i] == 'e' || sql[i] == 'E') && i+1 < len(sql) && (sql[i+1] == '+' || sql[i+1] == '-') {
+					i++
+				}
+				i++
+			}
+			write("?")
+		case (c == 'x' || c == 'X') && i+1 < len(sql) && sql[i+1] == '\'' && (i == 0 || !isIdentByte(sql[i-1])):
+			// hex literal, skip the prefix and treat as string literal
+			i++
+		case isIdentByte(c):
+			// keyword or identifier
+			start := i
+			for i < len(sql) && isIdentByte(sql[i]) {
+				i++
+			}
+			write(strings.ToLower(sql[start:i]))
+		default:
+			// operator or punctuation
+			write(string(c))
+			i++
+		}
+	}
+
+	result := sb.String()
+	result = _spaceBeforeRegexp.ReplaceAllString(result, "$1")
+	result = _spaceAfterRegexp.ReplaceAllString(result, "$1")
+	result = strings.ReplaceAll(result, ",", ", ")
+	result = strings.ReplaceAll(result, ",  ", ", ")
+	result = _inListRegexp.ReplaceAllString(result, "in (?+)")
+	result = _valuesListRegexp.ReplaceAllString(result, "$1, ...")
+	return result
+}
+
+// FingerprintSQL returns the fingerprint of given sql string, which is the 64-bit FNV-1a hash (in hex) of the normalized sql, see
+// NormalizeSQL for details.
+func FingerprintSQL(sql string) string {
+	return FingerprintDialectSQL(sql, detectDialect(sql))
+}
+
+// FingerprintDialectSQL returns the fingerprint of given sql string of given dialect, see FingerprintSQL and NormalizeDialectSQL for details.
+func FingerprintDialectSQL(sql, dialect string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(NormalizeDialectSQL(sql, dialect)))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
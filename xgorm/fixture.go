@@ -0,0 +1,524 @@
+package xgorm
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// _fixtureActionRegexp is the regexp of fixture template action, such as "{{ now }}", "{{ seq }}" and "{{ ref user1 uid }}".
+var _fixtureActionRegexp = regexp.MustCompile(`{{\s*([a-z]+)((?:\s+[^\s}]+)*)\s*}}`)
+
+// fixtureTimeLayouts is the time layouts used to parse time string to time.Time field.
+var fixtureTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// fixtureRow represents a row in fixture file.
+type fixtureRow struct {
+	table   *fixtureTable
+	label   string
+	columns []string
+	values  []interface{}
+	refs    []string               // referenced labels
+	result  map[string]interface{} // inserted values, including back-filled primary key
+}
+
+// fixtureTable represents a table in fixture file, which is keyed by table name or model name.
+type fixtureTable struct {
+	name      string
+	modelType reflect.Type // nil if no model registered
+	rows      []*fixtureRow
+}
+
+// fixturesOptions represents some options for Fixtures, set by FixturesOption.
+type fixturesOptions struct {
+	models []interface{}
+}
+
+// FixturesOption represents an option for Fixtures, created by WithFixtureXXX functions.
+type FixturesOption func(*fixturesOptions)
+
+// WithFixtureModels returns a FixturesOption with model types, the rows of these models' tables (keyed by table name or model struct
+// name) will be inserted by gorm's Create, so the callbacks and default values will be applied. Rows of other tables will be inserted
+// by raw INSERT statement.
+func WithFixtureModels(models ...interface{}) FixturesOption {
+	return func(o *fixturesOptions) {
+		o.models = append(o.models, models...)
+	}
+}
+
+// Fixtures represents a fixture and seed loader, which reads YAML or JSON fixture files keyed by table name or model name, and inserts
+// rows in dependency order with foreign key checks temporarily disabled.
+//
+// The fixture file is in the following format, where the rows can be labeled (in mapping) or not (in sequence):
+// 	users:                        # table name or model name
+// 	  user1:                      # row label
+// 	    name: "user{{ seq }}"     # sequence per table, starts from 1
+// 	    created_at: "{{ now }}"   # load time, can be with offset such as "{{ now -24h }}"
+// 	orders:
+// 	  - user_id: "{{ ref user1 }}"               # primary key of referenced row, label can be "users.user1"
+// 	    user_name: "{{ ref user1 name }}"        # column of referenced row
+//
+// Note that the value which is only a template action will be replaced in its original type, such as "{{ now }}" to time.Time, and the
+// value which contains template actions will be rendered to string.
+type Fixtures struct {
+	db      *gorm.DB
+	options *fixturesOptions
+	models  map[string]reflect.Type // table name or model name -> model type
+	tables  []*fixtureTable
+	labels  map[string][]*fixtureRow // label or "table.label" -> rows
+}
+
+// NewFixtures creates a new Fixtures with given gorm.DB and FixturesOption-s.
+// Example:
+// 	fixtures := xgorm.NewFixtures(db, xgorm.WithFixtureModels(&User{}, &Order{}))
+// 	_ = fixtures.AddFiles("testdata/users.yml", "testdata/orders.json")
+// 	err := fixtures.Load() // reset tables and insert rows, invoked before each test
+// 	uid, _ := fixtures.Value("user1", "uid")
+func NewFixtures(db *gorm.DB, options ...FixturesOption) *Fixtures {
+	opt := &fixturesOptions{}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	models := make(map[string]reflect.Type)
+	for _, model := range opt.models {
+		typ := reflect.TypeOf(model)
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+			typ = typ.Elem()
+		}
+		models[typ.Name()] = typ
+		models[db.NewScope(reflect.New(typ).Interface()).TableName()] = typ
+	}
+	return &Fixtures{db: db, options: opt, models: models, labels: make(map[string][]*fixtureRow)}
+}
+
+// AddFiles reads and parses given YAML or JSON fixture files.
+func (f *Fixtures) AddFiles(files ...string) error {
+	for _, file := range files {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := f.AddBytes(bs); err != nil {
+			return fmt.Errorf("xgorm: failed to parse fixture file %s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// AddBytes parses given YAML or JSON fixture content.
+func (f *Fixtures) AddBytes(data []byte) error {
+	document := yaml.MapSlice{} // JSON is also valid YAML
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+	for _, tableItem := range document {
+		name := fmt.Sprint(tableItem.Key)
+		table := f.table(name)
+		switch rows := tableItem.Value.(type) {
+		case yaml.MapSlice:
+			for _, rowItem := range rows {
+				if err := f.addRow(table, fmt.Sprint(rowItem.Key), rowItem.Value); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, row := range rows {
+				if err := f.addRow(table, fmt.Sprintf("%s_%d", name, len(table.rows)+1), row); err != nil {
+					return err
+				}
+			}
+		case nil:
+		default:
+			return fmt.Errorf("xgorm: invalid rows of fixture table %s", name)
+		}
+	}
+	return nil
+}
+
+// table returns the fixtureTable by table name or model name, creates a new one if not found.
+func (f *Fixtures) table(name string) *fixtureTable {
+	modelType := f.models[name]
+	if modelType != nil {
+		name = f.db.NewScope(reflect.New(modelType).Interface()).TableName()
+	}
+	for _, table := range f.tables {
+		if table.name == name {
+			return table
+		}
+	}
+	table := &fixtureTable{name: name, modelType: modelType}
+	f.tables = append(f.tables, table)
+	return table
+}
+
+// addRow parses and adds a row to fixtureTable.
+func (f *Fixtures) addRow(table *fixtureTable, label string, value interface{}) error {
+	columns, ok := value.(yaml.MapSlice)
+	if !ok {
+		return fmt.Errorf("xgorm: invalid fixture row %s.%s", table.name, label)
+	}
+	row := &fixtureRow{table: table, label: label}
+	for _, column := range columns {
+		row.columns = append(row.columns, fmt.Sprint(column.Key))
+		row.values = append(row.values, column.Value)
+		str, ok := column.Value.(string)
+		if !ok {
+			continue
+		}
+		for _, match := range _fixtureActionRegexp.FindAllStringSubmatch(str, -1) {
+			args := strings.Fields(match[2])
+			switch match[1] {
+			case "now", "seq":
+			case "ref":
+				if len(args) == 0 {
+					return fmt.Errorf("xgorm: missing label in ref action of fixture row %s.%s", table.name, label)
+				}
+				row.refs = append(row.refs, args[0])
+			default:
+				return fmt.Errorf("xgorm: unknown action %s in fixture row %s.%s", match[1], table.name, label)
+			}
+		}
+	}
+	table.rows = append(table.rows, row)
+	f.labels[label] = append(f.labels[label], row)
+	f.labels[table.name+"."+label] = append(f.labels[table.name+"."+label], row)
+	return nil
+}
+
+// find finds the row by label or "table.label".
+func (f *Fixtures) find(label string) (*fixtureRow, error) {
+	rows := f.labels[label]
+	switch len(rows) {
+	case 0:
+		return nil, fmt.Errorf("xgorm: fixture row %s not found", label)
+	case 1:
+		return rows[0], nil
+	default:
+		return nil, fmt.Errorf("xgorm: ambiguous fixture row label %s, use table.label instead", label)
+	}
+}
+
+// sortRows sorts all rows in dependency order, in which the referenced rows are inserted just before the first row referencing them,
+// and other rows keep the file order.
+func (f *Fixtures) sortRows() ([]*fixtureRow, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	sorted := make([]*fixtureRow, 0)
+	states := make(map[*fixtureRow]int)
+	var visit func(row *fixtureRow) error
+	visit = func(row *fixtureRow) error {
+		switch states[row] {
+		case visiting:
+			return fmt.Errorf("xgorm: circular reference found in fixture row %s.%s", row.table.name, row.label)
+		case visited:
+			return nil
+		}
+		states[row] = visiting
+		for _, ref := range row.refs {
+			dep, err := f.find(ref)
+			if err != nil {
+				return err
+			}
+			if err = visit(dep); err != nil {
+				return err
+			}
+		}
+		states[row] = visited
+		sorted = append(sorted, row)
+		return nil
+	}
+
+	for _, table := range f.tables {
+		for _, row := range table.rows {
+			if err := visit(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sorted, nil
+}
+
+// primaryKey returns the primary key column name of fixtureTable, defaults to "id" if no model registered.
+func (f *Fixtures) primaryKey(table *fixtureTable) string {
+	if table.modelType == nil {
+		return "id"
+	}
+	return f.db.NewScope(reflect.New(table.modelType).Interface()).PrimaryKey()
+}
+
+// fixtureContext represents the context when loading fixtures, used to evaluate template actions.
+type fixtureContext struct {
+	f         *Fixtures
+	now       time.Time
+	sequences map[string]int64
+}
+
+// evaluate evaluates the template actions in given value.
+func (c *fixtureContext) evaluate(row *fixtureRow, value interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return value, nil
+	}
+	matches := _fixtureActionRegexp.FindAllStringSubmatchIndex(str, -1)
+	if len(matches) == 0 {
+		return value, nil
+	}
+
+	sb := strings.Builder{}
+	last := 0
+	for _, match := range matches {
+		action, args := str[match[2]:match[3]], strings.Fields(str[match[4]:match[5]])
+		var result interface{}
+		switch action {
+		case "now":
+			result = c.now
+			if len(args) > 0 {
+				offset, err := time.ParseDuration(args[0])
+				if err != nil {
+					return nil, fmt.Errorf("xgorm: invalid offset in now action of fixture row %s.%s: %w", row.table.name, row.label, err)
+				}
+				result = c.now.Add(offset)
+			}
+		case "seq":
+			name := row.table.name
+			if len(args) > 0 {
+				name = args[0]
+			}
+			c.sequences[name]++
+			result = c.sequences[name]
+		case "ref":
+			ref, _ := c.f.find(args[0]) // checked in sortRows
+			column := c.f.primaryKey(ref.table)
+			if len(args) > 1 {
+				column = args[1]
+			}
+			result, ok = ref.result[column]
+			if !ok {
+				return nil, fmt.Errorf("xgorm: column %s of fixture row %s not found", column, args[0])
+			}
+		}
+		if match[0] == 0 && match[1] == len(str) {
+			return result, nil // only an action, keep type
+		}
+		sb.WriteString(str[last:match[0]])
+		if t, ok := result.(time.Time); ok {
+			sb.WriteString(t.Format(time.RFC3339Nano))
+		} else {
+			sb.WriteString(fmt.Sprint(result))
+		}
+		last = match[1]
+	}
+	sb.WriteString(str[last:])
+	return sb.String(), nil
+}
+
+// Load resets the fixture tables, and inserts all rows in dependency order in a transaction, with foreign key checks disabled (SET
+// FOREIGN_KEY_CHECKS in mysql, DISABLE TRIGGER ALL in postgres, and PRAGMA defer_foreign_keys in sqlite3). Note that in postgres, the
+// connected role must be superuser, because disabling the internally generated foreign key triggers requires superuser privileges.
+func (f *Fixtures) Load() error {
+	rows, err := f.sortRows()
+	if err != nil {
+		return err
+	}
+	return f.transaction(func(tx *gorm.DB) error {
+		if err := f.reset(tx); err != nil {
+			return err
+		}
+		ctx := &fixtureContext{f: f, now: time.Now(), sequences: make(map[string]int64)}
+		for _, row := range rows {
+			if err := f.insert(tx, ctx, row); err != nil {
+				return fmt.Errorf("xgorm: failed to insert fixture row %s.%s: %w", row.table.name, row.label, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Reset deletes all rows of the fixture tables in a transaction, with foreign key checks disabled.
+func (f *Fixtures) Reset() error {
+	return f.transaction(f.reset)
+}
+
+// reset deletes all rows of the fixture tables.
+func (f *Fixtures) reset(tx *gorm.DB) error {
+	for i := len(f.tables) - 1; i >= 0; i-- {
+		if err := tx.Exec(fmt.Sprintf("DELETE FROM %s", tx.Dialect().Quote(f.tables[i].name))).Error; err != nil {
+			return err
+		}
+		for _, row := range f.tables[i].rows {
+			row.result = nil
+		}
+	}
+	return nil
+}
+
+// transaction runs given function in a transaction with foreign key checks disabled, and the foreign key checks will be enabled again
+// on every path, including failure and panic.
+func (f *Fixtures) transaction(fn func(tx *gorm.DB) error) (err error) {
+	tx := f.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	var disables, enables []string
+	sessional := false // session state, which survives ROLLBACK and must be restored before releasing the connection
+	switch {
+	case IsMySQL(f.db):
+		disables, enables = []string{"SET FOREIGN_KEY_CHECKS = 0"}, []string{"SET FOREIGN_KEY_CHECKS = 1"}
+		sessional = true
+	case IsPostgreSQL(f.db):
+		for _, table := range f.tables {
+			disables = append(disables, fmt.Sprintf("ALTER TABLE %s DISABLE TRIGGER ALL", tx.Dialect().Quote(table.name)))
+			enables = append(enables, fmt.Sprintf("ALTER TABLE %s ENABLE TRIGGER ALL", tx.Dialect().Quote(table.name)))
+		}
+	case IsSQLite(f.db):
+		disables = []string{"PRAGMA defer_foreign_keys = ON"} // reset automatically when committing or rolling back
+	}
+
+	enabled, committed := false, false
+	defer func() {
+		if committed {
+			return
+		}
+		if sessional && !enabled {
+			for _, statement := range enables {
+				tx.Exec(statement)
+			}
+		}
+		tx.Rollback()
+	}()
+
+	for _, statement := range disables {
+		if err = tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	if err = fn(tx); err != nil {
+		return err
+	}
+	for _, statement := range enables {
+		if err = tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	enabled = true
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// insert evaluates and inserts a row, and records the inserted values.
+func (f *Fixtures) insert(tx *gorm.DB, ctx *fixtureContext, row *fixtureRow) error {
+	values := make([]interface{}, len(row.values))
+	for i, value := range row.values {
+		evaluated, err := ctx.evaluate(row, value)
+		if err != nil {
+			return err
+		}
+		values[i] = evaluated
+	}
+	if row.table.modelType != nil {
+		return f.insertModel(tx, row, values)
+	}
+	return f.insertRaw(tx, row, values)
+}
+
+// insertModel inserts a row using gorm's Create, and records all the field values.
+func (f *Fixtures) insertModel(tx *gorm.DB, row *fixtureRow, values []interface{}) error {
+	model := reflect.New(row.table.modelType).Interface()
+	scope := tx.NewScope(model)
+	for i, column := range row.columns {
+		field, ok := scope.FieldByName(column)
+		if !ok {
+			return fmt.Errorf("xgorm: unknown column %s", column)
+		}
+		value := values[i]
+		if s, ok := value.(string); ok && reflect.Indirect(field.Field).Type() == reflect.TypeOf(time.Time{}) {
+			for _, layout := range fixtureTimeLayouts {
+				if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+					value = t
+					break
+				}
+			}
+		}
+		if err := field.Set(value); err != nil {
+			return err
+		}
+	}
+	if err := tx.Create(model).Error; err != nil {
+		return err
+	}
+
+	row.result = make(map[string]interface{})
+	for _, field := range tx.NewScope(model).Fields() {
+		if field.IsNormal && !field.IsIgnored {
+			row.result[field.DBName] = field.Field.Interface()
+		}
+	}
+	return nil
+}
+
+// insertRaw inserts a row using raw INSERT statement, and records the values with back-filled "id" column.
+func (f *Fixtures) insertRaw(tx *gorm.DB, row *fixtureRow, values []interface{}) error {
+	dialect := tx.Dialect()
+	columns := make([]string, 0, len(row.columns))
+	placeholders := make([]string, 0, len(row.columns))
+	row.result = make(map[string]interface{})
+	for i, column := range row.columns {
+		columns = append(columns, dialect.Quote(column))
+		placeholders = append(placeholders, "?")
+		row.result[column] = values[i]
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", dialect.Quote(row.table.name), strings.Join(columns, ","), strings.Join(placeholders, ","))
+	if len(columns) == 0 {
+		query = fmt.Sprintf("INSERT INTO %s %s", dialect.Quote(row.table.name), dialect.DefaultValueStr())
+	}
+
+	if _, ok := row.result["id"]; ok {
+		return tx.Exec(query, values...).Error
+	}
+	if IsPostgreSQL(tx) {
+		if !dialect.HasColumn(row.table.name, "id") {
+			return tx.Exec(query, values...).Error
+		}
+		var id int64 // postgres does not support LastInsertId
+		if err := tx.Raw(query+" RETURNING "+dialect.Quote("id"), values...).Row().Scan(&id); err != nil {
+			return err
+		}
+		row.result["id"] = id
+		return nil
+	}
+	result, err := tx.CommonDB().Exec(query, values...)
+	if err != nil {
+		return err
+	}
+	if id, err := result.LastInsertId(); err == nil && id > 0 {
+		row.result["id"] = id
+	}
+	return nil
+}
+
+// Value returns the inserted value of given row label (or "table.label") and column, empty column means the primary key. Note that
+// this method only works after Load.
+func (f *Fixtures) Value(label string, column string) (interface{}, bool) {
+	row, err := f.find(label)
+	if err != nil || row.result == nil {
+		return nil, false
+	}
+	if column == "" {
+		column = f.primaryKey(row.table)
+	}
+	value, ok := row.result[column]
+	return value, ok
+}
@@ -32,6 +32,11 @@ const (
 	MySQLDuplicateEntryErrno       = 1062      // MySQLDuplicateEntryErrno is MySQL's ER_DUP_ENTRY errno.
 	SQLiteUniqueConstraintErrno    = 19 | 8<<8 // SQLiteUniqueConstraintErrno is SQLite's CONSTRAINT_UNIQUE extended errno.
 	PostgreSQLUniqueViolationErrno = "23505"   // PostgreSQLUniqueViolationErrno is PostgreSQL's unique_violation errno.
+
+	MySQLLockWaitTimeoutErrno       = 1205    // MySQLLockWaitTimeoutErrno is MySQL's ER_LOCK_WAIT_TIMEOUT errno.
+	MySQLLockNoWaitErrno            = 3572    // MySQLLockNoWaitErrno is MySQL's ER_LOCK_NOWAIT errno.
+	SQLiteBusyErrno                 = 5       // SQLiteBusyErrno is SQLite's SQLITE_BUSY errno.
+	PostgreSQLLockNotAvailableErrno = "55P03" // PostgreSQLLockNotAvailableErrno is PostgreSQL's lock_not_available errno.
 )
 
 // IsMySQLDuplicateEntryError checks if err is MySQL's ER_DUP_ENTRY error.
@@ -46,20 +51,24 @@ func IsPostgreSQLUniqueViolationError(err error) bool {
 	return ok && postgresErr.Code == PostgreSQLUniqueViolationErrno
 }
 
-// QueryErr checks gorm.DB query result, will only return xstatus.DbNotFound, xstatus.DbFailed and xstatus.DbSuccess.
+// QueryErr checks gorm.DB query result, will only return xstatus.DbNotFound, LockUnavailable, xstatus.DbFailed and xstatus.DbSuccess.
 func QueryErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	switch {
 	case rdb.RecordNotFound():
 		return xstatus.DbNotFound, nil // not found
+	case isLockError(rdb):
+		return LockUnavailable, rdb.Error // lock
 	case rdb.Error != nil:
 		return xstatus.DbFailed, rdb.Error // failed
 	}
 	return xstatus.DbSuccess, nil
 }
 
-// DeleteErr checks gorm.DB delete result, will only return xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
+// DeleteErr checks gorm.DB delete result, will only return LockUnavailable, xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
 func DeleteErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	switch {
+	case isLockError(rdb):
+		return LockUnavailable, rdb.Error // lock
 	case rdb.Error != nil:
 		return xstatus.DbFailed, rdb.Error // failed
 	case rdb.RowsAffected == 0:
@@ -6,6 +6,7 @@ import (
 	"github.com/Aoi-hosizora/ahlib/xstatus"
 	"github.com/jinzhu/gorm"
 	"github.com/mattn/go-sqlite3"
+	"strconv"
 )
 
 // IsSQLiteUniqueConstraintError checks if err is SQLite's ErrConstraintUnique error.
@@ -14,26 +15,36 @@ func IsSQLiteUniqueConstraintError(err error) bool {
 	return ok && sqliteErr.ExtendedCode == SQLiteUniqueConstraintErrno
 }
 
-// CreateErr checks gorm.DB create result, will only return xstatus.DbExisted, xstatus.DbFailed and xstatus.DbSuccess.
+// IsSQLiteBusyError checks if err is SQLite's SQLITE_BUSY error, which is returned when the database is locked and busy timeout exceeds.
+func IsSQLiteBusyError(err error) bool {
+	sqliteErr, ok := err.(sqlite3.Error)
+	return ok && sqliteErr.Code == SQLiteBusyErrno
+}
+
+// CreateErr checks gorm.DB create result, will only return xstatus.DbExisted, LockUnavailable, xstatus.DbFailed and xstatus.DbSuccess.
 func CreateErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	switch {
 	case IsMySQL(rdb) && IsMySQLDuplicateEntryError(rdb.Error),
 		IsSQLite(rdb) && IsSQLiteUniqueConstraintError(rdb.Error),
 		IsPostgreSQL(rdb) && IsPostgreSQLUniqueViolationError(rdb.Error):
 		return xstatus.DbExisted, rdb.Error // duplicate
+	case isLockError(rdb):
+		return LockUnavailable, rdb.Error // lock
 	case rdb.Error != nil:
 		return xstatus.DbFailed, rdb.Error // failed
 	}
 	return xstatus.DbSuccess, nil
 }
 
-// UpdateErr checks gorm.DB update result, will only return xstatus.DbExisted, xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
+// UpdateErr checks gorm.DB update result, will only return xstatus.DbExisted, LockUnavailable, xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
 func UpdateErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	switch {
 	case IsMySQL(rdb) && IsMySQLDuplicateEntryError(rdb.Error),
 		IsSQLite(rdb) && IsSQLiteUniqueConstraintError(rdb.Error),
 		IsPostgreSQL(rdb) && IsPostgreSQLUniqueViolationError(rdb.Error):
 		return xstatus.DbExisted, rdb.Error // duplicate
+	case isLockError(rdb):
+		return LockUnavailable, rdb.Error // lock
 	case rdb.Error != nil:
 		return xstatus.DbFailed, rdb.Error // failed
 	case rdb.RowsAffected == 0:
@@ -41,3 +52,21 @@ func UpdateErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	}
 	return xstatus.DbSuccess, nil
 }
+
+// sqliteErrorCode returns the extended errno string of SQLite's error, used in Recorder.
+func sqliteErrorCode(err error) (string, bool) {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return "", false
+	}
+	return strconv.Itoa(int(sqliteErr.ExtendedCode)), true
+}
+
+// newSQLiteError creates SQLite's error from extended errno string, used in Recorder. Note that the error message can not be restored.
+func newSQLiteError(code string) (error, bool) {
+	errno, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, false
+	}
+	return sqlite3.Error{Code: sqlite3.ErrNo(errno & 0xff), ExtendedCode: sqlite3.ErrNoExtended(errno)}, true
+}
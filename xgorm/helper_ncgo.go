@@ -7,24 +7,33 @@ import (
 	"github.com/jinzhu/gorm"
 )
 
-// CreateErr checks gorm.DB create result, will only return xstatus.DbExisted, xstatus.DbFailed and xstatus.DbSuccess.
+// IsSQLiteBusyError always returns false when cgo is disabled.
+func IsSQLiteBusyError(error) bool {
+	return false
+}
+
+// CreateErr checks gorm.DB create result, will only return xstatus.DbExisted, LockUnavailable, xstatus.DbFailed and xstatus.DbSuccess.
 func CreateErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	switch {
 	case IsMySQL(rdb) && IsMySQLDuplicateEntryError(rdb.Error),
 		IsPostgreSQL(rdb) && IsPostgreSQLUniqueViolationError(rdb.Error):
 		return xstatus.DbExisted, rdb.Error // duplicate
+	case isLockError(rdb):
+		return LockUnavailable, rdb.Error // lock
 	case rdb.Error != nil:
 		return xstatus.DbFailed, rdb.Error // failed
 	}
 	return xstatus.DbSuccess, nil
 }
 
-// UpdateErr checks gorm.DB update result, will only return xstatus.DbExisted, xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
+// UpdateErr checks gorm.DB update result, will only return xstatus.DbExisted, LockUnavailable, xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
 func UpdateErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	switch {
 	case IsMySQL(rdb) && IsMySQLDuplicateEntryError(rdb.Error),
 		IsPostgreSQL(rdb) && IsPostgreSQLUniqueViolationError(rdb.Error):
 		return xstatus.DbExisted, rdb.Error // duplicate
+	case isLockError(rdb):
+		return LockUnavailable, rdb.Error // lock
 	case rdb.Error != nil:
 		return xstatus.DbFailed, rdb.Error // failed
 	case rdb.RowsAffected == 0:
@@ -32,3 +41,13 @@ func UpdateErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
 	}
 	return xstatus.DbSuccess, nil
 }
+
+// sqliteErrorCode always returns false when cgo is disabled, used in Recorder.
+func sqliteErrorCode(error) (string, bool) {
+	return "", false
+}
+
+// newSQLiteError always returns false when cgo is disabled, used in Recorder.
+func newSQLiteError(string) (error, bool) {
+	return nil, false
+}
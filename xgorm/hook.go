@@ -1,6 +1,7 @@
 package xgorm
 
 import (
+	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
 	"strings"
@@ -122,3 +123,132 @@ func deletedAtDeleteCallback(deletedAtTimestamp string) func(scope *gorm.Scope)
 		}
 	}
 }
+
+const (
+	// tenantIdColumnName represents the column name of tenant field.
+	tenantIdColumnName = "tenant_id"
+
+	// tenantIdKey is the gorm.DB setting key for tenant id, set by WithTenant.
+	tenantIdKey = "xgorm:tenant_id"
+
+	// tenantUnscopedKey is the gorm.DB setting key for escaping tenant scoping, set by UnscopedTenant.
+	tenantUnscopedKey = "xgorm:tenant_unscoped"
+)
+
+// ErrMissingTenant represents an error when executing a tenanted query without providing any tenant id, returned by callbacks in HookTenant.
+var ErrMissingTenant = errors.New("xgorm: missing tenant id for tenanted model")
+
+// WithTenant returns a new gorm.DB with given tenant id, which will be used by the tenant callbacks registered by HookTenant.
+// Example:
+// 	tdb := xgorm.WithTenant(db, 1)
+// 	tdb.Model(&Order{}).Where("status = ?", 2).Find(&orders) // ... WHERE `tbl_order`.`tenant_id` = 1 AND (status = 2)
+func WithTenant(db *gorm.DB, tenantId interface{}) *gorm.DB {
+	return db.Set(tenantIdKey, tenantId)
+}
+
+// UnscopedTenant returns a new gorm.DB which escapes the tenant scoping, that is the tenant callbacks registered by HookTenant will do nothing.
+func UnscopedTenant(db *gorm.DB) *gorm.DB {
+	return db.Set(tenantUnscopedKey, true)
+}
+
+// HookTenant hooks gorm.DB to inject `tenant_id = ?` condition (including query, row_query, update, delete) and to set tenant field (create)
+// for models which have a "tenant_id" field. Note that these callbacks will return ErrMissingTenant when no tenant id is provided by WithTenant,
+// you can use UnscopedTenant to escape this restriction. For DB.Row and DB.Rows (also Count and Pluck), the row query will not be executed,
+// and the error will be returned by DB.Rows or sql.Row's Scan method.
+func HookTenant(db *gorm.DB) *gorm.DB {
+	// query
+	db.Callback().Query().
+		Before("gorm:query").
+		Register("tenant_before_query_callback", tenantQueryUpdateDeleteCallback)
+
+	// row query
+	db.Callback().RowQuery().
+		Before("gorm:row_query").
+		Register("tenant_before_row_query_callback", tenantQueryUpdateDeleteCallback)
+
+	// update
+	db.Callback().Update().
+		Before("gorm:update").
+		Register("tenant_before_update_callback", tenantQueryUpdateDeleteCallback)
+
+	// delete
+	db.Callback().Delete().
+		Before("gorm:delete").
+		Register("tenant_before_delete_callback", tenantQueryUpdateDeleteCallback)
+
+	// create
+	db.Callback().Create().
+		Before("gorm:create").
+		Register("tenant_before_create_callback", tenantCreateCallback)
+
+	return db
+}
+
+// getTenantField returns the tenant field and tenant id of given gorm.Scope, ok is false when the model has no tenant field or the scope is unscoped.
+func getTenantField(scope *gorm.Scope) (field *gorm.Field, tenantId interface{}, hasTenantId bool, ok bool) {
+	if unscoped, has := scope.Get(tenantUnscopedKey); has && unscoped == true {
+		return nil, nil, false, false
+	}
+	field, has := scope.FieldByName(tenantIdColumnName)
+	if !has {
+		return nil, nil, false, false
+	}
+	tenantId, hasTenantId = scope.Get(tenantIdKey)
+	return field, tenantId, hasTenantId && tenantId != nil, true
+}
+
+// tenantQueryUpdateDeleteCallback is a callback for gorm:query, gorm:row_query, gorm:update, gorm:delete used in HookTenant.
+func tenantQueryUpdateDeleteCallback(scope *gorm.Scope) {
+	tenantField, tenantId, hasTenantId, ok := getTenantField(scope)
+	if !ok {
+		return
+	}
+	if scope.HasError() {
+		blockRowQuery(scope, scope.DB().Error) // the condition can not be added, so never execute the row query
+		return
+	}
+	if !hasTenantId {
+		scope.Err(ErrMissingTenant)
+		blockRowQuery(scope, ErrMissingTenant)
+		return
+	}
+
+	sql := fmt.Sprintf("%s.%s = ?", scope.QuotedTableName(), scope.Quote(tenantField.DBName))
+	scope.Search.Where(sql, tenantId)
+}
+
+// tenantCreateCallback is a callback for gorm:create used in HookTenant.
+func tenantCreateCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+	tenantField, tenantId, hasTenantId, ok := getTenantField(scope)
+	if !ok {
+		return
+	}
+	if !hasTenantId {
+		if tenantField.IsBlank {
+			scope.Err(ErrMissingTenant)
+		}
+		return // use the given tenant field value
+	}
+
+	scope.Err(scope.SetColumn(tenantField, tenantId))
+}
+
+// blockRowQuery blocks the following gorm:row_query callback by replacing the "row_query_result", and makes the replaced result carry given
+// error, that is DB.Rows returns the error and DB.Row's Scan returns the error. This is needed because gorm's rowQueryCallback never checks
+// the scope's error, and both DB.Row and DB.Rows drop the scope's error. Note that this function does nothing if the scope is not row_query.
+func blockRowQuery(scope *gorm.Scope, err error) {
+	result, ok := scope.InstanceGet("row_query_result")
+	if !ok || result == nil {
+		return
+	}
+	switch result := result.(type) {
+	case *gorm.RowsQueryResult:
+		result.Rows, result.Error = nil, err
+	case *gorm.RowQueryResult:
+		result.Row = errorRow(err)
+	}
+	scope.InstanceSet("row_query_result", nil)
+}
@@ -0,0 +1,151 @@
+package xgorm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+)
+
+// ErrStopBatches is the error returned by the function in FindInBatches to stop the iteration early, it will not be returned by
+// FindInBatches.
+var ErrStopBatches = errors.New("xgorm: stop batches")
+
+// errRowQueryBlocked represents the row_query is blocked by a callback, but no error is reported.
+var errRowQueryBlocked = errors.New("xgorm: row query is blocked by callback")
+
+// FindInBatches finds records in batches of given size, and calls fn for each batch with the 1-based batch number, dest must be a pointer
+// to slice of model, which will be filled with the current batch before calling fn. It returns a gorm.DB with the error and total rows
+// found, the iteration stops when fn returns an error, and ErrStopBatches means stop without error.
+//
+// The records are iterated by primary key using keyset pagination ("WHERE pk > last ORDER BY pk LIMIT size") instead of OFFSET, so the
+// order and limit of given gorm.DB will be replaced, the offset will be cleared, and the model must have a single primary key. The
+// conditions of given gorm.DB, including the soft-delete condition of HookDeletedAt, are kept in each batch.
+// Example:
+// 	users := make([]*User, 0)
+// 	rdb := xgorm.FindInBatches(db.Where("age > ?", 18), &users, 500, func(batch int) error {
+// 		for _, user := range users {
+// 			// ...
+// 		}
+// 		return nil // or return xgorm.ErrStopBatches to stop
+// 	})
+// 	log.Println(rdb.RowsAffected, rdb.Error)
+func FindInBatches(db *gorm.DB, dest interface{}, batchSize int, fn func(batch int) error) *gorm.DB {
+	rdb := db.New()
+	val := reflect.ValueOf(dest)
+	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Slice {
+		rdb.Error = errors.New("xgorm: FindInBatches only supports pointer to slice")
+		return rdb
+	}
+	if batchSize <= 0 {
+		rdb.Error = errors.New("xgorm: FindInBatches with non-positive batch size")
+		return rdb
+	}
+	scope := db.NewScope(dest)
+	if len(scope.PrimaryFields()) != 1 {
+		rdb.Error = errors.New("xgorm: FindInBatches only supports model with a single primary key")
+		return rdb
+	}
+	primaryKey := fmt.Sprintf("%s.%s", scope.QuotedTableName(), scope.Quote(scope.PrimaryKey()))
+
+	query := db.Order(primaryKey, true).Limit(batchSize).Offset(-1) // offset is cleared, otherwise it will be applied to each batch
+	total := int64(0)
+	var lastKey interface{}
+	for batch := 1; ; batch++ {
+		tx := query
+		if lastKey != nil {
+			tx = tx.Where(primaryKey+" > ?", lastKey)
+		}
+		if err := tx.Find(dest).Error; err != nil {
+			rdb.Error = err
+			break
+		}
+		slice := val.Elem()
+		if slice.Len() == 0 {
+			break
+		}
+		total += int64(slice.Len())
+		if err := fn(batch); err != nil {
+			if err != ErrStopBatches {
+				rdb.Error = err
+			}
+			break
+		}
+		if slice.Len() < batchSize {
+			break
+		}
+
+		last := reflect.Indirect(slice.Index(slice.Len() - 1))
+		primaryField := db.NewScope(last.Addr().Interface()).PrimaryField()
+		if primaryField == nil || primaryField.IsBlank {
+			rdb.Error = errors.New("xgorm: FindInBatches with blank primary key")
+			break
+		}
+		lastKey = primaryField.Field.Interface()
+	}
+	rdb.RowsAffected = total
+	return rdb
+}
+
+// RowIterator represents an iterator over the rows of gorm.DB's Rows, which scans each row into a model by gorm.Scope, so that large
+// result sets can be processed without loading all the rows into memory.
+type RowIterator struct {
+	db   *gorm.DB
+	rows *sql.Rows
+}
+
+// NewRowIterator executes the query of given gorm.DB by Rows, and creates a RowIterator. Note that the gorm.DB should be created with a
+// model or table, such as db.Model(&User{}), and the soft-delete condition of HookDeletedAt will be kept by the row_query callback.
+//
+// The error of given gorm.DB is returned before executing, and the error of row_query callbacks (such as ErrMissingTenant of HookTenant)
+// is returned instead of an empty iterator, because gorm's DB.Rows drops the scope's error. Note that only the callbacks which replace
+// the "row_query_result" to block executing (as all the hooks in this package do) can make their errors returned.
+// Example:
+// 	it, err := xgorm.NewRowIterator(db.Model(&User{}).Where("age > ?", 18))
+// 	if err != nil {
+// 		return err
+// 	}
+// 	defer it.Close()
+// 	for it.Next() {
+// 		user := &User{}
+// 		if err = it.Scan(user); err != nil {
+// 			return err
+// 		}
+// 		// ...
+// 	}
+// 	return it.Err()
+func NewRowIterator(db *gorm.DB) (*RowIterator, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	rows, err := db.Rows()
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		return nil, errRowQueryBlocked // blocked by callback without error
+	}
+	return &RowIterator{db: db, rows: rows}, nil
+}
+
+// Next prepares the next row for Scan, and returns false when there are no more rows or an error occurred.
+func (r *RowIterator) Next() bool {
+	return r.rows.Next()
+}
+
+// Scan scans the current row into given model by gorm.Scope, the fields are matched by column names, and the fields without matching
+// columns are kept unchanged.
+func (r *RowIterator) Scan(model interface{}) error {
+	return r.db.ScanRows(r.rows, model)
+}
+
+// Err returns the error encountered during the iteration.
+func (r *RowIterator) Err() error {
+	return r.rows.Err()
+}
+
+// Close closes the underlying sql.Rows, it is safe to call Close multiple times.
+func (r *RowIterator) Close() error {
+	return r.rows.Close()
+}
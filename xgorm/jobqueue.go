@@ -0,0 +1,480 @@
+package xgorm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sync"
+	"time"
+)
+
+// ErrJobLeaseLost represents the lease of the job has expired and been reaped, or the job has been acked or nacked, returned by
+// JobQueue's Ack and Nack.
+var ErrJobLeaseLost = errors.New("xgorm: job lease is lost")
+
+// JobState represents the state of QueueJob.
+type JobState int8
+
+const (
+	// JobPending represents the job is waiting to be dequeued, or to be retried.
+	JobPending JobState = iota
+
+	// JobRunning represents the job is leased by a worker.
+	JobRunning
+
+	// JobSucceeded represents the job has been acked.
+	JobSucceeded
+
+	// JobDead represents the job has failed after the max attempts, that is the dead-letter state, and will not be retried.
+	JobDead
+)
+
+// QueueJob represents a job in the job queue table, which is enqueued and leased by JobQueue. Note that this model should be migrated by
+// AutoMigrate or Migrator.
+type QueueJob struct {
+	Id          uint64     `gorm:"primary_key; auto_increment"`
+	Queue       string     `gorm:"type:varchar(255); not null; index:idx_queue_jobs_dequeue"`
+	Payload     string     `gorm:"type:text; not null"`
+	Priority    int        `gorm:"not null"`
+	State       JobState   `gorm:"not null; index:idx_queue_jobs_dequeue"`
+	RunAt       time.Time  `gorm:"not null; index:idx_queue_jobs_dequeue"`
+	Attempts    int        `gorm:"not null"`
+	MaxAttempts int        `gorm:"not null"`
+	LeaseToken  string     `gorm:"type:varchar(64); not null; index"`
+	LeasedUntil *time.Time `gorm:"index"`
+	LastError   string     `gorm:"type:text"`
+	FinishedAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// jobQueueOptions represents some options for JobQueue, set by JobQueueOption.
+type jobQueueOptions struct {
+	visibilityTimeout time.Duration
+	maxAttempts       int
+	backoff           func(attempts int) time.Duration
+	pollInterval      time.Duration
+	reapInterval      time.Duration
+	errorHandler      func(error)
+}
+
+// JobQueueOption represents an option for JobQueue, created by WithJobQueueXXX functions.
+type JobQueueOption func(*jobQueueOptions)
+
+// WithJobQueueVisibilityTimeout returns a JobQueueOption with visibility timeout, which is the lease duration of dequeued jobs, and the
+// job will be reaped and retried if it is not acked or nacked before the lease expires, defaults to 30s.
+func WithJobQueueVisibilityTimeout(timeout time.Duration) JobQueueOption {
+	return func(o *jobQueueOptions) {
+		o.visibilityTimeout = timeout
+	}
+}
+
+// WithJobQueueMaxAttempts returns a JobQueueOption with default max attempts of enqueued jobs, defaults to 5.
+func WithJobQueueMaxAttempts(attempts int) JobQueueOption {
+	return func(o *jobQueueOptions) {
+		o.maxAttempts = attempts
+	}
+}
+
+// WithJobQueueBackoff returns a JobQueueOption with backoff function, which returns the delay before retrying a nacked job by the number
+// of attempts made, defaults to DefaultOutboxBackoff.
+func WithJobQueueBackoff(backoff func(attempts int) time.Duration) JobQueueOption {
+	return func(o *jobQueueOptions) {
+		o.backoff = backoff
+	}
+}
+
+// WithJobQueuePollInterval returns a JobQueueOption with polling interval used in Work when there are no ready jobs or no free workers,
+// defaults to 1s.
+func WithJobQueuePollInterval(interval time.Duration) JobQueueOption {
+	return func(o *jobQueueOptions) {
+		o.pollInterval = interval
+	}
+}
+
+// WithJobQueueReapInterval returns a JobQueueOption with reaping interval used in Work, defaults to the visibility timeout.
+func WithJobQueueReapInterval(interval time.Duration) JobQueueOption {
+	return func(o *jobQueueOptions) {
+		o.reapInterval = interval
+	}
+}
+
+// WithJobQueueErrorHandler returns a JobQueueOption with error handler, which will be invoked when database operations or job handlers
+// fail in Work, defaults to nil.
+func WithJobQueueErrorHandler(handler func(error)) JobQueueOption {
+	return func(o *jobQueueOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// JobQueue represents a named job queue on a database table through gorm, which supports delayed and prioritized jobs, lease-based
+// dequeuing with visibility timeout, retries with backoff and dead-letter state. The jobs are dequeued by "FOR UPDATE SKIP LOCKED" in
+// MySQL 8 and PostgreSQL, and by a single UPDATE statement in SQLite, so that multiple workers can dequeue concurrently.
+type JobQueue struct {
+	db      *gorm.DB
+	name    string
+	options *jobQueueOptions
+}
+
+// NewJobQueue creates a JobQueue with given gorm.DB, queue name and JobQueueOption-s.
+// Example:
+// 	queue := xgorm.NewJobQueue(db, "email", xgorm.WithJobQueueVisibilityTimeout(time.Minute))
+// 	_, err := queue.Enqueue(&Email{To: "a@b.c"}, xgorm.WithJobPriority(10))
+// 	go queue.Work(ctx, 4, func(ctx context.Context, job *xgorm.QueueJob) error {
+// 		return sendEmail(ctx, job.Payload)
+// 	})
+func NewJobQueue(db *gorm.DB, name string, options ...JobQueueOption) *JobQueue {
+	opt := &jobQueueOptions{visibilityTimeout: 30 * time.Second, maxAttempts: 5, backoff: DefaultOutboxBackoff, pollInterval: time.Second}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if opt.visibilityTimeout <= 0 {
+		opt.visibilityTimeout = 30 * time.Second
+	}
+	if opt.maxAttempts <= 0 {
+		opt.maxAttempts = 5
+	}
+	if opt.backoff == nil {
+		opt.backoff = DefaultOutboxBackoff
+	}
+	if opt.pollInterval <= 0 {
+		opt.pollInterval = time.Second
+	}
+	if opt.reapInterval <= 0 {
+		opt.reapInterval = opt.visibilityTimeout
+	}
+	return &JobQueue{db: db, name: name, options: opt}
+}
+
+// Name returns the queue name of JobQueue.
+func (q *JobQueue) Name() string {
+	return q.name
+}
+
+// WithTx returns a copy of JobQueue which uses the given gorm.DB (such as a transaction from db.Begin) to execute statements, it is
+// useful to enqueue jobs in the same transaction as the business changes.
+func (q *JobQueue) WithTx(tx *gorm.DB) *JobQueue {
+	return &JobQueue{db: tx, name: q.name, options: q.options}
+}
+
+// jobOptions represents some options for JobQueue's Enqueue, set by JobOption.
+type jobOptions struct {
+	runAt       time.Time
+	priority    int
+	maxAttempts int
+}
+
+// JobOption represents an option for JobQueue's Enqueue, created by WithJobXXX functions.
+type JobOption func(*jobOptions)
+
+// WithJobRunAt returns a JobOption with the time when the job can be dequeued, defaults to now.
+func WithJobRunAt(runAt time.Time) JobOption {
+	return func(o *jobOptions) {
+		o.runAt = runAt
+	}
+}
+
+// WithJobDelay returns a JobOption with delay, that is WithJobRunAt(time.Now().Add(delay)).
+func WithJobDelay(delay time.Duration) JobOption {
+	return func(o *jobOptions) {
+		o.runAt = gorm.NowFunc().Add(delay)
+	}
+}
+
+// WithJobPriority returns a JobOption with priority, the job with higher priority will be dequeued first, defaults to 0.
+func WithJobPriority(priority int) JobOption {
+	return func(o *jobOptions) {
+		o.priority = priority
+	}
+}
+
+// WithJobMaxAttempts returns a JobOption with max attempts, defaults to the JobQueue's max attempts.
+func WithJobMaxAttempts(attempts int) JobOption {
+	return func(o *jobOptions) {
+		o.maxAttempts = attempts
+	}
+}
+
+// Enqueue creates a QueueJob with given payload and JobOption-s, the payload is stored as is if it is a string or []byte, otherwise it
+// is marshaled to json.
+func (q *JobQueue) Enqueue(payload interface{}, options ...JobOption) (*QueueJob, error) {
+	opt := &jobOptions{runAt: gorm.NowFunc(), maxAttempts: q.options.maxAttempts}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if opt.maxAttempts <= 0 {
+		opt.maxAttempts = q.options.maxAttempts
+	}
+
+	var data string
+	switch p := payload.(type) {
+	case string:
+		data = p
+	case []byte:
+		data = string(p)
+	default:
+		bs, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		data = string(bs)
+	}
+	job := &QueueJob{Queue: q.name, Payload: data, Priority: opt.priority, State: JobPending, RunAt: opt.runAt, MaxAttempts: opt.maxAttempts}
+	if err := q.db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Dequeue leases at most limit ready jobs, ordered by priority (descending), run-at time and id, the leased jobs are in JobRunning state
+// until the visibility timeout, and should be acked or nacked before that. It returns an empty slice if there are no ready jobs.
+func (q *JobQueue) Dequeue(limit int) ([]*QueueJob, error) {
+	if limit <= 0 {
+		limit = 1
+	}
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(bs)
+	now := gorm.NowFunc()
+	updates := map[string]interface{}{
+		"state":        JobRunning,
+		"lease_token":  token,
+		"leased_until": now.Add(q.options.visibilityTimeout),
+		"attempts":     gorm.Expr("attempts + 1"),
+	}
+	ready := func(db *gorm.DB) *gorm.DB {
+		return db.Model(&QueueJob{}).Where("queue = ? AND state = ? AND run_at <= ?", q.name, JobPending, now).
+			Order("priority DESC, run_at, id").Limit(limit)
+	}
+
+	var err error
+	if IsSQLite(q.db) {
+		// lease by a single statement, to avoid upgrading the read lock in transaction
+		subQuery := ready(q.db).Select("id").SubQuery()
+		err = q.db.Model(&QueueJob{}).Where("id IN ?", subQuery).Updates(updates).Error
+	} else {
+		err = transaction(q.db, func(tx *gorm.DB) error {
+			ids := make([]uint64, 0, limit)
+			if err := ready(Lock(tx, LockForUpdate, WithLockSkipLocked(true))).Pluck("id", &ids).Error; err != nil || len(ids) == 0 {
+				return err
+			}
+			return tx.Model(&QueueJob{}).Where("id IN (?)", ids).Updates(updates).Error
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*QueueJob, 0)
+	err = q.db.Where("lease_token = ?", token).Order("priority DESC, run_at, id").Find(&jobs).Error
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Ack marks the leased job as JobSucceeded, and returns ErrJobLeaseLost if the job is no longer leased by the caller.
+func (q *JobQueue) Ack(job *QueueJob) error {
+	now := gorm.NowFunc()
+	if err := q.finish(job, map[string]interface{}{"state": JobSucceeded, "finished_at": now, "leased_until": nil}); err != nil {
+		return err
+	}
+	job.State, job.FinishedAt, job.LeasedUntil = JobSucceeded, &now, nil
+	return nil
+}
+
+// Nack marks the leased job as failed with given reason, the job will be retried after the backoff, or be marked as JobDead if the max
+// attempts is reached. It returns ErrJobLeaseLost if the job is no longer leased by the caller.
+func (q *JobQueue) Nack(job *QueueJob, reason error) error {
+	lastError := ""
+	if reason != nil {
+		lastError = reason.Error()
+	}
+	now := gorm.NowFunc()
+	updates := map[string]interface{}{"last_error": lastError, "leased_until": nil}
+	state, runAt, finishedAt := JobPending, now.Add(q.options.backoff(job.Attempts)), (*time.Time)(nil)
+	if job.Attempts >= job.MaxAttempts {
+		state, runAt, finishedAt = JobDead, job.RunAt, &now
+		updates["finished_at"] = now
+	}
+	updates["state"], updates["run_at"] = state, runAt
+	if err := q.finish(job, updates); err != nil {
+		return err
+	}
+	job.State, job.RunAt, job.LastError, job.FinishedAt, job.LeasedUntil = state, runAt, lastError, finishedAt, nil
+	return nil
+}
+
+// finish updates the leased job with given values, checking the lease token.
+func (q *JobQueue) finish(job *QueueJob, updates map[string]interface{}) error {
+	updates["lease_token"] = ""
+	rdb := q.db.Model(&QueueJob{}).Where("id = ? AND state = ? AND lease_token = ?", job.Id, JobRunning, job.LeaseToken).Updates(updates)
+	if rdb.Error != nil {
+		return rdb.Error
+	}
+	if rdb.RowsAffected == 0 {
+		return ErrJobLeaseLost
+	}
+	job.LeaseToken = ""
+	return nil
+}
+
+// Reap releases the jobs whose lease has expired, the jobs will be retried immediately, or be marked as JobDead if the max attempts is
+// reached, and returns the number of reaped jobs.
+func (q *JobQueue) Reap() (int64, error) {
+	now := gorm.NowFunc()
+	reaped := int64(0)
+	err := transaction(q.db, func(tx *gorm.DB) error {
+		expired := func() *gorm.DB {
+			return tx.Model(&QueueJob{}).Where("queue = ? AND state = ? AND leased_until < ?", q.name, JobRunning, now)
+		}
+		rdb := expired().Where("attempts >= max_attempts").Updates(map[string]interface{}{
+			"state": JobDead, "lease_token": "", "leased_until": nil, "finished_at": now, "last_error": "lease expired",
+		})
+		if rdb.Error != nil {
+			return rdb.Error
+		}
+		reaped += rdb.RowsAffected
+		rdb = expired().Updates(map[string]interface{}{
+			"state": JobPending, "lease_token": "", "leased_until": nil, "run_at": now, "last_error": "lease expired",
+		})
+		if rdb.Error != nil {
+			return rdb.Error
+		}
+		reaped += rdb.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reaped, nil
+}
+
+// JobQueueStats represents the statistics of JobQueue, returned by JobQueue's Stats.
+type JobQueueStats struct {
+	Ready     int64 // pending jobs which can be dequeued now, that is the queue depth
+	Delayed   int64 // pending jobs whose run-at time is in the future
+	Running   int64
+	Succeeded int64
+	Dead      int64
+}
+
+// String returns the string of JobQueueStats.
+func (j *JobQueueStats) String() string {
+	return fmt.Sprintf("ready: %d, delayed: %d, running: %d, succeeded: %d, dead: %d", j.Ready, j.Delayed, j.Running, j.Succeeded, j.Dead)
+}
+
+// Stats returns the JobQueueStats of the queue, by counting jobs in each state.
+func (q *JobQueue) Stats() (*JobQueueStats, error) {
+	now := gorm.NowFunc()
+	rows, err := q.db.Model(&QueueJob{}).Where("queue = ?", q.name).
+		Select("state, CASE WHEN run_at <= ? THEN 1 ELSE 0 END AS ready, COUNT(*)", now).
+		Group("state, ready").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &JobQueueStats{}
+	for rows.Next() {
+		var state JobState
+		var ready int
+		var cnt int64
+		if err = rows.Scan(&state, &ready, &cnt); err != nil {
+			return nil, err
+		}
+		switch {
+		case state == JobPending && ready == 1:
+			stats.Ready += cnt
+		case state == JobPending:
+			stats.Delayed += cnt
+		case state == JobRunning:
+			stats.Running += cnt
+		case state == JobSucceeded:
+			stats.Succeeded += cnt
+		case state == JobDead:
+			stats.Dead += cnt
+		}
+	}
+	return stats, rows.Err()
+}
+
+// JobHandler represents a function to handle a QueueJob, the job will be acked if nil is returned, otherwise it will be nacked.
+type JobHandler func(ctx context.Context, job *QueueJob) error
+
+// Work dequeues and handles jobs with at most concurrency handlers running at the same time, and reaps expired leases periodically,
+// until the context is done. When the context is done, no more jobs will be dequeued, and Work returns after the running handlers
+// finish. The errors of database operations and handlers are reported to the error handler set by WithJobQueueErrorHandler.
+func (q *JobQueue) Work(ctx context.Context, concurrency int, handler JobHandler) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	report := func(err error) {
+		if err != nil && q.options.errorHandler != nil {
+			q.options.errorHandler(err)
+		}
+	}
+	slots := make(chan struct{}, concurrency)
+	done := make(chan struct{}, 1) // notified when a handler finishes
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	lastReap := time.Time{}
+	for ctx.Err() == nil {
+		if time.Since(lastReap) >= q.options.reapInterval {
+			_, err := q.Reap()
+			report(err)
+			lastReap = time.Now()
+		}
+
+		wake := done
+		free := concurrency - len(slots)
+		jobs := make([]*QueueJob, 0)
+		if free > 0 {
+			var err error
+			jobs, err = q.Dequeue(free)
+			report(err)
+		}
+		for _, job := range jobs {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(job *QueueJob) {
+				defer func() {
+					<-slots
+					wg.Done()
+					select {
+					case done <- struct{}{}:
+					default:
+					}
+				}()
+				if err := handler(ctx, job); err != nil {
+					report(err)
+					report(q.Nack(job, err))
+				} else {
+					report(q.Ack(job))
+				}
+			}(job)
+		}
+		switch {
+		case free > 0 && len(jobs) == free:
+			continue // more jobs may be ready
+		case free > 0 && len(jobs) == 0:
+			wake = nil // no ready jobs, just wait for polling interval
+		}
+		select {
+		case <-ctx.Done():
+		case <-wake:
+		case <-time.After(q.options.pollInterval):
+		}
+	}
+}
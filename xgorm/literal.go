@@ -0,0 +1,140 @@
+package xgorm
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Some timestamp layouts used in renderLiteral, note that all timestamps will be rendered in UTC.
+const (
+	mysqlTimestampLayout    = "2006-01-02 15:04:05.999999"
+	postgresTimestampLayout = "2006-01-02 15:04:05.999999Z07:00"
+	sqliteTimestampLayout   = "2006-01-02 15:04:05.999999999-07:00" // same as sqlite3.SQLiteTimestampFormats[0]
+	defaultTimestampLayout  = "2006-01-02 15:04:05"
+)
+
+// mysqlStringEscaper escapes string literal in mysql style, just like mysql_real_escape_string.
+var mysqlStringEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+// renderStringLiteral renders given string to a quoted string literal in the given dialect.
+func renderStringLiteral(s string, dialect string) string {
+	if dialect == "mysql" {
+		return "'" + mysqlStringEscaper.Replace(s) + "'"
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'" // standard sql, also used by postgres (standard_conforming_strings) and sqlite
+}
+
+// renderBytesLiteral renders given bytes to a hex literal in the given dialect.
+func renderBytesLiteral(bs []byte, dialect string) string {
+	if dialect == "postgres" {
+		return `'\x` + hex.EncodeToString(bs) + "'" // bytea hex format
+	}
+	return "X'" + hex.EncodeToString(bs) + "'"
+}
+
+// renderTimeLiteral renders given time.Time to a UTC timestamp literal in the given dialect.
+func renderTimeLiteral(t time.Time, dialect string) string {
+	switch dialect {
+	case "mysql":
+		if t.IsZero() {
+			return "'0000-00-00 00:00:00'" // same as go-sql-driver/mysql
+		}
+		return "'" + t.UTC().Format(mysqlTimestampLayout) + "'"
+	case "postgres":
+		return "'" + t.UTC().Format(postgresTimestampLayout) + "'"
+	case "sqlite3":
+		return "'" + t.UTC().Format(sqliteTimestampLayout) + "'"
+	default:
+		return "'" + t.UTC().Format(defaultTimestampLayout) + "'"
+	}
+}
+
+// renderBoolLiteral renders given bool to a boolean literal in the given dialect.
+func renderBoolLiteral(b bool, dialect string) string {
+	switch dialect {
+	case "mysql", "sqlite3":
+		if b {
+			return "1"
+		}
+		return "0"
+	default:
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+}
+
+// renderFloatLiteral renders given float to a numeric literal in the given dialect.
+func renderFloatLiteral(f float64, bitSize int, dialect string) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		if dialect == "postgres" {
+			return "'" + strconv.FormatFloat(f, 'g', -1, bitSize) + "'" // 'NaN', '+Inf', '-Inf'
+		}
+		return "NULL" // unsupported
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
+
+// renderLiteral renders given value to a replayable sql literal in the given dialect, that is "mysql", "postgres", "sqlite3" and
+// others (standard sql). These rules are applied:
+//
+// 1. String: quoted with escaped single quotes (and backslashes in mysql).
+//
+// 2. Bytes: printable bytes are rendered as string, and others are rendered as X'..' (or '\x..' in postgres).
+//
+// 3. Bool: rendered as 1/0 in mysql and sqlite3, and TRUE/FALSE in others.
+//
+// 4. Time: rendered as UTC timestamp.
+func renderLiteral(v interface{}, dialect string) string {
+	indirectValue := reflect.Indirect(reflect.ValueOf(v))
+	if !indirectValue.IsValid() {
+		return "NULL"
+	}
+	if valuer, ok := v.(driver.Valuer); ok {
+		val, err := valuer.Value()
+		if err != nil || val == nil {
+			return "NULL"
+		}
+		if _, ok := val.(driver.Valuer); ok {
+			return renderStringLiteral(fmt.Sprintf("%v", val), dialect) // avoid infinite recursion
+		}
+		return renderLiteral(val, dialect)
+	}
+	v = indirectValue.Interface()
+
+	switch value := v.(type) {
+	case time.Time:
+		return renderTimeLiteral(value, dialect)
+	case []byte:
+		if str := string(value); isPrintable(str) {
+			return renderStringLiteral(str, dialect)
+		}
+		return renderBytesLiteral(value, dialect)
+	case string:
+		return renderStringLiteral(value, dialect)
+	case bool:
+		return renderBoolLiteral(value, dialect)
+	case float32:
+		return renderFloatLiteral(float64(value), 32, dialect)
+	case float64:
+		return renderFloatLiteral(value, 64, dialect)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", value)
+	default:
+		return renderStringLiteral(fmt.Sprintf("%v", value), dialect)
+	}
+}
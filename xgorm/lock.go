@@ -0,0 +1,161 @@
+package xgorm
+
+import (
+	"fmt"
+	"github.com/Aoi-hosizora/ahlib/xstatus"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// LockUnavailable is the status returned by QueryErr, CreateErr, UpdateErr and DeleteErr when the statement fails to acquire a lock, that
+// is, lock wait timeout or NOWAIT failure in MySQL and PostgreSQL, and SQLITE_BUSY in SQLite.
+const LockUnavailable = xstatus.DbTagA
+
+// IsMySQLLockError checks if err is MySQL's ER_LOCK_WAIT_TIMEOUT or ER_LOCK_NOWAIT error.
+func IsMySQLLockError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && (mysqlErr.Number == MySQLLockWaitTimeoutErrno || mysqlErr.Number == MySQLLockNoWaitErrno)
+}
+
+// IsPostgreSQLLockError checks if err is PostgreSQL's lock_not_available error, which is returned when NOWAIT fails or lock_timeout
+// exceeds.
+func IsPostgreSQLLockError(err error) bool {
+	switch postgresErr := err.(type) {
+	case *pq.Error:
+		return postgresErr.Code == PostgreSQLLockNotAvailableErrno
+	case pq.Error:
+		return postgresErr.Code == PostgreSQLLockNotAvailableErrno
+	}
+	return false
+}
+
+// isLockError checks if the error of given gorm.DB is a lock error in its dialect, used in error helpers.
+func isLockError(rdb *gorm.DB) bool {
+	if rdb.Error == nil {
+		return false
+	}
+	return IsMySQL(rdb) && IsMySQLLockError(rdb.Error) ||
+		IsPostgreSQL(rdb) && IsPostgreSQLLockError(rdb.Error) ||
+		IsSQLite(rdb) && IsSQLiteBusyError(rdb.Error)
+}
+
+// LockStrength represents the strength of row lock, used in Lock.
+type LockStrength string
+
+const (
+	// LockForUpdate represents an exclusive row lock, that is "FOR UPDATE".
+	LockForUpdate LockStrength = "UPDATE"
+
+	// LockForShare represents a shared row lock, that is "FOR SHARE".
+	LockForShare LockStrength = "SHARE"
+)
+
+// lockOptions represents some options for Lock, set by LockOption.
+type lockOptions struct {
+	skipLocked bool
+	noWait     bool
+}
+
+// LockOption represents an option for Lock, created by WithLockXXX functions.
+type LockOption func(*lockOptions)
+
+// WithLockSkipLocked returns a LockOption with skipLocked switcher, which appends "SKIP LOCKED" to skip the rows locked by others, defaults
+// to false. It takes precedence over WithLockNoWait.
+func WithLockSkipLocked(skip bool) LockOption {
+	return func(o *lockOptions) {
+		o.skipLocked = skip
+	}
+}
+
+// WithLockNoWait returns a LockOption with noWait switcher, which appends "NOWAIT" to fail immediately rather than waiting for the rows
+// locked by others, defaults to false. The failure will be checked as LockUnavailable by error helpers.
+func WithLockNoWait(noWait bool) LockOption {
+	return func(o *lockOptions) {
+		o.noWait = noWait
+	}
+}
+
+// lockClause returns the locking clause for given dialect name, strength and lockOptions, an empty string means not supported.
+func lockClause(dialect string, strength LockStrength, opt *lockOptions) string {
+	if dialect != "mysql" && dialect != "postgres" {
+		return ""
+	}
+	clause := "FOR " + string(strength)
+	switch {
+	case opt.skipLocked:
+		clause += " SKIP LOCKED"
+	case opt.noWait:
+		clause += " NOWAIT"
+	}
+	return clause
+}
+
+// Lock returns a gorm.DB with locking clause ("FOR UPDATE" or "FOR SHARE", with "SKIP LOCKED" or "NOWAIT" optionally) appended to the
+// query statements, which should be used in a transaction. The clause is supported by MySQL 8 and PostgreSQL, and note that PostgreSQL
+// does not allow locking clause with aggregate functions such as Count.
+//
+// SQLite has no row lock, and the whole database is locked by the transaction, so the clause will not be appended. To avoid deadlock when
+// upgrading the read lock of a transaction to write lock, use "_txlock=immediate" in dsn (see SQLiteImmediateDSN) to begin transactions
+// by "BEGIN IMMEDIATE", and the waiting is controlled by SetLockTimeout.
+// Example:
+// 	err := db.Transaction(func(tx *gorm.DB) error {
+// 		job := &Job{}
+// 		rdb := xgorm.Lock(tx, xgorm.LockForUpdate, xgorm.WithLockSkipLocked(true)).Where("state = ?", 0).First(job)
+// 		if status, err := xgorm.QueryErr(rdb); status != xstatus.DbSuccess {
+// 			return err
+// 		}
+// 		return tx.Model(job).Update("state", 1).Error
+// 	})
+func Lock(db *gorm.DB, strength LockStrength, options ...LockOption) *gorm.DB {
+	opt := &lockOptions{}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	clause := lockClause(db.Dialect().GetName(), strength, opt)
+	if clause == "" {
+		return db
+	}
+	return db.Set("gorm:query_option", clause)
+}
+
+// SQLiteImmediateDSN returns a SQLite dsn with "_txlock=immediate" parameter, which makes go-sqlite3 begin transactions by "BEGIN
+// IMMEDIATE", that is, acquiring the write lock at the beginning of transactions.
+// Example:
+// 	db, err := gorm.Open("sqlite3", xgorm.SQLiteImmediateDSN("test.sql"))
+func SQLiteImmediateDSN(dsn string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&_txlock=immediate"
+	}
+	return dsn + "?_txlock=immediate"
+}
+
+// SetLockTimeout sets the timeout of waiting for locks, by "SET SESSION innodb_lock_wait_timeout" (in seconds, rounded up) in MySQL,
+// "SET LOCAL lock_timeout" in PostgreSQL, and "PRAGMA busy_timeout" in SQLite. Note that the setting is bound to the connection (or the
+// transaction in PostgreSQL), so it should be called in a transaction, otherwise only one connection in the pool is affected.
+// Example:
+// 	err := db.Transaction(func(tx *gorm.DB) error {
+// 		if err := xgorm.SetLockTimeout(tx, 3*time.Second); err != nil {
+// 			return err
+// 		}
+// 		// ...
+// 	})
+func SetLockTimeout(db *gorm.DB, timeout time.Duration) error {
+	ms := timeout.Milliseconds()
+	var query string
+	switch {
+	case IsMySQL(db):
+		query = fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", (ms+999)/1000)
+	case IsPostgreSQL(db):
+		query = fmt.Sprintf("SET LOCAL lock_timeout = %d", ms)
+	case IsSQLite(db):
+		query = fmt.Sprintf("PRAGMA busy_timeout = %d", ms)
+	default:
+		return fmt.Errorf("xgorm: SetLockTimeout with unsupported dialect %s", db.Dialect().GetName())
+	}
+	return db.Exec(query).Error
+}
@@ -1,12 +1,13 @@
 package xgorm
 
 import (
-	"database/sql/driver"
 	"fmt"
+	"github.com/Aoi-hosizora/ahlib-db/internal/redact"
 	"github.com/sirupsen/logrus"
-	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 )
@@ -18,8 +19,17 @@ type ILogger interface {
 
 // loggerOptions represents some options for logger, set by LoggerOption.
 type loggerOptions struct {
-	logInfo  bool
-	logOther bool
+	logInfo       bool
+	logOther      bool
+	sqlLevel      logrus.Level
+	slowThreshold time.Duration
+	onlyErrors    bool
+	redact        *redact.Rules
+	dialect       string
+	explain       *explainer
+
+	// detectedDialect represents the last dialect detected from sql, see dialectOf.
+	detectedDialect atomic.Value
 }
 
 // LoggerOption represents an option for logger, created by WithXXX functions.
@@ -39,6 +49,103 @@ func WithLogOther(logOther bool) LoggerOption {
 	}
 }
 
+// WithSqlLevel returns a LoggerOption with the logrus.Level for "SQL" message, defaults to logrus.InfoLevel, only used in LogrusLogger.
+func WithSqlLevel(level logrus.Level) LoggerOption {
+	return func(o *loggerOptions) {
+		o.sqlLevel = level
+	}
+}
+
+// WithSlowThreshold returns a LoggerOption with slow sql threshold, defaults to 0 which means disable slow sql checking. Note that "SQL"
+// message whose duration is over this threshold will be logged as logrus.WarnLevel with a `slow: true` field.
+func WithSlowThreshold(threshold time.Duration) LoggerOption {
+	return func(o *loggerOptions) {
+		o.slowThreshold = threshold
+	}
+}
+
+// WithOnlyErrors returns a LoggerOption with onlyErrors switcher to only do log for errors and slow sql, defaults to false. Note that gorm
+// reports the error of a failed statement by a separate [LOG] message, which will be logged as logrus.ErrorLevel with an `error` field.
+func WithOnlyErrors(onlyErrors bool) LoggerOption {
+	return func(o *loggerOptions) {
+		o.onlyErrors = onlyErrors
+	}
+}
+
+// WithDialect returns a LoggerOption with the dialect name used to render the replayable sql literals, which overrides the dialect derived
+// from the sql, defaults to "" which means deriving the dialect from the gorm.DB's dialect, see dialectOf for details.
+// Example:
+// 	db.SetLogger(xgorm.NewLogrusLogger(l, xgorm.WithDialect(db.Dialect().GetName())))
+func WithDialect(dialect string) LoggerOption {
+	return func(o *loggerOptions) {
+		o.dialect = dialect
+	}
+}
+
+// some regexps used in detectDialect.
+var (
+	_mysqlQuoteRegexp    = regexp.MustCompile("`[^`]+`")
+	_standardQuoteRegexp = regexp.MustCompile(`"[^"]+"`)
+)
+
+// detectDialect detects the dialect of given sql string generated by gorm, that is, by the Quote and BindVar methods of gorm.Dialect. Here
+// "$n" placeholders mean "postgres", "`" quoted identifiers mean "mysql", '"' quoted identifiers with "?" placeholders mean "sqlite3", and
+// "" will be returned if the dialect can not be detected, such as for raw sql without any quoted identifier.
+func detectDialect(sql string) string {
+	switch {
+	case _numericPlaceholderRegexp.MatchString(sql):
+		return "postgres"
+	case _mysqlQuoteRegexp.MatchString(sql):
+		return "mysql"
+	case _standardQuoteRegexp.MatchString(sql) && strings.Contains(sql, "?"):
+		return "sqlite3"
+	}
+	return ""
+}
+
+// dialectOf returns the dialect used to render given sql string, that is the dialect set by WithDialect, or the dialect of gorm.DB passed
+// to WithExplain, or the dialect detected from the sql by detectDialect. Note that the detected dialect will be remembered by the logger,
+// and will be used for the following sql whose dialect can not be detected.
+func (o *loggerOptions) dialectOf(sql string) string {
+	if o.dialect != "" {
+		return o.dialect
+	}
+	if o.explain != nil {
+		return o.explain.dialect
+	}
+	if dialect := detectDialect(sql); dialect != "" {
+		o.detectedDialect.Store(dialect)
+		return dialect
+	}
+	dialect, _ := o.detectedDialect.Load().(string)
+	return dialect
+}
+
+// Redactable represents a type whose values will always be rendered as '***' in logs, see WithRedactColumns and WithRedactValues.
+type Redactable = redact.Redactable
+
+// WithRedactColumns returns a LoggerOption with column name patterns (in glob syntax, case-insensitive) whose bound values will be
+// rendered as '***' in logs, such as "password" and "*_token".
+func WithRedactColumns(columns ...string) LoggerOption {
+	return func(o *loggerOptions) {
+		if o.redact == nil {
+			o.redact = &redact.Rules{}
+		}
+		o.redact.AddNames(columns...)
+	}
+}
+
+// WithRedactValues returns a LoggerOption with value regexps, bound values that match any of these regexps will be rendered as '***' in logs.
+// Note that values whose type implements Redactable will always be redacted.
+func WithRedactValues(regexps ...*regexp.Regexp) LoggerOption {
+	return func(o *loggerOptions) {
+		if o.redact == nil {
+			o.redact = &redact.Rules{}
+		}
+		o.redact.AddRegexps(regexps...)
+	}
+}
+
 // _enable is a global switcher to control xgorm logger behavior.
 var _enable = true
 
@@ -81,13 +188,18 @@ func NewLogrusLogger(logger *logrus.Logger, options ...LoggerOption) *LogrusLogg
 	opt := &loggerOptions{
 		logInfo:  true,
 		logOther: true,
+		sqlLevel: logrus.InfoLevel,
 	}
 	for _, op := range options {
 		if op != nil {
 			op(opt)
 		}
 	}
-	return &LogrusLogger{logger: logger, options: opt}
+	g := &LogrusLogger{logger: logger, options: opt}
+	if opt.explain != nil {
+		opt.explain.report = g.reportExplain
+	}
+	return g
 }
 
 // LoggerLogger represents a gorm's logger, used to log "SQL" and "INFO" message to logrus.StdLogger.
@@ -106,12 +218,14 @@ func NewLoggerLogger(logger logrus.StdLogger, options ...LoggerOption) *LoggerLo
 	opt := &loggerOptions{
 		logInfo:  true,
 		logOther: true,
+		sqlLevel: logrus.InfoLevel,
 	}
 	for _, op := range options {
 		if op != nil {
 			op(opt)
 		}
 	}
+	opt.explain = nil // only used in LogrusLogger
 	return &LoggerLogger{logger: logger, options: opt}
 }
 
@@ -125,10 +239,29 @@ func (g *LogrusLogger) Print(v ...interface{}) {
 	}
 
 	// info & sql & ...
-	msg, fields := formatLoggerAndFields(v, g.options)
+	msg, fields, level := formatLoggerAndFields(v, g.options)
 	if msg != "" && len(fields) != 0 {
-		g.logger.WithFields(fields).Info(msg)
+		g.logger.WithFields(fields).Log(level, msg)
+	}
+}
+
+// reportExplain logs the statement explained asynchronously by explainer, see WithExplain for details.
+// Logs like:
+// 	[Gorm] [explain] users:ref[uk_name](rows=1) | SELECT * FROM `users` WHERE (name = 'user1')
+func (g *LogrusLogger) reportExplain(stmt *explainedStatement) {
+	level := g.options.sqlLevel
+	if g.options.slowThreshold > 0 && stmt.duration >= g.options.slowThreshold {
+		level = logrus.WarnLevel
+	}
+	fields := logrus.Fields{
+		"module":      "gorm",
+		"type":        "explain",
+		"sql":         stmt.sql,
+		"fingerprint": stmt.fingerprint,
+		"duration":    stmt.duration,
+		"explain":     stmt.plan,
 	}
+	g.logger.WithFields(fields).Log(level, fmt.Sprintf("[Gorm] [explain] %s | %s", stmt.plan, stmt.sql))
 }
 
 // Print logs to logrus.StdLogger, see gorm.LogFormatter for details.
@@ -138,27 +271,28 @@ func (g *LoggerLogger) Print(v ...interface{}) {
 	}
 
 	// info & sql & ...
-	msg, _ := formatLoggerAndFields(v, g.options)
+	msg, _, _ := formatLoggerAndFields(v, g.options)
 	if msg != "" {
 		g.logger.Print(msg)
 	}
 }
 
-// formatLoggerAndFields formats interface{}-s to logger string and logrus.Fields.
+// formatLoggerAndFields formats interface{}-s to logger string, logrus.Fields and logrus.Level.
 // Logs like:
 // 	[Gorm] [info] registering callback `new_deleted_at_before_query_callback` from F:/Projects/ahlib-db/xgorm/hook.go:36
 // 	[Gorm] [log] Error 1062: Duplicate entry '1' for key 'PRIMARY'
 // 	[Gorm]       1 |     1.9957ms | SELECT * FROM `tbl_test`   ORDER BY `tbl_test`.`id` ASC LIMIT 1 | F:/Projects/ahlib-db/xgorm/xgorm_test.go:48
 // 	      |-------| |------------| |---------------------------------------------------------------| |-------------------------------------------|
 // 	          7           12                                      ...                                                       ...
-func formatLoggerAndFields(v []interface{}, options *loggerOptions) (string, logrus.Fields) {
+func formatLoggerAndFields(v []interface{}, options *loggerOptions) (string, logrus.Fields, logrus.Level) {
 	var msg string
 	var fields logrus.Fields
+	var level = logrus.InfoLevel
 
 	if len(v) == 2 {
 		// info
-		if !options.logInfo {
-			return "", nil
+		if !options.logInfo || options.onlyErrors {
+			return "", nil, 0
 		}
 		fields = logrus.Fields{
 			"module": "gorm",
@@ -168,8 +302,15 @@ func formatLoggerAndFields(v []interface{}, options *loggerOptions) (string, log
 		msg = fmt.Sprintf("[Gorm] %v", v[1])
 	} else if v[0] != "sql" {
 		// other
-		if !options.logOther {
-			return "", nil
+		var err error
+		for _, val := range v[2:] {
+			if e, ok := val.(error); ok {
+				err = e
+				break
+			}
+		}
+		if !options.logOther || (options.onlyErrors && err == nil) {
+			return "", nil, 0
 		}
 		s := fmt.Sprint(v[2:]...)
 		fields = logrus.Fields{
@@ -177,26 +318,46 @@ func formatLoggerAndFields(v []interface{}, options *loggerOptions) (string, log
 			"type":    v[0],
 			"message": s,
 		}
+		if err != nil {
+			level = logrus.ErrorLevel
+			fields["error"] = err
+			fields["source"] = v[1]
+		}
 		msg = fmt.Sprintf("[Gorm] [%v] %v", v[0], s)
 	} else {
 		// sql
 		source := v[1]
 		duration := v[2].(time.Duration)
-		sql := render(v[3].(string), v[4].([]interface{}))
+		dialect := options.dialectOf(v[3].(string))
+		sql := render(v[3].(string), redactParams(v[3].(string), v[4].([]interface{}), options.redact), dialect)
+		fingerprint := FingerprintDialectSQL(v[3].(string), dialect)
 		rows := v[5].(int64)
+		slow := options.slowThreshold > 0 && duration >= options.slowThreshold
+		if options.onlyErrors && !slow {
+			return "", nil, 0
+		}
 
+		level = options.sqlLevel
 		fields = logrus.Fields{
-			"module":   "gorm",
-			"type":     "sql",
-			"sql":      sql,
-			"rows":     rows,
-			"duration": duration,
-			"source":   source,
+			"module":      "gorm",
+			"type":        "sql",
+			"sql":         sql,
+			"fingerprint": fingerprint,
+			"rows":        rows,
+			"duration":    duration,
+			"source":      source,
+		}
+		if slow {
+			level = logrus.WarnLevel
+			fields["slow"] = true
+		}
+		if options.explain != nil {
+			options.explain.submit(v[3].(string), v[4].([]interface{}), &explainedStatement{sql: sql, fingerprint: fingerprint, duration: duration})
 		}
 		msg = fmt.Sprintf("[Gorm] %7d | %12s | %s | %s", rows, duration, sql, source)
 	}
 
-	return msg, fields
+	return msg, fields, level
 }
 
 // some regexps used in render.
@@ -205,7 +366,91 @@ var (
 	_numericPlaceholderRegexp = regexp.MustCompile(`\$\d+`)
 )
 
-// isPrintable is a string util function used in render.
+// redactedValue represents a redacted parameter, used in redactParams and render.
+type redactedValue struct{}
+
+// some regexps used in redactParams.
+var (
+	_insertColumnsRegexp  = regexp.MustCompile(`(?is)^\s*INSERT\s.*?\(([^()]*)\)\s*(?:OUTPUT\s.*?\s)?VALUES\s*`)
+	_comparedColumnRegexp = regexp.MustCompile(`(?i)([\w.\x60"]+)\s*(?:=|<>|!=|<=|>=|<|>|\s(?:NOT\s+)?LIKE|\s(?:NOT\s+)?IN\s*\([^()]*)\s*$`)
+)
+
+// unquoteColumn is a string util function used in redactParams, which trims the quotes and table name of given column.
+func unquoteColumn(column string) string {
+	column = strings.TrimSpace(column)
+	if idx := strings.LastIndex(column, "."); idx != -1 {
+		column = column[idx+1:]
+	}
+	return strings.Trim(column, "`\"[]")
+}
+
+// placeholderColumns returns the column names of all placeholders in given sql string, the result is indexed by the parameter index, and
+// an empty string means the column name is unknown.
+func placeholderColumns(sql string, count int) []string {
+	columns := make([]string, count)
+	var insertColumns []string
+	valuesStart := -1
+	if matches := _insertColumnsRegexp.FindStringSubmatchIndex(sql); len(matches) >= 4 {
+		for _, col := range strings.Split(sql[matches[2]:matches[3]], ",") {
+			insertColumns = append(insertColumns, unquoteColumn(col))
+		}
+		valuesStart = matches[1]
+	}
+
+	numeric := _numericPlaceholderRegexp.MatchString(sql)
+	locs := _placeholderRegexp.FindAllStringIndex(sql, -1)
+	if numeric {
+		locs = _numericPlaceholderRegexp.FindAllStringIndex(sql, -1)
+	}
+	valuesSeq := 0
+	for seq, loc := range locs {
+		idx := seq
+		if numeric {
+			idx, _ = strconv.Atoi(sql[loc[0]+1 : loc[1]])
+			idx--
+		}
+		if idx < 0 || idx >= count {
+			continue
+		}
+		if valuesStart != -1 && loc[0] >= valuesStart && len(insertColumns) > 0 {
+			columns[idx] = insertColumns[valuesSeq%len(insertColumns)] // assume that all values are placeholders
+			valuesSeq++
+			continue
+		}
+		prefix := sql[:loc[0]]
+		if len(prefix) > 256 {
+			prefix = prefix[len(prefix)-256:]
+		}
+		if matches := _comparedColumnRegexp.FindStringSubmatch(prefix); len(matches) >= 2 {
+			columns[idx] = unquoteColumn(matches[1])
+		}
+	}
+	return columns
+}
+
+// redactParams checks given parameters using redact.Rules, and returns new parameters with redacted values replaced by redactedValue.
+func redactParams(sql string, params []interface{}, rules *redact.Rules) []interface{} {
+	var columns []string
+	result := make([]interface{}, len(params))
+	for idx, param := range params {
+		result[idx] = param
+		if rules.MatchValue(param) {
+			result[idx] = redactedValue{}
+			continue
+		}
+		if rules != nil {
+			if columns == nil {
+				columns = placeholderColumns(sql, len(params))
+			}
+			if rules.MatchName(columns[idx]) {
+				result[idx] = redactedValue{}
+			}
+		}
+	}
+	return result
+}
+
+// isPrintable is a string util function used in renderLiteral.
 func isPrintable(s string) bool {
 	for _, r := range s {
 		if !unicode.IsPrint(r) {
@@ -215,41 +460,16 @@ func isPrintable(s string) bool {
 	return true
 }
 
-// render renders sql string and parameters to complete sql expression.
-func render(sql string, params []interface{}) string {
+// render renders sql string and parameters to complete sql expression, parameters are rendered as replayable literals in the given dialect,
+// see renderLiteral for details.
+func render(sql string, params []interface{}, dialect string) string {
 	values := make([]string, 0, len(params))
 	for _, v := range params {
-		indirectValue := reflect.Indirect(reflect.ValueOf(v))
-		if !indirectValue.IsValid() {
-			values = append(values, "NULL")
+		if _, ok := v.(redactedValue); ok {
+			values = append(values, fmt.Sprintf("'%s'", redact.Mask))
 			continue
 		}
-
-		v = indirectValue.Interface()
-		switch value := v.(type) {
-		case time.Time:
-			if value.IsZero() {
-				values = append(values, fmt.Sprintf("'%v'", "0000-00-00 00:00:00"))
-			} else {
-				values = append(values, fmt.Sprintf("'%v'", value.Format("2006-01-02 15:04:05")))
-			}
-		case []byte:
-			if str := string(value); isPrintable(str) {
-				values = append(values, fmt.Sprintf("'%v'", str))
-			} else {
-				values = append(values, "'<binary>'")
-			}
-		case driver.Valuer:
-			if val, err := value.Value(); err == nil && val != nil {
-				values = append(values, fmt.Sprintf("'%v'", val))
-			} else {
-				values = append(values, "NULL")
-			}
-		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
-			values = append(values, fmt.Sprintf("%v", value))
-		default:
-			values = append(values, fmt.Sprintf("'%v'", value))
-		}
+		values = append(values, renderLiteral(v, dialect))
 	}
 
 	result := ""
@@ -257,7 +477,7 @@ func render(sql string, params []interface{}) string {
 		result = sql + " "
 		for idx, value := range values {
 			placeholder := fmt.Sprintf(`\$%d(\D)`, idx+1) // \$1(\D) X
-			result = regexp.MustCompile(placeholder).ReplaceAllString(result, value+"$1")
+			result = regexp.MustCompile(placeholder).ReplaceAllString(result, strings.ReplaceAll(value, "$", "$$")+"$1")
 		}
 	} else {
 		for idx, val := range _placeholderRegexp.Split(sql, -1) { // \?
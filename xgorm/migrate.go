@@ -0,0 +1,700 @@
+package xgorm
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMigrationTable is the default table name for recording applied migrations.
+	DefaultMigrationTable = "schema_migrations"
+
+	// defaultMigrationLockTimeout is the default timeout for acquiring migration lock.
+	defaultMigrationLockTimeout = 30 * time.Second
+)
+
+var (
+	// ErrMigrationNotFound represents the migration is not registered.
+	ErrMigrationNotFound = errors.New("xgorm: migration not found")
+
+	// ErrDuplicateMigration represents the migration id has been registered.
+	ErrDuplicateMigration = errors.New("xgorm: duplicate migration id")
+
+	// ErrIrreversibleMigration represents the migration can not be rolled back, that is the migration has no Down function.
+	ErrIrreversibleMigration = errors.New("xgorm: irreversible migration")
+
+	// ErrMigrationLocked represents the migration lock can not be acquired in the lock timeout.
+	ErrMigrationLocked = errors.New("xgorm: failed to acquire migration lock")
+)
+
+// Migration represents a versioned schema migration, registered by Migrator.Register and Migrator.RegisterSQL. Migrations are applied
+// in the ascending order of ID, so it is recommended to use a sortable ID, such as "20210101120000_create_users".
+type Migration struct {
+	ID   string                  // unique migration id
+	Up   func(db *gorm.DB) error // apply function, required
+	Down func(db *gorm.DB) error // rollback function, nil means the migration is irreversible
+}
+
+// SchemaMigration represents an applied migration record in the migration table.
+type SchemaMigration struct {
+	Id        string    `gorm:"primary_key; type:varchar(255)"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// MigrationStatus represents a migration's status, returned by Migrator.Status.
+type MigrationStatus struct {
+	ID         string    `json:"id"`
+	Registered bool      `json:"registered"` // false if the migration is applied but not registered
+	Applied    bool      `json:"applied"`
+	AppliedAt  time.Time `json:"applied_at"` // zero if not applied
+}
+
+// migratorOptions represents some options for Migrator, set by MigratorOption.
+type migratorOptions struct {
+	table       string
+	lockTimeout time.Duration
+	dryRun      bool
+	logger      ILogger
+}
+
+// MigratorOption represents an option for Migrator, created by WithMigrationXXX functions.
+type MigratorOption func(*migratorOptions)
+
+// WithMigrationTable returns a MigratorOption with migration table name, defaults to DefaultMigrationTable.
+func WithMigrationTable(table string) MigratorOption {
+	return func(o *migratorOptions) {
+		o.table = table
+	}
+}
+
+// WithMigrationLockTimeout returns a MigratorOption with timeout for acquiring migration lock, defaults to 30s.
+func WithMigrationLockTimeout(timeout time.Duration) MigratorOption {
+	return func(o *migratorOptions) {
+		o.lockTimeout = timeout
+	}
+}
+
+// WithMigrationDryRun returns a MigratorOption to enable dry-run mode, in which the write statements (including the migration table
+// records) will not be executed but be logged through given ILogger, defaults to disabled. Note that plain SELECT, SHOW and EXPLAIN
+// statements are still executed, and the migration table will not be locked in dry-run mode. If the given logger is nil, a LoggerLogger
+// with log.LstdFlags will be used.
+func WithMigrationDryRun(logger ILogger) MigratorOption {
+	return func(o *migratorOptions) {
+		o.dryRun = true
+		o.logger = logger
+	}
+}
+
+// Migrator represents a versioned schema migrator, which applies and rolls back registered migrations, and records the applied versions
+// in the migration table. A dialect-appropriate lock is taken when running migrations (GET_LOCK in mysql, pg_advisory_lock in postgres,
+// and a lock row in the migration table name with "_lock" suffix in sqlite3), and each migration runs in its own transaction except in
+// mysql, whose DDL statements cause implicit commit.
+type Migrator struct {
+	db         *gorm.DB
+	options    *migratorOptions
+	migrations []*Migration // sorted by id
+}
+
+// NewMigrator creates a new Migrator with given gorm.DB and MigratorOption-s, note that the migration table is always accessed on the
+// primary if using Resolver.
+// Example:
+// 	m := xgorm.NewMigrator(db)
+// 	_ = m.Register(&xgorm.Migration{
+// 		ID:   "20210101120000_create_users",
+// 		Up:   func(db *gorm.DB) error { return db.CreateTable(&User{}).Error },
+// 		Down: func(db *gorm.DB) error { return db.DropTable(&User{}).Error },
+// 	})
+// 	_ = m.RegisterSQL(http.Dir("./migrations"), ".") // 20210102120000_add_index.up.sql, 20210102120000_add_index.down.sql
+// 	err := m.Migrate()
+func NewMigrator(db *gorm.DB, options ...MigratorOption) *Migrator {
+	opt := &migratorOptions{table: DefaultMigrationTable, lockTimeout: defaultMigrationLockTimeout}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if opt.table == "" {
+		opt.table = DefaultMigrationTable
+	}
+	if opt.dryRun && opt.logger == nil {
+		opt.logger = NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags))
+	}
+	return &Migrator{db: ForcePrimary(db), options: opt}
+}
+
+// Register registers some Migration-s to Migrator, returns error if the migration is invalid or its id has been registered.
+func (m *Migrator) Register(migrations ...*Migration) error {
+	for _, migration := range migrations {
+		if migration == nil || migration.ID == "" || migration.Up == nil {
+			return errors.New("xgorm: invalid migration, id and up function are required")
+		}
+		if m.find(migration.ID) != nil {
+			return fmt.Errorf("%w: %s", ErrDuplicateMigration, migration.ID)
+		}
+		m.migrations = append(m.migrations, migration)
+	}
+	sort.SliceStable(m.migrations, func(i, j int) bool {
+		return m.migrations[i].ID < m.migrations[j].ID
+	})
+	return nil
+}
+
+// RegisterSQL registers the sql file migrations in given directory of http.FileSystem (use http.Dir for os directory, or http.FS for
+// embed.FS), the file names must be in "{id}.up.sql" and "{id}.down.sql" format, and the down file is optional. Each file can contain
+// multiple statements separated by ";".
+func (m *Migrator) RegisterSQL(fs http.FileSystem, dir string) error {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	infos, err := f.Readdir(-1)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	ups, downs := make(map[string]string), make(map[string]string)
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		var id string
+		var scripts map[string]string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			id, scripts = strings.TrimSuffix(name, ".up.sql"), ups
+		case strings.HasSuffix(name, ".down.sql"):
+			id, scripts = strings.TrimSuffix(name, ".down.sql"), downs
+		default:
+			continue
+		}
+		script, err := readHttpFile(fs, path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		scripts[id] = script
+	}
+
+	migrations := make([]*Migration, 0, len(ups))
+	for id, up := range ups {
+		migration := &Migration{ID: id, Up: sqlMigrationFunc(up)}
+		if down, ok := downs[id]; ok {
+			migration.Down = sqlMigrationFunc(down)
+		}
+		migrations = append(migrations, migration)
+	}
+	for id := range downs {
+		if _, ok := ups[id]; !ok {
+			return fmt.Errorf("xgorm: missing up sql file for migration %s", id)
+		}
+	}
+	return m.Register(migrations...)
+}
+
+// readHttpFile reads the whole file content from http.FileSystem.
+func readHttpFile(fs http.FileSystem, name string) (string, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	bs, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// sqlMigrationFunc returns a migration function which executes given sql script statement by statement.
+func sqlMigrationFunc(script string) func(db *gorm.DB) error {
+	statements := splitSQLStatements(script)
+	return func(db *gorm.DB) error {
+		for _, statement := range statements {
+			if err := db.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// splitSQLStatements splits given sql script to statements by ";", comments are stripped, and quoted strings, quoted identifiers and
+// postgres's dollar-quoted strings are kept unchanged.
+func splitSQLStatements(script string) []string {
+	statements := make([]string, 0)
+	sb := strings.Builder{}
+	flush := func() {
+		if s := strings.TrimSpace(sb.String()); s != "" {
+			statements = append(statements, s)
+		}
+		sb.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for ; j < len(script); j++ {
+				if script[j] == '\\' && c == '\'' {
+					j++
+				} else if script[j] == c {
+					if j+1 < len(script) && script[j+1] == c {
+						j++ // escaped by doubling
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(script) {
+				j = len(script) - 1
+			}
+			sb.WriteString(script[i : j+1])
+			i = j
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+			sb.WriteByte('\n')
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			end := strings.Index(script[i+2:], "*/")
+			if end == -1 {
+				i = len(script)
+			} else {
+				i += end + 3
+			}
+			sb.WriteByte(' ')
+		case c == '$' && (i == 0 || !isIdentByte(script[i-1])):
+			j := i + 1
+			for j < len(script) && isIdentByte(script[j]) && script[j] != '$' && !(j == i+1 && isDigitByte(script[j])) {
+				j++
+			}
+			if j >= len(script) || script[j] != '$' {
+				sb.WriteByte(c) // placeholder or others
+				continue
+			}
+			tag := script[i : j+1]
+			end := strings.Index(script[j+1:], tag)
+			if end == -1 {
+				sb.WriteString(script[i:])
+				i = len(script)
+			} else {
+				sb.WriteString(script[i : j+1+end+len(tag)])
+				i = j + end + len(tag)
+			}
+		case c == ';':
+			flush()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
+
+// Migrations returns the registered migrations, sorted by id.
+func (m *Migrator) Migrations() []*Migration {
+	out := make([]*Migration, len(m.migrations))
+	copy(out, m.migrations)
+	return out
+}
+
+// find finds the registered migration by id.
+func (m *Migrator) find(id string) *Migration {
+	for _, migration := range m.migrations {
+		if migration.ID == id {
+			return migration
+		}
+	}
+	return nil
+}
+
+// applied returns the applied migrations from migration table, returns empty map if the table does not exist.
+func (m *Migrator) applied() (map[string]time.Time, error) {
+	out := make(map[string]time.Time)
+	if !m.db.HasTable(m.options.table) {
+		return out, nil
+	}
+	records := make([]*SchemaMigration, 0)
+	if err := m.db.Table(m.options.table).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		out[record.Id] = record.AppliedAt
+	}
+	return out, nil
+}
+
+// Status returns the status of registered and applied migrations, sorted by id.
+func (m *Migrator) Status() ([]*MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.ID]
+		out = append(out, &MigrationStatus{ID: migration.ID, Registered: true, Applied: ok, AppliedAt: appliedAt})
+	}
+	for id, appliedAt := range applied {
+		if m.find(id) == nil {
+			out = append(out, &MigrationStatus{ID: id, Registered: false, Applied: true, AppliedAt: appliedAt})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// migrationStep represents a step of migration plan.
+type migrationStep struct {
+	migration *Migration
+	up        bool
+}
+
+// rollbackStep checks and creates a rollback migrationStep for given applied migration id.
+func (m *Migrator) rollbackStep(id string) (*migrationStep, error) {
+	migration := m.find(id)
+	if migration == nil {
+		return nil, fmt.Errorf("%w: %s", ErrMigrationNotFound, id)
+	}
+	if migration.Down == nil {
+		return nil, fmt.Errorf("%w: %s", ErrIrreversibleMigration, id)
+	}
+	return &migrationStep{migration: migration, up: false}, nil
+}
+
+// sortedAppliedIds returns the applied migration ids in descending order.
+func sortedAppliedIds(applied map[string]time.Time) []string {
+	ids := make([]string, 0, len(applied))
+	for id := range applied {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids
+}
+
+// Migrate applies all pending migrations.
+func (m *Migrator) Migrate() error {
+	return m.MigrateTo("")
+}
+
+// MigrateTo migrates to given migration id, that is applying the pending migrations whose id is not greater than the given id, and
+// rolling back the applied migrations whose id is greater than the given id. Empty id means applying all pending migrations.
+func (m *Migrator) MigrateTo(id string) error {
+	if id != "" && m.find(id) == nil {
+		return fmt.Errorf("%w: %s", ErrMigrationNotFound, id)
+	}
+	return m.run(func(applied map[string]time.Time) ([]*migrationStep, error) {
+		steps := make([]*migrationStep, 0)
+		if id != "" {
+			for _, appliedId := range sortedAppliedIds(applied) {
+				if appliedId > id {
+					step, err := m.rollbackStep(appliedId)
+					if err != nil {
+						return nil, err
+					}
+					steps = append(steps, step)
+				}
+			}
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.ID]; !ok && (id == "" || migration.ID <= id) {
+				steps = append(steps, &migrationStep{migration: migration, up: true})
+			}
+		}
+		return steps, nil
+	})
+}
+
+// Rollback rolls back the last n applied migrations in descending order of id, and returns error if n is negative.
+func (m *Migrator) Rollback(n int) error {
+	if n < 0 {
+		return fmt.Errorf("xgorm: invalid rollback count %d", n)
+	}
+	return m.run(func(applied map[string]time.Time) ([]*migrationStep, error) {
+		steps := make([]*migrationStep, 0, n)
+		for _, appliedId := range sortedAppliedIds(applied) {
+			if len(steps) >= n {
+				break
+			}
+			step, err := m.rollbackStep(appliedId)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+		}
+		return steps, nil
+	})
+}
+
+// run takes the migration lock, creates the migration plan by given function and executes the plan.
+func (m *Migrator) run(plan func(applied map[string]time.Time) ([]*migrationStep, error)) error {
+	unlock, err := m.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+	steps, err := plan(applied)
+	if err != nil {
+		return err
+	}
+
+	db := m.db
+	if m.options.dryRun {
+		db, err = gorm.Open(m.db.Dialect().GetName(), &dryRunConn{db: m.db.CommonDB()})
+		if err != nil {
+			return err
+		}
+		db.LogMode(true)
+		db.SetLogger(m.options.logger)
+	}
+	if err := db.Table(m.options.table).AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if err := m.execute(db, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute executes a migrationStep, and records the result in migration table.
+func (m *Migrator) execute(db *gorm.DB, step *migrationStep) error {
+	table := db.Dialect().Quote(m.options.table)
+	fn, action := step.migration.Up, "apply"
+	record := func(db *gorm.DB) error {
+		return db.Exec(fmt.Sprintf("INSERT INTO %s (id, applied_at) VALUES (?, ?)", table), step.migration.ID, time.Now()).Error
+	}
+	if !step.up {
+		fn, action = step.migration.Down, "rollback"
+		record = func(db *gorm.DB) error {
+			return db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", table), step.migration.ID).Error
+		}
+	}
+
+	if _, inTx := db.CommonDB().(*sql.Tx); inTx || m.options.dryRun || IsMySQL(db) {
+		if err := fn(db); err != nil {
+			return fmt.Errorf("xgorm: failed to %s migration %s: %w", action, step.migration.ID, err)
+		}
+		return record(db)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("xgorm: failed to %s migration %s: %w", action, step.migration.ID, err)
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// hashLockName hashes given lock name to int64 key, used in postgres's advisory lock functions.
+func hashLockName(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// sessionLockReleaseTimeout is the timeout for releasing the session level lock, which is not bound to the caller's context, so that
+// the lock can still be released when the caller's context is done.
+const sessionLockReleaseTimeout = 5 * time.Second
+
+// closeLockConn closes the dedicated connection which holds a session level lock. If the lock is not released (or may have been
+// acquired), the connection is discarded instead of being returned to the pool, so that the lock will be released by the database
+// rather than being owned by the next user of the pooled connection.
+func closeLockConn(conn *sql.Conn, released bool) {
+	if !released {
+		_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+	_ = conn.Close()
+}
+
+// lock acquires the migration lock, and returns the unlock function. The lock is taken on a dedicated connection in mysql and postgres,
+// and by a lock row in sqlite3.
+func (m *Migrator) lock() (func(), error) {
+	noop := func() {}
+	if m.options.dryRun {
+		return noop, nil
+	}
+	if IsSQLite(m.db) {
+		return m.lockTable()
+	}
+	sqlDB := primarySQLDB(m.db)
+	if sqlDB == nil || (!IsMySQL(m.db) && !IsPostgreSQL(m.db)) {
+		return noop, nil
+	}
+
+	ctx := context.Background()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := "xgorm:migrate:" + m.options.table
+
+	if IsMySQL(m.db) {
+		var result sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(m.options.lockTimeout.Seconds())).Scan(&result)
+		if err != nil || !result.Valid || result.Int64 != 1 {
+			closeLockConn(conn, err == nil) // the lock may have been acquired if failed to scan
+			if err == nil {
+				err = ErrMigrationLocked
+			}
+			return nil, err
+		}
+		return func() {
+			ctx, cancel := context.WithTimeout(context.Background(), sessionLockReleaseTimeout)
+			defer cancel()
+			var released sql.NullInt64
+			err := conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", name).Scan(&released)
+			closeLockConn(conn, err == nil && released.Valid && released.Int64 == 1)
+		}, nil
+	}
+
+	key := hashLockName(name)
+	deadline := time.Now().Add(m.options.lockTimeout)
+	for {
+		locked := false
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+			closeLockConn(conn, false)
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			closeLockConn(conn, true)
+			return nil, ErrMigrationLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sessionLockReleaseTimeout)
+		defer cancel()
+		released := false
+		err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", key).Scan(&released)
+		closeLockConn(conn, err == nil && released)
+	}, nil
+}
+
+// lockTable acquires the migration lock by inserting a lock row into the lock table (migration table name with "_lock" suffix), which
+// is used in sqlite3. Note that the lock row will be left if the process exits without unlocking, and it should be deleted manually.
+func (m *Migrator) lockTable() (func(), error) {
+	table := m.db.Dialect().Quote(m.options.table + "_lock")
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER NOT NULL PRIMARY KEY, owner VARCHAR(255) NOT NULL, locked_at TIMESTAMP NOT NULL)", table)
+	if err := m.db.Exec(query).Error; err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	owner := hex.EncodeToString(token)
+	query = fmt.Sprintf("INSERT OR IGNORE INTO %s (id, owner, locked_at) VALUES (1, ?, ?)", table)
+	deadline := time.Now().Add(m.options.lockTimeout)
+	for {
+		rdb := m.db.Exec(query, owner, time.Now())
+		if rdb.Error != nil {
+			return nil, rdb.Error
+		}
+		if rdb.RowsAffected == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrMigrationLocked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return func() {
+		m.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = 1 AND owner = ?", table), owner)
+	}, nil
+}
+
+// errDryRunWrite represents a write statement is queried with Query or QueryRow in dry-run mode.
+var errDryRunWrite = errors.New("xgorm: write statement with returning result is not supported in dry-run mode")
+
+// dryRunConn is a gorm.SQLCommon used in dry-run mode, which skips executing write statements, and delegates read statements to the
+// underlying gorm.SQLCommon.
+type dryRunConn struct {
+	db gorm.SQLCommon
+}
+
+var _ gorm.SQLCommon = &dryRunConn{}
+
+// isReadStatement checks if given sql is a plain read statement, that is a SELECT without INTO and locking clause, a SHOW, or an EXPLAIN
+// without ANALYZE. Any other statement, including WITH (which may contain data-modifying statements in postgres) and PRAGMA, is treated
+// as a write statement.
+func isReadStatement(sql string) bool {
+	normalized := NormalizeSQL(sql)
+	switch {
+	case strings.HasPrefix(normalized, "select "):
+		for _, clause := range []string{" into ", " for update", " for share", " lock in share mode"} {
+			if strings.Contains(normalized, clause) {
+				return false
+			}
+		}
+		return true
+	case strings.HasPrefix(normalized, "show "):
+		return true
+	case strings.HasPrefix(normalized, "explain "):
+		return !strings.HasPrefix(normalized, "explain analyze")
+	}
+	return false
+}
+
+// Exec skips executing the statement, and returns zero rows affected, implements gorm.SQLCommon.
+func (d *dryRunConn) Exec(string, ...interface{}) (sql.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+// Prepare is not supported in dry-run mode, implements gorm.SQLCommon.
+func (d *dryRunConn) Prepare(string) (*sql.Stmt, error) {
+	return nil, errDryRunWrite
+}
+
+// Query executes a read statement, implements gorm.SQLCommon.
+func (d *dryRunConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	if !isReadStatement(query) {
+		return nil, errDryRunWrite
+	}
+	return d.db.Query(query, args...)
+}
+
+// QueryRow executes a read statement, implements gorm.SQLCommon. Note that for write statements, the returned sql.Row's Scan method will
+// always return an error.
+func (d *dryRunConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	if isReadStatement(query) {
+		return d.db.QueryRow(query, args...)
+	}
+	return errorRow(errDryRunWrite)
+}
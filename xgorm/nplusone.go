@@ -0,0 +1,220 @@
+package xgorm
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// some variables used in callerSource.
+var (
+	_gormSrcRegexp  = regexp.MustCompile(`jinzhu/gorm(@.*)?/.*.go`)
+	_gormTestRegexp = regexp.MustCompile(`jinzhu/gorm(@.*)?/.*test.go`)
+	_xgormSrcDir    = func() string {
+		_, file, _, _ := runtime.Caller(0)
+		return filepath.Dir(file)
+	}()
+)
+
+// callerSource returns the caller source location like "file:line", which skips the gorm and xgorm's source files, just like the
+// source passed to gorm's logger.
+func callerSource() string {
+	for i := 2; i < 20; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		if _gormSrcRegexp.MatchString(file) && !_gormTestRegexp.MatchString(file) {
+			continue
+		}
+		if filepath.Dir(file) == _xgormSrcDir && !strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return ""
+}
+
+const (
+	// nPlusOneDetectorKey is the gorm.DB setting key for NPlusOneDetector, set by NPlusOneDetector.Attach.
+	nPlusOneDetectorKey = "xgorm:n_plus_one_detector"
+
+	// maxNPlusOneSources is the max count of distinct sources recorded in NPlusOneReport.
+	maxNPlusOneSources = 10
+)
+
+// NPlusOneReport represents a report of N+1 query, which is generated when a statement shape runs more than the threshold times.
+type NPlusOneReport struct {
+	Fingerprint string   // sql fingerprint, see FingerprintSQL
+	SQL         string   // normalized sql, see NormalizeSQL
+	Count       int      // executed times when reporting
+	Sources     []string // distinct source locations, at most 10
+}
+
+// String returns the string of NPlusOneReport.
+func (n *NPlusOneReport) String() string {
+	return fmt.Sprintf("xgorm: N+1 query detected, `%s` runs %d times, from %s", n.SQL, n.Count, strings.Join(n.Sources, ", "))
+}
+
+// nPlusOneOptions represents some options for NPlusOneDetector, set by NPlusOneOption.
+type nPlusOneOptions struct {
+	panic    bool
+	reporter func(*NPlusOneReport)
+}
+
+// NPlusOneOption represents an option for NPlusOneDetector, created by WithNPlusOneXXX functions.
+type NPlusOneOption func(*nPlusOneOptions)
+
+// WithNPlusOnePanic returns a NPlusOneOption with panic switcher to panic with NPlusOneReport when detected, defaults to false.
+func WithNPlusOnePanic(panic bool) NPlusOneOption {
+	return func(o *nPlusOneOptions) {
+		o.panic = panic
+	}
+}
+
+// WithNPlusOneReporter returns a NPlusOneOption with reporter function which will be invoked when detected, defaults to nil.
+func WithNPlusOneReporter(reporter func(*NPlusOneReport)) NPlusOneOption {
+	return func(o *nPlusOneOptions) {
+		o.reporter = reporter
+	}
+}
+
+// nPlusOneEntry represents a statement counter entry in NPlusOneDetector.
+type nPlusOneEntry struct {
+	sql      string
+	count    int
+	sources  []string
+	reported bool
+}
+
+// NPlusOneDetector represents a N+1 query detection scope, which counts query statements by fingerprint through gorm callbacks, and
+// reports (or panics) when one statement shape runs more than the threshold times. This is designed to be created per unit of work,
+// such as per HTTP request, and is safe for concurrent use.
+type NPlusOneDetector struct {
+	threshold int
+	options   *nPlusOneOptions
+
+	mu      sync.Mutex
+	entries map[string]*nPlusOneEntry
+	reports []*NPlusOneReport
+}
+
+// NewNPlusOneDetector creates a new NPlusOneDetector with given threshold and NPlusOneOption-s, note that HookNPlusOne must be invoked
+// on the gorm.DB before using this detector.
+// Example:
+// 	xgorm.HookNPlusOne(db) // only once
+// 	detector := xgorm.NewNPlusOneDetector(10, xgorm.WithNPlusOneReporter(func(r *xgorm.NPlusOneReport) { log.Println(r) }))
+// 	rdb := detector.Attach(db) // use this gorm.DB in a request
+func NewNPlusOneDetector(threshold int, options ...NPlusOneOption) *NPlusOneDetector {
+	opt := &nPlusOneOptions{}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &NPlusOneDetector{threshold: threshold, options: opt, entries: make(map[string]*nPlusOneEntry)}
+}
+
+// Attach returns a new gorm.DB bound with this NPlusOneDetector.
+func (n *NPlusOneDetector) Attach(db *gorm.DB) *gorm.DB {
+	return db.Set(nPlusOneDetectorKey, n)
+}
+
+// Reports returns all the NPlusOneReport-s generated by this detector.
+func (n *NPlusOneDetector) Reports() []*NPlusOneReport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]*NPlusOneReport, len(n.reports))
+	copy(out, n.reports)
+	return out
+}
+
+// Reset clears all counters and reports of this detector.
+func (n *NPlusOneDetector) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries = make(map[string]*nPlusOneEntry)
+	n.reports = nil
+}
+
+// record records a statement with its source, and returns a NPlusOneReport if the statement runs more than the threshold times at first.
+func (n *NPlusOneDetector) record(sql, dialect, source string) *NPlusOneReport {
+	fingerprint := FingerprintDialectSQL(sql, dialect)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	entry, ok := n.entries[fingerprint]
+	if !ok {
+		entry = &nPlusOneEntry{sql: NormalizeDialectSQL(sql, dialect)}
+		n.entries[fingerprint] = entry
+	}
+	entry.count++
+	if source != "" && len(entry.sources) < maxNPlusOneSources {
+		found := false
+		for _, s := range entry.sources {
+			if s == source {
+				found = true
+				break
+			}
+		}
+		if !found {
+			entry.sources = append(entry.sources, source)
+		}
+	}
+	if entry.reported || entry.count <= n.threshold {
+		return nil
+	}
+
+	entry.reported = true
+	sources := make([]string, len(entry.sources))
+	copy(sources, entry.sources)
+	report := &NPlusOneReport{Fingerprint: fingerprint, SQL: entry.sql, Count: entry.count, Sources: sources}
+	n.reports = append(n.reports, report)
+	return report
+}
+
+// HookNPlusOne hooks gorm.DB to count the query and row_query statements for NPlusOneDetector, note that statements will only be counted
+// when the gorm.DB is attached by NPlusOneDetector.Attach.
+func HookNPlusOne(db *gorm.DB) *gorm.DB {
+	// query
+	db.Callback().Query().
+		After("gorm:query").
+		Register("n_plus_one_after_query_callback", nPlusOneCallback)
+
+	// row query
+	db.Callback().RowQuery().
+		After("gorm:row_query").
+		Register("n_plus_one_after_row_query_callback", nPlusOneCallback)
+
+	return db
+}
+
+// nPlusOneCallback is a callback for gorm:query, gorm:row_query used in HookNPlusOne.
+func nPlusOneCallback(scope *gorm.Scope) {
+	val, ok := scope.Get(nPlusOneDetectorKey)
+	if !ok || scope.SQL == "" {
+		return
+	}
+	detector, ok := val.(*NPlusOneDetector)
+	if !ok || detector == nil {
+		return
+	}
+
+	report := detector.record(scope.SQL, scope.Dialect().GetName(), callerSource())
+	if report == nil {
+		return
+	}
+	if detector.options.reporter != nil {
+		detector.options.reporter(report)
+	}
+	if detector.options.panic {
+		panic(report)
+	}
+}
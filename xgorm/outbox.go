@@ -0,0 +1,304 @@
+package xgorm
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// OutboxStatus represents the delivery status of OutboxEvent.
+type OutboxStatus int8
+
+const (
+	// OutboxPending represents the event is waiting to be delivered, or to be retried.
+	OutboxPending OutboxStatus = iota
+
+	// OutboxDelivered represents the event has been delivered by the publisher.
+	OutboxDelivered
+
+	// OutboxFailed represents the event has failed to be delivered after the max attempts, and will not be retried.
+	OutboxFailed
+)
+
+// OutboxEvent represents an event in the transactional outbox table, which is written in the same transaction as the business changes
+// by EnqueueOutbox, and delivered later by OutboxDispatcher. Note that this model should be migrated by AutoMigrate or Migrator.
+type OutboxEvent struct {
+	Id            uint64       `gorm:"primary_key; auto_increment"`
+	Topic         string       `gorm:"type:varchar(255); not null"`
+	Key           string       `gorm:"type:varchar(255); not null"`
+	Payload       string       `gorm:"type:text; not null"`
+	Status        OutboxStatus `gorm:"not null; index:idx_outbox_events_status_next_attempt_at"`
+	Attempts      int          `gorm:"not null"`
+	NextAttemptAt time.Time    `gorm:"not null; index:idx_outbox_events_status_next_attempt_at"`
+	LastError     string       `gorm:"type:text"`
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// EnqueueOutbox creates an OutboxEvent with given topic, key and payload, which should be invoked with a gorm.DB in transaction, so that
+// the event is committed or rolled back with the business changes. The payload is stored as is if it is a string or []byte, otherwise
+// it is marshaled to json.
+// Example:
+// 	err := db.Transaction(func(tx *gorm.DB) error {
+// 		if err := tx.Create(order).Error; err != nil {
+// 			return err
+// 		}
+// 		_, err := xgorm.EnqueueOutbox(tx, "order.created", strconv.Itoa(order.Id), order)
+// 		return err
+// 	})
+func EnqueueOutbox(tx *gorm.DB, topic, key string, payload interface{}) (*OutboxEvent, error) {
+	var data string
+	switch p := payload.(type) {
+	case string:
+		data = p
+	case []byte:
+		data = string(p)
+	default:
+		bs, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		data = string(bs)
+	}
+	event := &OutboxEvent{Topic: topic, Key: key, Payload: data, Status: OutboxPending, NextAttemptAt: gorm.NowFunc()}
+	if err := tx.Create(event).Error; err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// OutboxPublisher represents a function to publish an OutboxEvent, such as sending to a message broker, the event will be retried if an
+// error is returned. Note that events may be published more than once, so the consumers should be idempotent.
+type OutboxPublisher func(ctx context.Context, event *OutboxEvent) error
+
+// outboxOptions represents some options for OutboxDispatcher, set by OutboxOption.
+type outboxOptions struct {
+	batchSize    int
+	interval     time.Duration
+	claimTimeout time.Duration
+	maxAttempts  int
+	backoff      func(attempts int) time.Duration
+	errorHandler func(error)
+}
+
+// OutboxOption represents an option for OutboxDispatcher, created by WithOutboxXXX functions.
+type OutboxOption func(*outboxOptions)
+
+// WithOutboxBatchSize returns an OutboxOption with the max number of events claimed in a batch, defaults to 100.
+func WithOutboxBatchSize(size int) OutboxOption {
+	return func(o *outboxOptions) {
+		o.batchSize = size
+	}
+}
+
+// WithOutboxInterval returns an OutboxOption with polling interval when there are no more pending events, defaults to 1s.
+func WithOutboxInterval(interval time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.interval = interval
+	}
+}
+
+// WithOutboxClaimTimeout returns an OutboxOption with claim timeout, the claimed events will be claimable by other dispatchers again after
+// the timeout if they are not marked, such as the dispatcher crashes, defaults to 1min.
+func WithOutboxClaimTimeout(timeout time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.claimTimeout = timeout
+	}
+}
+
+// WithOutboxMaxAttempts returns an OutboxOption with max attempts, the event will be marked as OutboxFailed after failing max attempts,
+// defaults to 10, and non-positive value means retrying forever.
+func WithOutboxMaxAttempts(attempts int) OutboxOption {
+	return func(o *outboxOptions) {
+		o.maxAttempts = attempts
+	}
+}
+
+// WithOutboxBackoff returns an OutboxOption with backoff function, which returns the delay before the next attempt by the number of
+// attempts made, defaults to DefaultOutboxBackoff.
+func WithOutboxBackoff(backoff func(attempts int) time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.backoff = backoff
+	}
+}
+
+// WithOutboxErrorHandler returns an OutboxOption with error handler, which will be invoked when database operations or publisher fail in
+// Run, defaults to nil.
+func WithOutboxErrorHandler(handler func(error)) OutboxOption {
+	return func(o *outboxOptions) {
+		o.errorHandler = handler
+	}
+}
+
+// DefaultOutboxBackoff is the default backoff function of OutboxDispatcher, which is exponential from 1s and capped at 1h.
+func DefaultOutboxBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > 13 {
+		return time.Hour
+	}
+	backoff := time.Second << uint(attempts-1)
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	return backoff
+}
+
+// OutboxDispatcher represents a dispatcher of the transactional outbox, which claims pending events with row locking, publishes them by
+// OutboxPublisher, and marks them as delivered, or retries them with backoff.
+type OutboxDispatcher struct {
+	db        *gorm.DB
+	publisher OutboxPublisher
+	options   *outboxOptions
+}
+
+// NewOutboxDispatcher creates an OutboxDispatcher with given gorm.DB, OutboxPublisher and OutboxOption-s. Multiple dispatchers can run
+// concurrently, the events are claimed by "FOR UPDATE SKIP LOCKED" in MySQL 8 and PostgreSQL, and by the database lock in SQLite.
+// Example:
+// 	dispatcher := xgorm.NewOutboxDispatcher(db, func(ctx context.Context, event *xgorm.OutboxEvent) error {
+// 		return producer.Send(ctx, event.Topic, event.Key, event.Payload)
+// 	}, xgorm.WithOutboxErrorHandler(func(err error) { log.Println(err) }))
+// 	ctx, cancel := context.WithCancel(context.Background())
+// 	go dispatcher.Run(ctx)
+// 	defer cancel() // stop gracefully
+func NewOutboxDispatcher(db *gorm.DB, publisher OutboxPublisher, options ...OutboxOption) *OutboxDispatcher {
+	opt := &outboxOptions{batchSize: 100, interval: time.Second, claimTimeout: time.Minute, maxAttempts: 10, backoff: DefaultOutboxBackoff}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	if opt.batchSize <= 0 {
+		opt.batchSize = 100
+	}
+	if opt.interval <= 0 {
+		opt.interval = time.Second
+	}
+	if opt.claimTimeout <= 0 {
+		opt.claimTimeout = time.Minute
+	}
+	if opt.backoff == nil {
+		opt.backoff = DefaultOutboxBackoff
+	}
+	return &OutboxDispatcher{db: db, publisher: publisher, options: opt}
+}
+
+// Run dispatches events until the context is done, it polls by the interval when there are no more pending events. When the context is
+// done, the event being published is finished, and the rest claimed events are released for other dispatchers.
+func (o *OutboxDispatcher) Run(ctx context.Context) {
+	for {
+		n, err := o.DispatchOnce(ctx)
+		if err != nil && o.options.errorHandler != nil && ctx.Err() == nil {
+			o.options.errorHandler(err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if n >= o.options.batchSize && err == nil {
+			continue // more events may be pending
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(o.options.interval):
+		}
+	}
+}
+
+// DispatchOnce claims a batch of pending events and publishes them, returns the number of claimed events, and the first error of the
+// publisher or database operations.
+func (o *OutboxDispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	events, err := o.claim()
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	var firstErr error
+	for i, event := range events {
+		if ctx.Err() != nil {
+			if err := o.release(events[i:]); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if firstErr == nil {
+				firstErr = ctx.Err()
+			}
+			break
+		}
+		pubErr := o.publisher(ctx, event)
+		if pubErr != nil && firstErr == nil {
+			firstErr = pubErr
+		}
+		if err := o.mark(event, pubErr); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return len(events), firstErr
+}
+
+// claim selects pending events with row locking, and postpones their next attempt time by claim timeout in a transaction.
+func (o *OutboxDispatcher) claim() ([]*OutboxEvent, error) {
+	events := make([]*OutboxEvent, 0)
+	err := transaction(o.db, func(tx *gorm.DB) error {
+		now := gorm.NowFunc()
+		rdb := Lock(tx, LockForUpdate, WithLockSkipLocked(true)).
+			Where("status = ? AND next_attempt_at <= ?", OutboxPending, now).
+			Order("id").Limit(o.options.batchSize).Find(&events)
+		if rdb.Error != nil || len(events) == 0 {
+			return rdb.Error
+		}
+		ids := make([]uint64, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.Id)
+		}
+		next := now.Add(o.options.claimTimeout)
+		rdb = tx.Model(&OutboxEvent{}).Where("id IN (?)", ids).
+			Updates(map[string]interface{}{"attempts": gorm.Expr("attempts + 1"), "next_attempt_at": next})
+		if rdb.Error != nil {
+			return rdb.Error
+		}
+		for _, event := range events {
+			event.Attempts++
+			event.NextAttemptAt = next
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// mark marks the event as delivered if published successfully, otherwise schedules the next attempt or marks it as failed.
+func (o *OutboxDispatcher) mark(event *OutboxEvent, pubErr error) error {
+	now := gorm.NowFunc()
+	updates := map[string]interface{}{}
+	switch {
+	case pubErr == nil:
+		event.Status, event.DeliveredAt, event.LastError = OutboxDelivered, &now, ""
+		updates["delivered_at"] = now
+	case o.options.maxAttempts > 0 && event.Attempts >= o.options.maxAttempts:
+		event.Status, event.LastError = OutboxFailed, pubErr.Error()
+	default:
+		event.Status, event.LastError = OutboxPending, pubErr.Error()
+		event.NextAttemptAt = now.Add(o.options.backoff(event.Attempts))
+		updates["next_attempt_at"] = event.NextAttemptAt
+	}
+	updates["status"], updates["last_error"] = event.Status, event.LastError
+	return o.db.Model(&OutboxEvent{}).Where("id = ?", event.Id).Updates(updates).Error
+}
+
+// release releases the claimed but unpublished events, so that they can be claimed again immediately.
+func (o *OutboxDispatcher) release(events []*OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	ids := make([]uint64, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.Id)
+	}
+	updates := map[string]interface{}{"attempts": gorm.Expr("attempts - 1"), "next_attempt_at": gorm.NowFunc()}
+	return o.db.Model(&OutboxEvent{}).Where("id IN (?)", ids).Updates(updates).Error
+}
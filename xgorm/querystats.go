@@ -0,0 +1,280 @@
+package xgorm
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sync"
+	"time"
+)
+
+const (
+	// queryStatsKey is the gorm.DB setting key for QueryStats, set by QueryStats.Attach.
+	queryStatsKey = "xgorm:query_stats"
+
+	// queryStatsStartKey is the gorm.Scope instance key for the statement start time.
+	queryStatsStartKey = "xgorm:query_stats_start"
+)
+
+// QueryBudgetExceededError represents the error returned when a statement is refused by QueryStats, because the statement count or the
+// total DB time has exceeded the budget.
+type QueryBudgetExceededError struct {
+	MaxStatements int           // statement budget, 0 means no limit
+	MaxDuration   time.Duration // duration budget, 0 means no limit
+	Statements    int           // executed statements when refusing
+	Duration      time.Duration // total DB time when refusing
+}
+
+// Error returns the error message of QueryBudgetExceededError.
+func (q *QueryBudgetExceededError) Error() string {
+	if q.MaxStatements > 0 && q.Statements >= q.MaxStatements {
+		return fmt.Sprintf("xgorm: query budget exceeded, %d statements have been executed (max %d)", q.Statements, q.MaxStatements)
+	}
+	return fmt.Sprintf("xgorm: query budget exceeded, %s has been spent in database (max %s)", q.Duration.String(), q.MaxDuration.String())
+}
+
+// IsQueryBudgetExceededError checks if err is QueryBudgetExceededError, or gorm.Errors containing QueryBudgetExceededError.
+func IsQueryBudgetExceededError(err error) bool {
+	switch e := err.(type) {
+	case *QueryBudgetExceededError:
+		return true
+	case gorm.Errors:
+		for _, err := range e {
+			if _, ok := err.(*QueryBudgetExceededError); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TableQueryStats represents the statistics of statements on a single table, used in QueryStatsSnapshot.
+type TableQueryStats struct {
+	Statements   int           `json:"statements"`    // executed statement count
+	Duration     time.Duration `json:"duration"`      // total DB time
+	RowsRead     int64         `json:"rows_read"`     // rows returned by query statements, row_query (Row and Rows) is not included
+	RowsAffected int64         `json:"rows_affected"` // rows affected by create, update and delete statements
+}
+
+// add adds a statement's result to TableQueryStats.
+func (t *TableQueryStats) add(duration time.Duration, rowsRead, rowsAffected int64) {
+	t.Statements++
+	t.Duration += duration
+	t.RowsRead += rowsRead
+	t.RowsAffected += rowsAffected
+}
+
+// QueryStatsSnapshot represents a snapshot of QueryStats, which contains the total statistics and the statistics broken down by table.
+// Statements without table name (such as some raw sql) are only counted in the total statistics.
+type QueryStatsSnapshot struct {
+	TableQueryStats
+	Tables  map[string]*TableQueryStats `json:"tables"`
+	Refused int                         `json:"refused"` // statements refused by budget
+}
+
+// queryStatsOptions represents some options for QueryStats, set by QueryStatsOption.
+type queryStatsOptions struct {
+	maxStatements int
+	maxDuration   time.Duration
+}
+
+// QueryStatsOption represents an option for QueryStats, created by WithXXXBudget functions.
+type QueryStatsOption func(*queryStatsOptions)
+
+// WithStatementBudget returns a QueryStatsOption with max statement count, further statements will fail with QueryBudgetExceededError
+// once exceeded, defaults to 0, means no limit.
+func WithStatementBudget(max int) QueryStatsOption {
+	return func(o *queryStatsOptions) {
+		o.maxStatements = max
+	}
+}
+
+// WithDurationBudget returns a QueryStatsOption with max total DB time, further statements will fail with QueryBudgetExceededError once
+// exceeded, defaults to 0, means no limit.
+func WithDurationBudget(max time.Duration) QueryStatsOption {
+	return func(o *queryStatsOptions) {
+		o.maxDuration = max
+	}
+}
+
+// QueryStats represents a statement statistics collector, which counts statements, total DB time, rows read and rows affected through
+// gorm callbacks, broken down by table. This is designed to be created per unit of work, such as per HTTP request, and is safe for
+// concurrent use.
+type QueryStats struct {
+	options *queryStatsOptions
+
+	mu     sync.Mutex
+	total  TableQueryStats
+	tables map[string]*TableQueryStats
+	refuse int
+}
+
+// NewQueryStats creates a new QueryStats with given QueryStatsOption-s, note that HookQueryStats must be invoked on the gorm.DB before
+// using this collector.
+// Example:
+// 	xgorm.HookQueryStats(db) // only once
+// 	stats := xgorm.NewQueryStats(xgorm.WithStatementBudget(100))
+// 	rdb := stats.Attach(db) // use this gorm.DB in a request
+// 	log.Println(stats.Snapshot().Statements)
+func NewQueryStats(options ...QueryStatsOption) *QueryStats {
+	opt := &queryStatsOptions{}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	return &QueryStats{options: opt, tables: make(map[string]*TableQueryStats)}
+}
+
+// Attach returns a new gorm.DB bound with this QueryStats.
+func (q *QueryStats) Attach(db *gorm.DB) *gorm.DB {
+	return db.Set(queryStatsKey, q)
+}
+
+// Snapshot returns a copied QueryStatsSnapshot of current statistics.
+func (q *QueryStats) Snapshot() *QueryStatsSnapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tables := make(map[string]*TableQueryStats, len(q.tables))
+	for name, stats := range q.tables {
+		copied := *stats
+		tables[name] = &copied
+	}
+	return &QueryStatsSnapshot{TableQueryStats: q.total, Tables: tables, Refused: q.refuse}
+}
+
+// Reset clears all statistics of this collector.
+func (q *QueryStats) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.total = TableQueryStats{}
+	q.tables = make(map[string]*TableQueryStats)
+	q.refuse = 0
+}
+
+// check checks the budget before executing a statement, and returns QueryBudgetExceededError if exceeded.
+func (q *QueryStats) check() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	maxStatements, maxDuration := q.options.maxStatements, q.options.maxDuration
+	if (maxStatements > 0 && q.total.Statements >= maxStatements) || (maxDuration > 0 && q.total.Duration >= maxDuration) {
+		q.refuse++
+		return &QueryBudgetExceededError{MaxStatements: maxStatements, MaxDuration: maxDuration, Statements: q.total.Statements, Duration: q.total.Duration}
+	}
+	return nil
+}
+
+// record records an executed statement.
+func (q *QueryStats) record(table string, duration time.Duration, rowsRead, rowsAffected int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.total.add(duration, rowsRead, rowsAffected)
+	if table == "" {
+		return
+	}
+	stats, ok := q.tables[table]
+	if !ok {
+		stats = &TableQueryStats{}
+		q.tables[table] = stats
+	}
+	stats.add(duration, rowsRead, rowsAffected)
+}
+
+// HookQueryStats hooks gorm.DB to collect statement statistics for QueryStats, note that statements will only be collected when the
+// gorm.DB is attached by QueryStats.Attach. Refused statements fail with QueryBudgetExceededError, for DB.Row, the error is returned
+// by the sql.Row's Scan method, because sql.Row can not carry an error by itself.
+func HookQueryStats(db *gorm.DB) *gorm.DB {
+	// query
+	db.Callback().Query().
+		Before("gorm:query").
+		Register("query_stats_before_query_callback", queryStatsBeforeCallback)
+	db.Callback().Query().
+		After("gorm:query").
+		Register("query_stats_after_query_callback", queryStatsAfterCallback(true))
+
+	// row query
+	db.Callback().RowQuery().
+		Before("gorm:row_query").
+		Register("query_stats_before_row_query_callback", queryStatsBeforeCallback)
+	db.Callback().RowQuery().
+		After("gorm:row_query").
+		Register("query_stats_after_row_query_callback", queryStatsAfterCallback(false))
+
+	// update
+	db.Callback().Update().
+		Before("gorm:update").
+		Register("query_stats_before_update_callback", queryStatsBeforeCallback)
+	db.Callback().Update().
+		After("gorm:update").
+		Register("query_stats_after_update_callback", queryStatsAfterCallback(false))
+
+	// delete
+	db.Callback().Delete().
+		Before("gorm:delete").
+		Register("query_stats_before_delete_callback", queryStatsBeforeCallback)
+	db.Callback().Delete().
+		After("gorm:delete").
+		Register("query_stats_after_delete_callback", queryStatsAfterCallback(false))
+
+	// create
+	db.Callback().Create().
+		Before("gorm:create").
+		Register("query_stats_before_create_callback", queryStatsBeforeCallback)
+	db.Callback().Create().
+		After("gorm:create").
+		Register("query_stats_after_create_callback", queryStatsAfterCallback(false))
+
+	return db
+}
+
+// getQueryStats returns the QueryStats attached to the scope.
+func getQueryStats(scope *gorm.Scope) (*QueryStats, bool) {
+	val, ok := scope.Get(queryStatsKey)
+	if !ok {
+		return nil, false
+	}
+	stats, ok := val.(*QueryStats)
+	return stats, ok && stats != nil
+}
+
+// queryStatsBeforeCallback is a callback before gorm:query, gorm:row_query, gorm:update, gorm:delete and gorm:create used in HookQueryStats.
+func queryStatsBeforeCallback(scope *gorm.Scope) {
+	stats, ok := getQueryStats(scope)
+	if !ok || scope.HasError() {
+		return
+	}
+
+	err := stats.check()
+	if err == nil {
+		scope.InstanceSet(queryStatsStartKey, time.Now())
+		return
+	}
+	_ = scope.Err(err)
+
+	// gorm:row_query does not check scope's error, so replace the result to skip executing, Row will report the error when scanning
+	blockRowQuery(scope, err)
+}
+
+// queryStatsAfterCallback is a callback after gorm:query, gorm:row_query, gorm:update, gorm:delete and gorm:create used in HookQueryStats.
+func queryStatsAfterCallback(read bool) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		stats, ok := getQueryStats(scope)
+		if !ok {
+			return
+		}
+		start, ok := scope.InstanceGet(queryStatsStartKey)
+		if !ok || scope.SQL == "" {
+			return // refused or not executed
+		}
+		duration := time.Since(start.(time.Time))
+
+		var rowsRead, rowsAffected int64
+		if scope.DB().Error == nil || scope.DB().Error == gorm.ErrRecordNotFound {
+			if read {
+				rowsRead = scope.DB().RowsAffected
+			} else if _, isRowQuery := scope.InstanceGet("row_query_result"); !isRowQuery {
+				rowsAffected = scope.DB().RowsAffected
+			}
+		}
+		stats.record(scope.TableName(), duration, rowsRead, rowsAffected)
+	}
+}
@@ -0,0 +1,552 @@
+package xgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// RecorderMode represents the mode of Recorder, can be RecordMode or ReplayMode.
+type RecorderMode int
+
+const (
+	// ReplayMode means Recorder serves the statements from the golden file, without a real database.
+	ReplayMode RecorderMode = iota
+
+	// RecordMode means Recorder executes the statements against a real database, and records them to the golden file.
+	RecordMode
+)
+
+// recordValue represents a typed value in the golden file, which is used for statement arguments and result values.
+type recordValue struct {
+	Type  string `json:"type"` // null, int64, float64, bool, string, bytes, base64 and time
+	Value string `json:"value,omitempty"`
+}
+
+// encodeRecordValue encodes given driver value to recordValue.
+func encodeRecordValue(value interface{}) recordValue {
+	switch v := value.(type) {
+	case nil:
+		return recordValue{Type: "null"}
+	case int64:
+		return recordValue{Type: "int64", Value: strconv.FormatInt(v, 10)}
+	case float64:
+		return recordValue{Type: "float64", Value: strconv.FormatFloat(v, 'g', -1, 64)}
+	case bool:
+		return recordValue{Type: "bool", Value: strconv.FormatBool(v)}
+	case string:
+		return recordValue{Type: "string", Value: v}
+	case []byte:
+		if !utf8.Valid(v) {
+			return recordValue{Type: "base64", Value: base64.StdEncoding.EncodeToString(v)}
+		}
+		return recordValue{Type: "bytes", Value: string(v)}
+	case time.Time:
+		return recordValue{Type: "time", Value: v.Format(time.RFC3339Nano)}
+	}
+	if converted, err := driver.DefaultParameterConverter.ConvertValue(value); err == nil {
+		return encodeRecordValue(converted)
+	}
+	return recordValue{Type: "string", Value: fmt.Sprint(value)}
+}
+
+// decode decodes recordValue to driver value.
+func (r recordValue) decode() (interface{}, error) {
+	switch r.Type {
+	case "null":
+		return nil, nil
+	case "int64":
+		return strconv.ParseInt(r.Value, 10, 64)
+	case "float64":
+		return strconv.ParseFloat(r.Value, 64)
+	case "bool":
+		return strconv.ParseBool(r.Value)
+	case "string":
+		return r.Value, nil
+	case "bytes":
+		return []byte(r.Value), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(r.Value)
+	case "time":
+		return time.Parse(time.RFC3339Nano, r.Value)
+	}
+	return nil, fmt.Errorf("xgorm: unknown record value type %s", r.Type)
+}
+
+// queryRecord represents a recorded statement in the golden file.
+type queryRecord struct {
+	SQL          string          `json:"sql"`
+	Args         []recordValue   `json:"args,omitempty"`
+	Columns      []string        `json:"columns,omitempty"`
+	Rows         [][]recordValue `json:"rows,omitempty"`
+	RowsAffected int64           `json:"rows_affected,omitempty"`
+	LastInsertId *int64          `json:"last_insert_id,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	ErrorCode    string          `json:"error_code,omitempty"` // mysql errno, postgres sqlstate, or sqlite3 extended errno
+
+	normalized string
+}
+
+// matchArgs checks if the recorded arguments equal to given arguments, note that time arguments are not compared, because they are
+// usually generated by time.Now.
+func (q *queryRecord) matchArgs(args []recordValue) bool {
+	if len(q.Args) != len(args) {
+		return false
+	}
+	for i, arg := range args {
+		if q.Args[i].Type != arg.Type || (arg.Type != "time" && q.Args[i].Value != arg.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// err returns the recorded error, and restores the driver's error type by dialect, so that the helpers such as CreateErr still work.
+func (q *queryRecord) err(dialect string) error {
+	if q.Error == "" {
+		return nil
+	}
+	if q.ErrorCode != "" {
+		switch dialect {
+		case "mysql":
+			if errno, err := strconv.ParseUint(q.ErrorCode, 10, 16); err == nil {
+				return &mysql.MySQLError{Number: uint16(errno), Message: q.Error}
+			}
+		case "postgres":
+			return &pq.Error{Code: pq.ErrorCode(q.ErrorCode), Message: q.Error}
+		case "sqlite3":
+			if err, ok := newSQLiteError(q.ErrorCode); ok {
+				return err
+			}
+		}
+	}
+	return errors.New(q.Error)
+}
+
+// setErr sets the error and its code of driver to queryRecord.
+func (q *queryRecord) setErr(err error) {
+	q.Error = err.Error()
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &mysqlErr):
+		q.ErrorCode = strconv.Itoa(int(mysqlErr.Number))
+	case errors.As(err, &pqErr):
+		q.ErrorCode = string(pqErr.Code)
+	default:
+		q.ErrorCode, _ = sqliteErrorCode(err)
+	}
+}
+
+// recorderOptions represents some options for Recorder, set by RecorderOption.
+type recorderOptions struct {
+	mode   RecorderMode
+	strict bool
+}
+
+// RecorderOption represents an option for Recorder, created by WithRecorderXXX functions.
+type RecorderOption func(*recorderOptions)
+
+// WithRecorderMode returns a RecorderOption with RecorderMode, defaults to ReplayMode.
+func WithRecorderMode(mode RecorderMode) RecorderOption {
+	return func(o *recorderOptions) {
+		o.mode = mode
+	}
+}
+
+// WithRecorderStrict returns a RecorderOption with strict switcher, defaults to true, means the statements must be replayed in the recorded
+// order with the same normalized sql and arguments, otherwise the statements can be replayed in any order, and the one with the same
+// normalized sql and arguments is preferred, then the one with the same normalized sql only.
+func WithRecorderStrict(strict bool) RecorderOption {
+	return func(o *recorderOptions) {
+		o.strict = strict
+	}
+}
+
+// Recorder represents a database/sql driver wrapper for deterministic tests, which records every statement, its arguments and results to
+// a golden file against a real database in RecordMode, and serves these recordings in ReplayMode without a real database. Statements are
+// matched by NormalizeSQL.
+type Recorder struct {
+	golden  string
+	options *recorderOptions
+
+	mu      sync.Mutex
+	records []*queryRecord
+	used    []bool
+	next    int
+	dbs     map[string]*sql.DB // dsn -> real database, only used in RecordMode
+}
+
+// NewRecorder creates a new Recorder with given golden file path and RecorderOption-s.
+// Example:
+// 	rec := xgorm.NewRecorder("testdata/user.golden.json", xgorm.WithRecorderMode(xgorm.RecordMode))
+// 	_ = rec.Register("mysql_recorder", "mysql")
+// 	defer rec.Close() // save golden file in RecordMode
+// 	db, err := gorm.Open("mysql_recorder", dsn) // dsn is ignored in ReplayMode
+// 	defer db.Close()
+func NewRecorder(golden string, options ...RecorderOption) *Recorder {
+	opt := &recorderOptions{mode: ReplayMode, strict: true}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	return &Recorder{golden: golden, options: opt, dbs: make(map[string]*sql.DB)}
+}
+
+// Register registers a database/sql driver and a gorm dialect with given name, the dialect is a copy of given registered gorm dialect,
+// which is also used as the real driver name in RecordMode. In ReplayMode, the golden file will be loaded. Note that a name can only
+// be registered once in a process.
+func (r *Recorder) Register(name, dialect string) error {
+	base, ok := gorm.GetDialect(dialect)
+	if !ok {
+		return fmt.Errorf("xgorm: dialect %s is not registered", dialect)
+	}
+	for _, driverName := range sql.Drivers() {
+		if driverName == name {
+			return fmt.Errorf("xgorm: driver %s has been registered", name)
+		}
+	}
+	if r.options.mode == ReplayMode {
+		if err := r.load(); err != nil {
+			return err
+		}
+	}
+	sql.Register(name, &recorderDriver{recorder: r, dialect: dialect})
+	gorm.RegisterDialect(name, base)
+	return nil
+}
+
+// load loads the records from golden file.
+func (r *Recorder) load() error {
+	bs, err := ioutil.ReadFile(r.golden)
+	if err != nil {
+		return err
+	}
+	records := make([]*queryRecord, 0)
+	if err = json.Unmarshal(bs, &records); err != nil {
+		return fmt.Errorf("xgorm: failed to parse golden file %s: %w", r.golden, err)
+	}
+	for _, record := range records {
+		record.normalized = NormalizeSQL(record.SQL)
+	}
+	r.mu.Lock()
+	r.records, r.used, r.next = records, make([]bool, len(records)), 0
+	r.mu.Unlock()
+	return nil
+}
+
+// Unused returns the count of unused records in ReplayMode, which can be used to check if all the recorded statements are replayed.
+func (r *Recorder) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cnt := 0
+	for _, used := range r.used {
+		if !used {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Close saves the records to golden file and closes the real databases in RecordMode, it does nothing in ReplayMode.
+func (r *Recorder) Close() error {
+	if r.options.mode != RecordMode {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for dsn, db := range r.dbs {
+		_ = db.Close()
+		delete(r.dbs, dsn)
+	}
+	bs, err := json.MarshalIndent(r.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(r.golden), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.golden, bs, 0644)
+}
+
+// add adds a record in RecordMode.
+func (r *Recorder) add(record *queryRecord) {
+	r.mu.Lock()
+	r.records = append(r.records, record)
+	r.mu.Unlock()
+}
+
+// match finds the matched record for given statement in ReplayMode.
+func (r *Recorder) match(query string, args []recordValue) (*queryRecord, error) {
+	normalized := NormalizeSQL(query)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.options.strict {
+		if r.next >= len(r.records) {
+			return nil, fmt.Errorf("xgorm: no more record for statement `%s`", query)
+		}
+		record := r.records[r.next]
+		if record.normalized != normalized || !record.matchArgs(args) {
+			return nil, fmt.Errorf("xgorm: statement `%s` does not match the record `%s`", query, record.SQL)
+		}
+		r.used[r.next] = true
+		r.next++
+		return record, nil
+	}
+
+	found := -1
+	for i, record := range r.records {
+		if r.used[i] || record.normalized != normalized {
+			continue
+		}
+		if record.matchArgs(args) {
+			found = i
+			break
+		}
+		if found == -1 {
+			found = i
+		}
+	}
+	if found == -1 {
+		return nil, fmt.Errorf("xgorm: no record for statement `%s`", query)
+	}
+	r.used[found] = true
+	return r.records[found], nil
+}
+
+// recorderDriver is a driver.Driver used in Recorder.
+type recorderDriver struct {
+	recorder *Recorder
+	dialect  string
+}
+
+// Open implements driver.Driver, a connection of the real database is pinned in RecordMode.
+func (d *recorderDriver) Open(dsn string) (driver.Conn, error) {
+	r := d.recorder
+	if r.options.mode != RecordMode {
+		return &recorderConn{driver: d}, nil
+	}
+
+	r.mu.Lock()
+	db, ok := r.dbs[dsn]
+	if !ok {
+		var err error
+		db, err = sql.Open(d.dialect, dsn)
+		if err != nil {
+			r.mu.Unlock()
+			return nil, err
+		}
+		r.dbs[dsn] = db
+	}
+	r.mu.Unlock()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &recorderConn{driver: d, conn: conn}, nil
+}
+
+// recorderConn is a driver.Conn used in Recorder.
+type recorderConn struct {
+	driver *recorderDriver
+	conn   *sql.Conn // nil in ReplayMode
+	tx     *sql.Tx
+}
+
+var (
+	_ driver.Conn           = &recorderConn{}
+	_ driver.ConnBeginTx    = &recorderConn{}
+	_ driver.ExecerContext  = &recorderConn{}
+	_ driver.QueryerContext = &recorderConn{}
+)
+
+// Prepare implements driver.Conn.
+func (c *recorderConn) Prepare(query string) (driver.Stmt, error) {
+	return &unpreparedStmt{conn: c, query: query}, nil
+}
+
+// Close implements driver.Conn.
+func (c *recorderConn) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	if c.tx != nil {
+		_ = c.tx.Rollback()
+	}
+	return c.conn.Close()
+}
+
+// Begin implements driver.Conn.
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx, transactions are not recorded, and do nothing in ReplayMode.
+func (c *recorderConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.conn != nil {
+		tx, err := c.conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.IsolationLevel(opts.Isolation), ReadOnly: opts.ReadOnly})
+		if err != nil {
+			return nil, err
+		}
+		c.tx = tx
+	}
+	return &recorderTx{conn: c}, nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, all the values are passed to the real driver as is.
+func (c *recorderConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+// recordArgs encodes given arguments to recordValue-s.
+func recordArgs(args []driver.NamedValue) []recordValue {
+	out := make([]recordValue, len(args))
+	for i, arg := range args {
+		out[i] = encodeRecordValue(arg.Value)
+	}
+	return out
+}
+
+// ExecContext implements driver.ExecerContext.
+func (c *recorderConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.conn == nil {
+		record, err := c.driver.recorder.match(query, recordArgs(args))
+		if err != nil {
+			return nil, err
+		}
+		if err = record.err(c.driver.dialect); err != nil {
+			return nil, err
+		}
+		return &recorderResult{record: record}, nil
+	}
+
+	record := &queryRecord{SQL: query, Args: recordArgs(args)}
+	var result sql.Result
+	var err error
+	if c.tx != nil {
+		result, err = c.tx.ExecContext(ctx, query, namedValueArgs(args)...)
+	} else {
+		result, err = c.conn.ExecContext(ctx, query, namedValueArgs(args)...)
+	}
+	if err != nil {
+		record.setErr(err)
+	} else {
+		record.RowsAffected, _ = result.RowsAffected()
+		if id, err := result.LastInsertId(); err == nil {
+			record.LastInsertId = &id
+		}
+	}
+	c.driver.recorder.add(record)
+	return result, err
+}
+
+// QueryContext implements driver.QueryerContext.
+func (c *recorderConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.conn == nil {
+		record, err := c.driver.recorder.match(query, recordArgs(args))
+		if err != nil {
+			return nil, err
+		}
+		if err = record.err(c.driver.dialect); err != nil {
+			return nil, err
+		}
+		rows := &bufferedRows{columns: record.Columns, values: make([][]interface{}, 0, len(record.Rows))}
+		for _, row := range record.Rows {
+			values := make([]interface{}, len(row))
+			for i, value := range row {
+				if values[i], err = value.decode(); err != nil {
+					return nil, err
+				}
+			}
+			rows.values = append(rows.values, values)
+		}
+		return rows, nil
+	}
+
+	record := &queryRecord{SQL: query, Args: recordArgs(args)}
+	var sqlRows *sql.Rows
+	var err error
+	if c.tx != nil {
+		sqlRows, err = c.tx.QueryContext(ctx, query, namedValueArgs(args)...)
+	} else {
+		sqlRows, err = c.conn.QueryContext(ctx, query, namedValueArgs(args)...)
+	}
+	var rows *bufferedRows
+	if err == nil {
+		rows, err = readBufferedRows(sqlRows)
+	}
+	if err != nil {
+		record.setErr(err)
+		c.driver.recorder.add(record)
+		return nil, err
+	}
+	record.Columns = rows.columns
+	for _, values := range rows.values {
+		row := make([]recordValue, len(values))
+		for i, value := range values {
+			row[i] = encodeRecordValue(value)
+		}
+		record.Rows = append(record.Rows, row)
+	}
+	c.driver.recorder.add(record)
+	return rows, nil
+}
+
+// recorderTx is a driver.Tx used in Recorder.
+type recorderTx struct {
+	conn *recorderConn
+}
+
+// Commit implements driver.Tx.
+func (t *recorderTx) Commit() error {
+	if t.conn.tx == nil {
+		return nil
+	}
+	err := t.conn.tx.Commit()
+	t.conn.tx = nil
+	return err
+}
+
+// Rollback implements driver.Tx.
+func (t *recorderTx) Rollback() error {
+	if t.conn.tx == nil {
+		return nil
+	}
+	err := t.conn.tx.Rollback()
+	t.conn.tx = nil
+	return err
+}
+
+// recorderResult is a driver.Result used in Recorder's ReplayMode.
+type recorderResult struct {
+	record *queryRecord
+}
+
+// LastInsertId implements driver.Result.
+func (r *recorderResult) LastInsertId() (int64, error) {
+	if r.record.LastInsertId == nil {
+		return 0, errors.New("xgorm: LastInsertId is not recorded")
+	}
+	return *r.record.LastInsertId, nil
+}
+
+// RowsAffected implements driver.Result.
+func (r *recorderResult) RowsAffected() (int64, error) {
+	return r.record.RowsAffected, nil
+}
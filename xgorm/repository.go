@@ -0,0 +1,248 @@
+package xgorm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Aoi-hosizora/ahlib/xstatus"
+	"github.com/jinzhu/gorm"
+	"reflect"
+)
+
+const (
+	// panicNonStructModel is the panic message when creating Repository with non-struct model.
+	panicNonStructModel = "xgorm: repository with non-struct model"
+)
+
+// errRepositoryNoCondition is the error returned when updating or deleting by Repository without any condition.
+var errRepositoryNoCondition = errors.New("xgorm: repository update or delete without condition")
+
+// Repository represents a reflection-based repository bound to a model type, which wraps gorm's CRUD methods and checks the results by
+// QueryErr, CreateErr, UpdateErr and DeleteErr. Note that the soft-delete semantics depend on the given gorm.DB, that is, a gorm.DB hooked
+// by HookDeletedAt will filter the soft-deleted rows in queries, and use soft delete in Delete.
+type Repository struct {
+	db        *gorm.DB
+	modelType reflect.Type
+	dict      PropertyDict
+}
+
+// NewRepository creates a new Repository bound to the type of given model, panics when using non-struct model, the dict is used to
+// generate the order expression in List, and can be nil.
+// Example:
+// 	repo := xgorm.NewRepository(db, &User{}, xgorm.PropertyDict{
+// 		"uid":  xgorm.NewPropertyValue(false, "uid"),
+// 		"name": xgorm.NewPropertyValue(false, "name"),
+// 	})
+// 	user := &User{}
+// 	status, err := repo.Get(user, "uid = ?", 1)
+func NewRepository(db *gorm.DB, model interface{}, dict PropertyDict) *Repository {
+	typ := reflect.TypeOf(model)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		panic(panicNonStructModel)
+	}
+	return &Repository{db: db, modelType: typ, dict: dict}
+}
+
+// WithTx returns a copy of Repository which uses the given gorm.DB (such as a transaction from db.Begin) to execute statements.
+// Example:
+// 	err := db.Transaction(func(tx *gorm.DB) error {
+// 		_, err := repo.WithTx(tx).Create(user)
+// 		return err
+// 	})
+func (r *Repository) WithTx(tx *gorm.DB) *Repository {
+	return &Repository{db: tx, modelType: r.modelType, dict: r.dict}
+}
+
+// DB returns the gorm.DB used by Repository.
+func (r *Repository) DB() *gorm.DB {
+	return r.db
+}
+
+// newModel creates a new pointer of bound model type.
+func (r *Repository) newModel() interface{} {
+	return reflect.New(r.modelType).Interface()
+}
+
+// checkType checks if the given value's type is pointer to the bound model type, or pointer to slice of the bound model type.
+func (r *Repository) checkType(value interface{}, slice bool) error {
+	typ := reflect.TypeOf(value)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		return fmt.Errorf("xgorm: repository requires pointer, got %T", value)
+	}
+	typ = typ.Elem()
+	if slice {
+		if typ.Kind() != reflect.Slice {
+			return fmt.Errorf("xgorm: repository requires pointer to slice, got %T", value)
+		}
+		typ = typ.Elem()
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+	}
+	if typ != r.modelType {
+		return fmt.Errorf("xgorm: repository requires model type %s, got %T", r.modelType.String(), value)
+	}
+	return nil
+}
+
+// where returns a gorm.DB of bound model with given conditions, the conditions are passed to gorm.DB's Where, such as "uid = ?", 1.
+func (r *Repository) where(where []interface{}) *gorm.DB {
+	db := r.db.Model(r.newModel())
+	if len(where) > 0 {
+		db = db.Where(where[0], where[1:]...)
+	}
+	return db
+}
+
+// Get queries the first row by given conditions into dest, which must be a pointer to model, and returns xstatus.DbSuccess,
+// xstatus.DbNotFound or xstatus.DbFailed.
+// Example:
+// 	user := &User{}
+// 	status, err := repo.Get(user, "uid = ?", uid)
+func (r *Repository) Get(dest interface{}, where ...interface{}) (xstatus.DbStatus, error) {
+	if err := r.checkType(dest, false); err != nil {
+		return xstatus.DbFailed, err
+	}
+	return QueryErr(r.where(where).First(dest))
+}
+
+// listOptions represents some options for Repository's List, set by ListOption.
+type listOptions struct {
+	where []interface{}
+	order string
+	page  int32
+	limit int32
+	total *int64
+}
+
+// ListOption represents an option for Repository's List, created by WithListXXX functions.
+type ListOption func(*listOptions)
+
+// WithListWhere returns a ListOption with conditions, which are passed to gorm.DB's Where.
+func WithListWhere(query interface{}, args ...interface{}) ListOption {
+	return func(o *listOptions) {
+		o.where = append([]interface{}{query}, args...)
+	}
+}
+
+// WithListOrder returns a ListOption with order source string (such as "name desc, age"), which is translated by the Repository's
+// PropertyDict using GenerateOrderByExp.
+func WithListOrder(source string) ListOption {
+	return func(o *listOptions) {
+		o.order = source
+	}
+}
+
+// WithListPage returns a ListOption with pagination, page starts from 1, and non-positive limit means no pagination.
+func WithListPage(page, limit int32) ListOption {
+	return func(o *listOptions) {
+		o.page = page
+		o.limit = limit
+	}
+}
+
+// WithListTotal returns a ListOption with a pointer to store the total count of rows matched the conditions, ignoring pagination.
+func WithListTotal(total *int64) ListOption {
+	return func(o *listOptions) {
+		o.total = total
+	}
+}
+
+// List queries rows into dest, which must be a pointer to slice of model, with given ListOption-s, and returns xstatus.DbSuccess or
+// xstatus.DbFailed. Note that empty result is not regarded as xstatus.DbNotFound.
+// Example:
+// 	users := make([]*User, 0)
+// 	total := int64(0)
+// 	status, err := repo.List(&users, xgorm.WithListOrder("name desc"), xgorm.WithListPage(1, 20), xgorm.WithListTotal(&total))
+func (r *Repository) List(dest interface{}, options ...ListOption) (xstatus.DbStatus, error) {
+	if err := r.checkType(dest, true); err != nil {
+		return xstatus.DbFailed, err
+	}
+	opt := &listOptions{}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+
+	db := r.where(opt.where)
+	if opt.total != nil {
+		if err := db.Count(opt.total).Error; err != nil {
+			return xstatus.DbFailed, err
+		}
+	}
+	if opt.order != "" && r.dict != nil {
+		if order := GenerateOrderByExp(opt.order, r.dict); order != "" {
+			db = db.Order(order)
+		}
+	}
+	if opt.limit > 0 {
+		if opt.page < 1 {
+			opt.page = 1
+		}
+		db = db.Limit(opt.limit).Offset((opt.page - 1) * opt.limit)
+	}
+	return QueryErr(db.Find(dest))
+}
+
+// Create inserts the given model, which must be a pointer to model, and returns xstatus.DbSuccess, xstatus.DbExisted or xstatus.DbFailed.
+// Example:
+// 	status, err := repo.Create(&User{Name: "user1"})
+func (r *Repository) Create(model interface{}) (xstatus.DbStatus, error) {
+	if err := r.checkType(model, false); err != nil {
+		return xstatus.DbFailed, err
+	}
+	return CreateErr(r.db.Create(model))
+}
+
+// Update updates the rows matched the given conditions by values, which can be a map or a model (only non-blank fields are updated), and
+// returns xstatus.DbSuccess, xstatus.DbNotFound, xstatus.DbExisted or xstatus.DbFailed. The conditions must not be empty, to avoid updating
+// the whole table accidentally.
+// Example:
+// 	status, err := repo.Update(map[string]interface{}{"name": "user2"}, "uid = ?", uid)
+func (r *Repository) Update(values interface{}, where ...interface{}) (xstatus.DbStatus, error) {
+	if len(where) == 0 {
+		return xstatus.DbFailed, errRepositoryNoCondition
+	}
+	return UpdateErr(r.where(where).Updates(values))
+}
+
+// Delete deletes the rows matched the given conditions, which is soft delete if the gorm.DB is hooked by HookDeletedAt, and returns
+// xstatus.DbSuccess, xstatus.DbNotFound or xstatus.DbFailed. The conditions must not be empty, to avoid deleting the whole table
+// accidentally.
+// Example:
+// 	status, err := repo.Delete("uid = ?", uid)
+func (r *Repository) Delete(where ...interface{}) (xstatus.DbStatus, error) {
+	if len(where) == 0 {
+		return xstatus.DbFailed, errRepositoryNoCondition
+	}
+	return DeleteErr(r.where(where).Delete(r.newModel()))
+}
+
+// Exists checks if there is any row matched the given conditions, and returns xstatus.DbSuccess if exists, xstatus.DbNotFound if not
+// exists, or xstatus.DbFailed.
+// Example:
+// 	status, err := repo.Exists("name = ?", name)
+func (r *Repository) Exists(where ...interface{}) (xstatus.DbStatus, error) {
+	cnt := 0
+	if err := r.where(where).Limit(1).Count(&cnt).Error; err != nil {
+		return xstatus.DbFailed, err
+	}
+	if cnt == 0 {
+		return xstatus.DbNotFound, nil
+	}
+	return xstatus.DbSuccess, nil
+}
+
+// Count counts the rows matched the given conditions into total, and returns xstatus.DbSuccess or xstatus.DbFailed.
+// Example:
+// 	total := int64(0)
+// 	status, err := repo.Count(&total, "name LIKE ?", "user%")
+func (r *Repository) Count(total *int64, where ...interface{}) (xstatus.DbStatus, error) {
+	if err := r.where(where).Count(total).Error; err != nil {
+		return xstatus.DbFailed, err
+	}
+	return xstatus.DbSuccess, nil
+}
@@ -0,0 +1,268 @@
+package xgorm
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// resolverReplicaHint is the query hint prefix used to mark a statement which can be routed to replica.
+	resolverReplicaHint = "/* xgorm:replica */ "
+
+	// resolverPrimaryKey is the gorm.DB setting key for forcing primary, set by ForcePrimary.
+	resolverPrimaryKey = "xgorm:resolver_primary"
+
+	// panicNilPrimary is the panic message when using nil primary in NewResolver.
+	panicNilPrimary = "xgorm: using nil primary"
+)
+
+// Replica represents a read replica of Resolver, with its moving average latency.
+type Replica struct {
+	db      *sql.DB
+	latency int64 // ewma latency in nanoseconds, accessed atomically
+}
+
+// DB returns the sql.DB of Replica.
+func (r *Replica) DB() *sql.DB {
+	return r.db
+}
+
+// Latency returns the exponentially weighted moving average latency of Replica.
+func (r *Replica) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.latency))
+}
+
+// observe records a new latency to Replica, the weight of new latency is 1/8.
+func (r *Replica) observe(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&r.latency)
+		val := int64(d)
+		if old != 0 {
+			val = old + (int64(d)-old)/8
+		}
+		if atomic.CompareAndSwapInt64(&r.latency, old, val) {
+			return
+		}
+	}
+}
+
+// ReplicaPolicy represents a policy to choose a replica from Resolver's replicas, the given replicas will never be empty.
+type ReplicaPolicy interface {
+	Choose(replicas []*Replica) *Replica
+}
+
+// ReplicaPolicyFunc is a function which implements ReplicaPolicy.
+type ReplicaPolicyFunc func(replicas []*Replica) *Replica
+
+// Choose implements ReplicaPolicy.
+func (f ReplicaPolicyFunc) Choose(replicas []*Replica) *Replica {
+	return f(replicas)
+}
+
+// RandomPolicy returns a ReplicaPolicy which chooses replica randomly.
+func RandomPolicy() ReplicaPolicy {
+	return ReplicaPolicyFunc(func(replicas []*Replica) *Replica {
+		return replicas[rand.Intn(len(replicas))]
+	})
+}
+
+// RoundRobinPolicy returns a ReplicaPolicy which chooses replica in round-robin order.
+func RoundRobinPolicy() ReplicaPolicy {
+	var counter uint64
+	return ReplicaPolicyFunc(func(replicas []*Replica) *Replica {
+		idx := atomic.AddUint64(&counter, 1) - 1
+		return replicas[idx%uint64(len(replicas))]
+	})
+}
+
+// LeastLatencyPolicy returns a ReplicaPolicy which chooses replica with the least moving average latency, replicas which have never been
+// used will be chosen first.
+func LeastLatencyPolicy() ReplicaPolicy {
+	return ReplicaPolicyFunc(func(replicas []*Replica) *Replica {
+		chosen := replicas[0]
+		for _, r := range replicas[1:] {
+			if r.Latency() < chosen.Latency() {
+				chosen = r
+			}
+		}
+		return chosen
+	})
+}
+
+// Resolver represents a read/write splitting gorm.SQLCommon, which wraps a primary and some replicas. Statements generated by query and
+// row_query callbacks will be routed to replicas using ReplicaPolicy, and other statements (such as create, update, delete) and all
+// statements in transaction will be routed to primary.
+//
+// Note that Resolver uses "gorm:query_hint" to mark the routable statement, so HookResolver must be invoked on the opened gorm.DB.
+type Resolver struct {
+	primary  *sql.DB
+	replicas []*Replica
+	policy   ReplicaPolicy
+}
+
+var _ gorm.SQLCommon = &Resolver{}
+
+// NewResolver creates a Resolver using given primary, ReplicaPolicy and replicas, panics when using nil primary, uses RandomPolicy when
+// giving nil policy. Note that all statements will be routed to primary if no replica is given.
+// Example:
+// 	primary, _ := sql.Open("mysql", primaryDsl)
+// 	replica1, _ := sql.Open("mysql", replica1Dsl)
+// 	replica2, _ := sql.Open("mysql", replica2Dsl)
+// 	resolver := xgorm.NewResolver(primary, xgorm.RoundRobinPolicy(), replica1, replica2)
+// 	db, err := gorm.Open("mysql", resolver)
+// 	xgorm.HookResolver(db)
+func NewResolver(primary *sql.DB, policy ReplicaPolicy, replicas ...*sql.DB) *Resolver {
+	if primary == nil {
+		panic(panicNilPrimary)
+	}
+	if policy == nil {
+		policy = RandomPolicy()
+	}
+	rs := make([]*Replica, 0, len(replicas))
+	for _, r := range replicas {
+		if r != nil {
+			rs = append(rs, &Replica{db: r})
+		}
+	}
+	return &Resolver{primary: primary, replicas: rs, policy: policy}
+}
+
+// Primary returns the primary sql.DB of Resolver.
+func (r *Resolver) Primary() *sql.DB {
+	return r.primary
+}
+
+// Replicas returns the replicas of Resolver.
+func (r *Resolver) Replicas() []*Replica {
+	return r.replicas
+}
+
+// resolve checks the replica hint of given query, and returns the chosen replica (nil if using primary) and the query without hint.
+func (r *Resolver) resolve(query string) (*Replica, string) {
+	if !strings.HasPrefix(query, resolverReplicaHint) {
+		return nil, query
+	}
+	query = strings.TrimPrefix(query, resolverReplicaHint)
+	if len(r.replicas) == 0 {
+		return nil, query
+	}
+	return r.policy.Choose(r.replicas), query
+}
+
+// Exec executes a query on primary, implements gorm.SQLCommon.
+func (r *Resolver) Exec(query string, args ...interface{}) (sql.Result, error) {
+	_, query = r.resolve(query)
+	return r.primary.Exec(query, args...)
+}
+
+// Prepare creates a prepared statement on primary, implements gorm.SQLCommon.
+func (r *Resolver) Prepare(query string) (*sql.Stmt, error) {
+	_, query = r.resolve(query)
+	return r.primary.Prepare(query)
+}
+
+// Query executes a query that returns rows on replica or primary, implements gorm.SQLCommon.
+func (r *Resolver) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	replica, query := r.resolve(query)
+	if replica == nil {
+		return r.primary.Query(query, args...)
+	}
+	start := time.Now()
+	rows, err := replica.db.Query(query, args...)
+	replica.observe(time.Since(start))
+	return rows, err
+}
+
+// QueryRow executes a query that returns at most one row on replica or primary, implements gorm.SQLCommon.
+func (r *Resolver) QueryRow(query string, args ...interface{}) *sql.Row {
+	replica, query := r.resolve(query)
+	if replica == nil {
+		return r.primary.QueryRow(query, args...)
+	}
+	start := time.Now()
+	row := replica.db.QueryRow(query, args...)
+	replica.observe(time.Since(start))
+	return row
+}
+
+// Begin starts a transaction on primary, used by gorm.DB.
+func (r *Resolver) Begin() (*sql.Tx, error) {
+	return r.primary.Begin()
+}
+
+// BeginTx starts a transaction with context and options on primary, used by gorm.DB.
+func (r *Resolver) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return r.primary.BeginTx(ctx, opts)
+}
+
+// Close closes primary and all replicas, returns the first error.
+func (r *Resolver) Close() error {
+	err := r.primary.Close()
+	for _, replica := range r.replicas {
+		if e := replica.db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ForcePrimary returns a new gorm.DB which forces the query and row_query statements to be routed to primary, this is useful when
+// reading your own writes.
+func ForcePrimary(db *gorm.DB) *gorm.DB {
+	return db.Set(resolverPrimaryKey, true)
+}
+
+// HookResolver hooks gorm.DB to mark the query and row_query statements as routable to replica, note that this will only take effect
+// when the gorm.DB is opened by a Resolver.
+func HookResolver(db *gorm.DB) *gorm.DB {
+	// query
+	db.Callback().Query().
+		Before("gorm:query").
+		Register("resolver_before_query_callback", resolverQueryCallback)
+
+	// row query
+	db.Callback().RowQuery().
+		Before("gorm:row_query").
+		Register("resolver_before_row_query_callback", resolverQueryCallback)
+
+	return db
+}
+
+// resolverQueryCallback is a callback for gorm:query, gorm:row_query used in HookResolver.
+func resolverQueryCallback(scope *gorm.Scope) {
+	if scope.HasError() {
+		return
+	}
+	if _, ok := scope.SQLDB().(*Resolver); !ok {
+		return // in transaction, or not using Resolver
+	}
+	if force, ok := scope.Get(resolverPrimaryKey); ok && force == true {
+		return
+	}
+
+	hint := ""
+	if str, ok := scope.Get("gorm:query_hint"); ok {
+		hint = fmt.Sprint(str)
+	}
+	if !strings.HasPrefix(hint, resolverReplicaHint) {
+		scope.Set("gorm:query_hint", resolverReplicaHint+hint)
+	}
+}
+
+// primarySQLDB returns the primary sql.DB of given gorm.DB, that is the sql.DB itself or the Resolver's primary, returns nil if the
+// gorm.DB is in transaction or using other gorm.SQLCommon.
+func primarySQLDB(db *gorm.DB) *sql.DB {
+	switch common := db.CommonDB().(type) {
+	case *sql.DB:
+		return common
+	case *Resolver:
+		return common.primary
+	}
+	return nil
+}
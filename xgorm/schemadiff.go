@@ -0,0 +1,625 @@
+package xgorm
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ColumnSchema represents a column's schema, which is parsed from model's gorm.Field or live database.
+type ColumnSchema struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`    // normalized type, such as "int", "varchar(255)", "datetime"
+	Nullable   bool   `json:"nullable"`
+	Default    string `json:"default"` // normalized default value without quotes, empty if no default value
+	PrimaryKey bool   `json:"primary_key"`
+
+	rawType    string // model's type in dialect, without constraints
+	definition string // model's column definition in dialect, including constraints
+}
+
+// IndexSchema represents an index's schema, which is parsed from model's gorm.Field tags or live database. Note that the name of the
+// unique constraint declared by "unique" tag is empty, because the name is generated by the database.
+type IndexSchema struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+
+	constraint bool // live index is created by constraint, such as postgres's unique constraint and sqlite's autoindex
+}
+
+// ColumnChange represents a column whose schema in model is different from the live database.
+type ColumnChange struct {
+	Name    string        `json:"name"`
+	Model   *ColumnSchema `json:"model"`
+	Live    *ColumnSchema `json:"live"`
+	Changes []string      `json:"changes"` // changed properties, that is "type", "nullable" and "default"
+}
+
+// SchemaDiff represents the difference between a model and its live table, returned by DiffSchema.
+type SchemaDiff struct {
+	Table          string          `json:"table"`
+	MissingTable   bool            `json:"missing_table"`   // table does not exist in database
+	MissingColumns []*ColumnSchema `json:"missing_columns"` // columns in model but not in database
+	ExtraColumns   []*ColumnSchema `json:"extra_columns"`   // columns in database but not in model
+	ChangedColumns []*ColumnChange `json:"changed_columns"` // columns with different type, nullability or default value
+	MissingIndexes []*IndexSchema  `json:"missing_indexes"` // indexes in model but not in database
+	ExtraIndexes   []*IndexSchema  `json:"extra_indexes"`   // indexes in database but not in model
+
+	dialect     gorm.Dialect
+	primaryKeys []string
+	dropColumn  bool // supports dropping column, sqlite3 supports it since 3.35.0
+}
+
+// HasDiff checks if the model is different from the live table.
+func (s *SchemaDiff) HasDiff() bool {
+	return s.MissingTable || len(s.MissingColumns) > 0 || len(s.ExtraColumns) > 0 || len(s.ChangedColumns) > 0 ||
+		len(s.MissingIndexes) > 0 || len(s.ExtraIndexes) > 0
+}
+
+// String returns the summary string of SchemaDiff.
+func (s *SchemaDiff) String() string {
+	if s.MissingTable {
+		return fmt.Sprintf("%s: missing table", s.Table)
+	}
+	sp := make([]string, 0)
+	for _, col := range s.MissingColumns {
+		sp = append(sp, "+column "+col.Name)
+	}
+	for _, col := range s.ExtraColumns {
+		sp = append(sp, "-column "+col.Name)
+	}
+	for _, change := range s.ChangedColumns {
+		sp = append(sp, fmt.Sprintf("~column %s(%s)", change.Name, strings.Join(change.Changes, ",")))
+	}
+	for _, idx := range s.MissingIndexes {
+		sp = append(sp, fmt.Sprintf("+index %s(%s)", idx.Name, strings.Join(idx.Columns, ",")))
+	}
+	for _, idx := range s.ExtraIndexes {
+		sp = append(sp, fmt.Sprintf("-index %s(%s)", idx.Name, strings.Join(idx.Columns, ",")))
+	}
+	return fmt.Sprintf("%s: %s", s.Table, strings.Join(sp, ", "))
+}
+
+// DiffSchema inspects the live schema of given models' tables (information_schema in mysql and postgres, PRAGMA table_info and
+// PRAGMA index_list in sqlite3), compares them with the models' gorm.Scope fields (type, nullability, default value) and indexes
+// (declared by "index", "unique_index" and "unique" tags), and returns the SchemaDiff-s of the models which are different from the
+// database. Note that the column types are compared after normalization, such as "integer" and "int(11)" are both treated as "int".
+// Example:
+// 	diffs, err := xgorm.DiffSchema(db, &User{}, &Order{})
+// 	for _, diff := range diffs {
+// 		log.Println(diff)
+// 		log.Println(strings.Join(diff.MigrationSQL(), ";\n"))
+// 	}
+func DiffSchema(db *gorm.DB, models ...interface{}) ([]*SchemaDiff, error) {
+	out := make([]*SchemaDiff, 0)
+	for _, model := range models {
+		diff, err := diffModelSchema(db, model)
+		if err != nil {
+			return nil, err
+		}
+		if diff.HasDiff() {
+			out = append(out, diff)
+		}
+	}
+	return out, nil
+}
+
+// diffModelSchema compares the model with its live table, and returns the SchemaDiff.
+func diffModelSchema(db *gorm.DB, model interface{}) (*SchemaDiff, error) {
+	scope := db.NewScope(model)
+	table := scope.TableName()
+	modelColumns, primaryKeys := parseModelColumns(scope)
+	modelIndexes := parseModelIndexes(scope)
+	diff := &SchemaDiff{Table: table, dialect: scope.Dialect(), primaryKeys: primaryKeys, dropColumn: true}
+	if IsSQLite(db) {
+		var version string
+		if err := db.Raw("SELECT sqlite_version()").Row().Scan(&version); err != nil {
+			return nil, err
+		}
+		sp := strings.SplitN(version, ".", 3)
+		major, _ := strconv.Atoi(sp[0])
+		minor := 0
+		if len(sp) > 1 {
+			minor, _ = strconv.Atoi(sp[1])
+		}
+		diff.dropColumn = major > 3 || (major == 3 && minor >= 35)
+	}
+
+	if !scope.Dialect().HasTable(table) {
+		diff.MissingTable = true
+		diff.MissingColumns = modelColumns
+		for _, idx := range modelIndexes {
+			if idx.Name != "" {
+				diff.MissingIndexes = append(diff.MissingIndexes, idx)
+			}
+		}
+		return diff, nil
+	}
+	liveColumns, err := queryLiveColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	liveIndexes, err := queryLiveIndexes(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	// columns
+	liveColumnMap := make(map[string]*ColumnSchema, len(liveColumns))
+	for _, col := range liveColumns {
+		liveColumnMap[strings.ToLower(col.Name)] = col
+	}
+	modelColumnMap := make(map[string]*ColumnSchema, len(modelColumns))
+	for _, col := range modelColumns {
+		modelColumnMap[strings.ToLower(col.Name)] = col
+		live, ok := liveColumnMap[strings.ToLower(col.Name)]
+		if !ok {
+			diff.MissingColumns = append(diff.MissingColumns, col)
+			continue
+		}
+		changes := make([]string, 0)
+		if col.Type != live.Type {
+			changes = append(changes, "type")
+		}
+		if col.Nullable != live.Nullable {
+			changes = append(changes, "nullable")
+		}
+		if col.Default != live.Default && !col.PrimaryKey {
+			changes = append(changes, "default")
+		}
+		if len(changes) > 0 {
+			diff.ChangedColumns = append(diff.ChangedColumns, &ColumnChange{Name: col.Name, Model: col, Live: live, Changes: changes})
+		}
+	}
+	for _, col := range liveColumns {
+		if _, ok := modelColumnMap[strings.ToLower(col.Name)]; !ok {
+			diff.ExtraColumns = append(diff.ExtraColumns, col)
+		}
+	}
+
+	// indexes
+	matched := make(map[*IndexSchema]bool, len(liveIndexes))
+	for _, idx := range modelIndexes {
+		found := false
+		for _, live := range liveIndexes {
+			if matched[live] || idx.Unique != live.Unique || !equalFoldStrings(idx.Columns, live.Columns) {
+				continue
+			}
+			if (idx.Name == "" && live.Unique) || strings.EqualFold(idx.Name, live.Name) {
+				matched[live], found = true, true
+				break
+			}
+		}
+		if !found {
+			diff.MissingIndexes = append(diff.MissingIndexes, idx)
+		}
+	}
+	for _, live := range liveIndexes {
+		if !matched[live] {
+			diff.ExtraIndexes = append(diff.ExtraIndexes, live)
+		}
+	}
+	return diff, nil
+}
+
+// equalFoldStrings checks if two string slices are equal under case-insensitivity.
+func equalFoldStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// some regexps used in normalizeColumnType, normalizeColumnDefault, parseModelColumns and SchemaDiff.MigrationSQL.
+var (
+	_intDisplayWidthRegexp = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|bigint)\(\d+\)`)
+	_postgresCastRegexp    = regexp.MustCompile(`::[a-z][a-z0-9_ ]*(\(\d+(,\d+)?\))?(\[\])?$`)
+	_typeConstraintRegexp  = regexp.MustCompile(`(?i)\s+(not null|null|unique|auto_increment|autoincrement|primary key)\b`)
+	_notNullUniqueRegexp   = regexp.MustCompile(`(?i)\s+(not null|unique)\b`)
+	_uniqueRegexp          = regexp.MustCompile(`(?i)\s+unique\b`)
+)
+
+// columnTypeAliases is used in normalizeColumnType, which maps the type prefixes to the normalized types.
+var columnTypeAliases = [][2]string{
+	{"tinyint(1)", "boolean"},
+	{"bool", "boolean"},
+	{"integer", "int"},
+	{"int4", "int"},
+	{"serial", "int"},
+	{"bigserial", "bigint"},
+	{"int8", "bigint"},
+	{"smallserial", "smallint"},
+	{"int2", "smallint"},
+	{"character varying", "varchar"},
+	{"character", "char"},
+	{"timestamp with time zone", "timestamptz"},
+	{"timestamp without time zone", "timestamp"},
+	{"double precision", "double"},
+	{"float8", "double"},
+	{"real", "float"},
+	{"float4", "float"},
+	{"decimal", "numeric"},
+}
+
+// normalizeColumnType normalizes given column type, which lowers the case, strips the constraints and integer display width, and
+// replaces the aliases.
+func normalizeColumnType(typ string) string {
+	typ = strings.ToLower(strings.TrimSpace(_typeConstraintRegexp.ReplaceAllString(" "+typ, "")))
+	typ = strings.Join(strings.Fields(typ), " ")
+	if typ != "tinyint(1)" {
+		typ = _intDisplayWidthRegexp.ReplaceAllString(typ, "$1")
+	}
+	for _, alias := range columnTypeAliases {
+		if typ == alias[0] || (strings.HasPrefix(typ, alias[0]) && !isIdentByte(typ[len(alias[0])])) {
+			typ = alias[1] + typ[len(alias[0]):]
+			break
+		}
+	}
+	return typ
+}
+
+// normalizeColumnDefault normalizes given column default value, which strips postgres's type cast, parentheses and quotes, and treats
+// "NULL" and postgres's sequence as no default value.
+func normalizeColumnDefault(value string) string {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	if lower == "null" || strings.HasPrefix(lower, "nextval(") {
+		return ""
+	}
+	value = strings.TrimSpace(value[:len(value)-len(_postgresCastRegexp.FindString(lower))])
+	for len(value) >= 2 && value[0] == '(' && value[len(value)-1] == ')' {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		value = strings.ReplaceAll(value[1:len(value)-1], value[:1]+value[:1], value[:1])
+	}
+	return value
+}
+
+// parseModelColumns parses the model's columns and primary key names from gorm.Scope.
+func parseModelColumns(scope *gorm.Scope) ([]*ColumnSchema, []string) {
+	columns := make([]*ColumnSchema, 0)
+	primaryKeys := make([]string, 0)
+	for _, field := range scope.GetModelStruct().StructFields {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		definition := scope.Dialect().DataTypeOf(field)
+		rawType := definition
+		for _, keyword := range []string{" DEFAULT ", " COMMENT "} {
+			if idx := strings.Index(strings.ToUpper(rawType), keyword); idx != -1 {
+				rawType = rawType[:idx]
+			}
+		}
+		rawType = strings.TrimSpace(_notNullUniqueRegexp.ReplaceAllString(" "+rawType, ""))
+		_, notNull := field.TagSettingsGet("NOT NULL")
+		defaultValue, _ := field.TagSettingsGet("DEFAULT")
+
+		columns = append(columns, &ColumnSchema{
+			Name:       field.DBName,
+			Type:       normalizeColumnType(rawType),
+			Nullable:   !notNull && !field.IsPrimaryKey,
+			Default:    normalizeColumnDefault(defaultValue),
+			PrimaryKey: field.IsPrimaryKey,
+			rawType:    rawType,
+			definition: definition,
+		})
+		if field.IsPrimaryKey {
+			primaryKeys = append(primaryKeys, field.DBName)
+		}
+	}
+	return columns, primaryKeys
+}
+
+// parseModelIndexes parses the model's indexes from gorm.Scope, in the same way as gorm's auto index.
+func parseModelIndexes(scope *gorm.Scope) []*IndexSchema {
+	indexes := make(map[string]*IndexSchema)
+	for _, field := range scope.GetModelStruct().StructFields {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		for _, setting := range []struct {
+			tag    string
+			kind   string
+			unique bool
+		}{{"INDEX", "idx", false}, {"UNIQUE_INDEX", "uix", true}} {
+			value, ok := field.TagSettingsGet(setting.tag)
+			if !ok {
+				continue
+			}
+			for _, name := range strings.Split(value, ",") {
+				if name == setting.tag || name == "" {
+					name = scope.Dialect().BuildKeyName(setting.kind, scope.TableName(), field.DBName)
+				}
+				name, column := scope.Dialect().NormalizeIndexAndColumn(name, field.DBName)
+				if idx, ok := indexes[name]; ok {
+					idx.Columns = append(idx.Columns, column)
+				} else {
+					indexes[name] = &IndexSchema{Name: name, Columns: []string{column}, Unique: setting.unique}
+				}
+			}
+		}
+	}
+
+	out := make([]*IndexSchema, 0, len(indexes))
+	for _, idx := range indexes {
+		out = append(out, idx)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	for _, field := range scope.GetModelStruct().StructFields {
+		if _, ok := field.TagSettingsGet("UNIQUE"); ok && field.IsNormal && !field.IsIgnored {
+			out = append(out, &IndexSchema{Name: "", Columns: []string{field.DBName}, Unique: true})
+		}
+	}
+	return out
+}
+
+// queryStringRecords queries given sql, and returns the string records keyed by lower-cased column names.
+func queryStringRecords(db *gorm.DB, sql string, values ...interface{}) ([]map[string]string, error) {
+	rows, err := db.Raw(sql, values...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanStringRecords(rows)
+}
+
+// queryLiveColumns queries the live columns of given table.
+func queryLiveColumns(db *gorm.DB, table string) ([]*ColumnSchema, error) {
+	columns := make([]*ColumnSchema, 0)
+	switch {
+	case IsMySQL(db):
+		records, err := queryStringRecords(db, "SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT, COLUMN_KEY FROM information_schema.COLUMNS "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", table)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			columns = append(columns, &ColumnSchema{
+				Name: r["column_name"], Type: normalizeColumnType(r["column_type"]), Nullable: r["is_nullable"] == "YES",
+				Default: normalizeColumnDefault(r["column_default"]), PrimaryKey: r["column_key"] == "PRI",
+			})
+		}
+	case IsPostgreSQL(db):
+		records, err := queryStringRecords(db, "SELECT column_name, data_type, character_maximum_length, is_nullable, column_default FROM information_schema.columns "+
+			"WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? ORDER BY ordinal_position", table)
+		if err != nil {
+			return nil, err
+		}
+		pkRecords, err := queryStringRecords(db, "SELECT kcu.column_name FROM information_schema.table_constraints tc JOIN information_schema.key_column_usage kcu "+
+			"ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema "+
+			"WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = CURRENT_SCHEMA() AND tc.table_name = ?", table)
+		if err != nil {
+			return nil, err
+		}
+		pks := make(map[string]bool, len(pkRecords))
+		for _, r := range pkRecords {
+			pks[r["column_name"]] = true
+		}
+		for _, r := range records {
+			typ := r["data_type"]
+			if r["character_maximum_length"] != "" {
+				typ += "(" + r["character_maximum_length"] + ")"
+			}
+			columns = append(columns, &ColumnSchema{
+				Name: r["column_name"], Type: normalizeColumnType(typ), Nullable: r["is_nullable"] == "YES",
+				Default: normalizeColumnDefault(r["column_default"]), PrimaryKey: pks[r["column_name"]],
+			})
+		}
+	default: // sqlite3
+		records, err := queryStringRecords(db, fmt.Sprintf("PRAGMA table_info(%s)", db.Dialect().Quote(table)))
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			pk := r["pk"] != "" && r["pk"] != "0"
+			columns = append(columns, &ColumnSchema{
+				Name: r["name"], Type: normalizeColumnType(r["type"]), Nullable: r["notnull"] == "0" && !pk,
+				Default: normalizeColumnDefault(r["dflt_value"]), PrimaryKey: pk,
+			})
+		}
+	}
+	return columns, nil
+}
+
+// queryLiveIndexes queries the live indexes (excluding primary key) of given table.
+func queryLiveIndexes(db *gorm.DB, table string) ([]*IndexSchema, error) {
+	indexes := make([]*IndexSchema, 0)
+	appendColumn := func(name, column string, unique, constraint bool) {
+		if len(indexes) > 0 && indexes[len(indexes)-1].Name == name {
+			indexes[len(indexes)-1].Columns = append(indexes[len(indexes)-1].Columns, column)
+			return
+		}
+		indexes = append(indexes, &IndexSchema{Name: name, Columns: []string{column}, Unique: unique, constraint: constraint})
+	}
+
+	switch {
+	case IsMySQL(db):
+		records, err := queryStringRecords(db, "SELECT INDEX_NAME, NON_UNIQUE, COLUMN_NAME FROM information_schema.STATISTICS "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME <> 'PRIMARY' ORDER BY INDEX_NAME, SEQ_IN_INDEX", table)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			appendColumn(r["index_name"], r["column_name"], r["non_unique"] == "0", false)
+		}
+	case IsPostgreSQL(db):
+		records, err := queryStringRecords(db, "SELECT i.relname AS index_name, ix.indisunique AS is_unique, a.attname AS column_name, "+
+			"EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = ix.indexrelid) AS is_constraint "+
+			"FROM pg_class t JOIN pg_index ix ON t.oid = ix.indrelid JOIN pg_class i ON i.oid = ix.indexrelid "+
+			"JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = ANY(ix.indkey) "+
+			"WHERE t.relname = ? AND t.relkind = 'r' AND pg_table_is_visible(t.oid) AND NOT ix.indisprimary "+
+			"ORDER BY i.relname, array_position(ix.indkey::int2[], a.attnum)", table)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			appendColumn(r["index_name"], r["column_name"], r["is_unique"] == "true", r["is_constraint"] == "true")
+		}
+	default: // sqlite3
+		records, err := queryStringRecords(db, fmt.Sprintf("PRAGMA index_list(%s)", db.Dialect().Quote(table)))
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return records[i]["name"] < records[j]["name"]
+		})
+		for _, r := range records {
+			if r["origin"] == "pk" {
+				continue
+			}
+			infoRecords, err := queryStringRecords(db, fmt.Sprintf("PRAGMA index_info(%s)", db.Dialect().Quote(r["name"])))
+			if err != nil {
+				return nil, err
+			}
+			sort.Slice(infoRecords, func(i, j int) bool {
+				si, _ := strconv.Atoi(infoRecords[i]["seqno"])
+				sj, _ := strconv.Atoi(infoRecords[j]["seqno"])
+				return si < sj
+			})
+			for _, info := range infoRecords {
+				appendColumn(r["name"], info["name"], r["unique"] == "1", r["origin"] == "u")
+			}
+		}
+	}
+	return indexes, nil
+}
+
+// MigrationSQL generates the migration statements to make the live table match the model, in the order of dropping extra indexes,
+// creating table, adding missing columns, altering changed columns, creating missing indexes and dropping extra columns. Note that
+// sqlite3 does not support altering columns (and dropping columns before 3.35.0), so the comment statement which starts with "--" will be
+// generated instead.
+func (s *SchemaDiff) MigrationSQL() []string {
+	d := s.dialect
+	name := d.GetName()
+	table := d.Quote(s.Table)
+	statements := make([]string, 0)
+
+	// drop extra indexes
+	for _, idx := range s.ExtraIndexes {
+		switch {
+		case name == "mysql":
+			statements = append(statements, fmt.Sprintf("DROP INDEX %s ON %s", d.Quote(idx.Name), table))
+		case name == "postgres" && idx.constraint:
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, d.Quote(idx.Name)))
+		case name == "sqlite3" && idx.constraint:
+			statements = append(statements, fmt.Sprintf("-- sqlite3 does not support dropping constraint index %s, rebuild the table instead", idx.Name))
+		default:
+			statements = append(statements, fmt.Sprintf("DROP INDEX %s", d.Quote(idx.Name)))
+		}
+	}
+
+	// create table or add columns
+	if s.MissingTable {
+		definitions := make([]string, 0, len(s.MissingColumns))
+		primaryKeyInColumnType := false
+		for _, col := range s.MissingColumns {
+			definitions = append(definitions, d.Quote(col.Name)+" "+col.definition)
+			if strings.Contains(strings.ToLower(col.definition), "primary key") {
+				primaryKeyInColumnType = true
+			}
+		}
+		if len(s.primaryKeys) > 0 && !primaryKeyInColumnType {
+			quoted := make([]string, 0, len(s.primaryKeys))
+			for _, pk := range s.primaryKeys {
+				quoted = append(quoted, d.Quote(pk))
+			}
+			definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoted, ",")))
+		}
+		statements = append(statements, fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(definitions, ",")))
+	} else {
+		for _, col := range s.MissingColumns {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, d.Quote(col.Name), col.definition))
+		}
+	}
+
+	// alter changed columns
+	for _, change := range s.ChangedColumns {
+		column := d.Quote(change.Name)
+		switch name {
+		case "mysql":
+			definition := _uniqueRegexp.ReplaceAllString(change.Model.definition, "")
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, column, definition))
+		case "postgres":
+			for _, property := range change.Changes {
+				switch property {
+				case "type":
+					typ := change.Model.rawType
+					for from, to := range map[string]string{"bigserial": "bigint", "smallserial": "smallint", "serial": "integer"} {
+						if strings.EqualFold(typ, from) {
+							typ = to
+						}
+					}
+					statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, column, typ))
+				case "nullable":
+					action := "SET NOT NULL"
+					if change.Model.Nullable {
+						action = "DROP NOT NULL"
+					}
+					statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s", table, column, action))
+				case "default":
+					action := "DROP DEFAULT"
+					if value, ok := change.Model.defaultTag(); ok {
+						action = "SET DEFAULT " + value
+					}
+					statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s", table, column, action))
+				}
+			}
+		case "sqlite3":
+			statements = append(statements, fmt.Sprintf("-- sqlite3 does not support altering column %s.%s (%s), rebuild the table instead",
+				s.Table, change.Name, strings.Join(change.Changes, ", ")))
+		default:
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, column, change.Model.rawType))
+		}
+	}
+
+	// create missing indexes
+	for _, idx := range s.MissingIndexes {
+		indexName, create := idx.Name, "CREATE INDEX"
+		if idx.Unique {
+			create = "CREATE UNIQUE INDEX"
+		}
+		if indexName == "" {
+			indexName = d.BuildKeyName("uix", s.Table, idx.Columns...)
+		}
+		quoted := make([]string, 0, len(idx.Columns))
+		for _, col := range idx.Columns {
+			quoted = append(quoted, d.Quote(col))
+		}
+		statements = append(statements, fmt.Sprintf("%s %s ON %s (%s)", create, d.Quote(indexName), table, strings.Join(quoted, ", ")))
+	}
+
+	// drop extra columns
+	for _, col := range s.ExtraColumns {
+		if !s.dropColumn {
+			statements = append(statements, fmt.Sprintf("-- sqlite3 (before 3.35.0) does not support dropping column %s.%s, rebuild the table instead", s.Table, col.Name))
+			continue
+		}
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, d.Quote(col.Name)))
+	}
+	return statements
+}
+
+// defaultTag returns the model column's raw default value in the column definition.
+func (c *ColumnSchema) defaultTag() (string, bool) {
+	idx := strings.Index(strings.ToUpper(c.definition), " DEFAULT ")
+	if idx == -1 {
+		return "", false
+	}
+	value := c.definition[idx+len(" DEFAULT "):]
+	if cmt := strings.Index(strings.ToUpper(value), " COMMENT "); cmt != -1 {
+		value = value[:cmt]
+	}
+	return strings.TrimSpace(value), true
+}
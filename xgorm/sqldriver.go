@@ -0,0 +1,155 @@
+package xgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+)
+
+// contextConn represents a driver.Conn which supports ExecContext and QueryContext, used in unpreparedStmt.
+type contextConn interface {
+	driver.ExecerContext
+	driver.QueryerContext
+}
+
+// unpreparedStmt is a driver.Stmt which is not prepared actually, and executes the statement by its connection directly.
+type unpreparedStmt struct {
+	conn  contextConn
+	query string
+}
+
+// Close implements driver.Stmt.
+func (u *unpreparedStmt) Close() error {
+	return nil
+}
+
+// NumInput implements driver.Stmt, -1 means the number of arguments is not checked.
+func (u *unpreparedStmt) NumInput() int {
+	return -1
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, all the values are passed to the connection as is.
+func (u *unpreparedStmt) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+// Exec implements driver.Stmt.
+func (u *unpreparedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return u.conn.ExecContext(context.Background(), u.query, namedValues(args))
+}
+
+// ExecContext implements driver.StmtExecContext.
+func (u *unpreparedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return u.conn.ExecContext(ctx, u.query, args)
+}
+
+// Query implements driver.Stmt.
+func (u *unpreparedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return u.conn.QueryContext(context.Background(), u.query, namedValues(args))
+}
+
+// QueryContext implements driver.StmtQueryContext.
+func (u *unpreparedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return u.conn.QueryContext(ctx, u.query, args)
+}
+
+// namedValues converts driver.Value-s to driver.NamedValue-s.
+func namedValues(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return out
+}
+
+// namedValueArgs converts driver.NamedValue-s to arguments of sql.DB, sql.Conn and sql.Tx.
+func namedValueArgs(args []driver.NamedValue) []interface{} {
+	out := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			out = append(out, sql.Named(arg.Name, arg.Value))
+		} else {
+			out = append(out, arg.Value)
+		}
+	}
+	return out
+}
+
+// bufferedRows is a driver.Rows which contains all rows in memory.
+type bufferedRows struct {
+	columns []string
+	values  [][]interface{}
+	index   int
+}
+
+// readBufferedRows reads all rows from sql.Rows into bufferedRows, and closes the sql.Rows.
+func readBufferedRows(rows *sql.Rows) (*bufferedRows, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &bufferedRows{columns: columns}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		result.values = append(result.values, values)
+	}
+	return result, rows.Err()
+}
+
+// Columns implements driver.Rows.
+func (b *bufferedRows) Columns() []string {
+	return b.columns
+}
+
+// Close implements driver.Rows.
+func (b *bufferedRows) Close() error {
+	return nil
+}
+
+// Next implements driver.Rows.
+func (b *bufferedRows) Next(dest []driver.Value) error {
+	if b.index >= len(b.values) {
+		return io.EOF
+	}
+	for i, value := range b.values[b.index] {
+		dest[i] = value
+	}
+	b.index++
+	return nil
+}
+
+// errorConnector is a driver.Connector which always fails to connect with given error, used in errorRow.
+type errorConnector struct {
+	err error
+}
+
+// Connect implements driver.Connector.
+func (e *errorConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, e.err
+}
+
+// Driver implements driver.Connector.
+func (e *errorConnector) Driver() driver.Driver {
+	return e
+}
+
+// Open implements driver.Driver.
+func (e *errorConnector) Open(string) (driver.Conn, error) {
+	return nil, e.err
+}
+
+// errorRow returns a sql.Row whose Scan method always returns given error, note that sql.Row can not be created with an error directly.
+func errorRow(err error) *sql.Row {
+	db := sql.OpenDB(&errorConnector{err: err})
+	defer db.Close()
+	return db.QueryRow("")
+}
@@ -0,0 +1,290 @@
+package xgorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"os"
+	"sync"
+)
+
+// errTestDBClosed is the error returned when using a closed TestDB.
+var errTestDBClosed = errors.New("xgorm: test db is closed")
+
+// testDBOptions represents some options for TestDB, set by TestDBOption.
+type testDBOptions struct {
+	models    []interface{}
+	logger    ILogger
+	deletedAt string
+	tempFile  bool
+}
+
+// TestDBOption represents an option for TestDB, created by WithTestDBXXX functions.
+type TestDBOption func(*testDBOptions)
+
+// WithTestDBModels returns a TestDBOption with models to be auto migrated when creating TestDB.
+func WithTestDBModels(models ...interface{}) TestDBOption {
+	return func(o *testDBOptions) {
+		o.models = append(o.models, models...)
+	}
+}
+
+// WithTestDBLogger returns a TestDBOption with logger, which enables gorm's log mode and set the logger, defaults to nil, means do not
+// log.
+func WithTestDBLogger(logger ILogger) TestDBOption {
+	return func(o *testDBOptions) {
+		o.logger = logger
+	}
+}
+
+// WithTestDBDeletedAt returns a TestDBOption with deletedAt timestamp used in HookDeletedAt, defaults to DefaultDeletedAtTimestamp, and
+// empty string means do not hook.
+func WithTestDBDeletedAt(deletedAtTimestamp string) TestDBOption {
+	return func(o *testDBOptions) {
+		o.deletedAt = deletedAtTimestamp
+	}
+}
+
+// WithTestDBTempFile returns a TestDBOption with tempFile switcher, which is only used in NewTestSQLite, defaults to false, means to use
+// an in-memory database, otherwise to use a temp file which is removed when closing.
+func WithTestDBTempFile(tempFile bool) TestDBOption {
+	return func(o *testDBOptions) {
+		o.tempFile = tempFile
+	}
+}
+
+// TestDB represents a gorm.DB for testing, all the statements of which are executed in a single transaction that is always rolled back
+// when closing. Note that Begin, Commit and Rollback on this gorm.DB are mapped to SAVEPOINT, RELEASE SAVEPOINT and ROLLBACK TO SAVEPOINT,
+// so the code paths using transaction can also be tested, but concurrent transactions are not isolated from each other.
+type TestDB struct {
+	*gorm.DB
+	state *testDBState
+	sqlDB *sql.DB
+	file  string
+}
+
+// NewTestDB creates a new TestDB with given dialect, dsn and TestDBOption-s. Here the dialect is also used as the driver name, and the
+// models will be auto migrated inside the transaction except mysql, because DDL statements cause implicit commit in mysql.
+// Example:
+// 	tdb, err := xgorm.NewTestDB("mysql", dsn, xgorm.WithTestDBModels(&User{}), xgorm.WithTestDBLogger(xgorm.NewLogrusLogger(l)))
+// 	if err != nil {
+// 		t.Fatal(err)
+// 	}
+// 	defer tdb.Close() // rollback
+// 	testSomething(t, tdb.DB)
+func NewTestDB(dialect, dsn string, options ...TestDBOption) (*TestDB, error) {
+	opt := &testDBOptions{deletedAt: DefaultDeletedAtTimestamp}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	sqlDB, err := sql.Open(dialect, dsn)
+	if err != nil {
+		return nil, err
+	}
+	tdb, err := newTestDB(dialect, sqlDB, opt)
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	return tdb, nil
+}
+
+// newTestDB creates a new TestDB with given dialect, underlying sql.DB and testDBOptions.
+func newTestDB(dialect string, sqlDB *sql.DB, opt *testDBOptions) (*TestDB, error) {
+	setup := func(db *gorm.DB) *gorm.DB {
+		if opt.logger != nil {
+			db.LogMode(true)
+			db.SetLogger(opt.logger)
+		}
+		if opt.deletedAt != "" {
+			HookDeletedAt(db, opt.deletedAt)
+		}
+		return db
+	}
+
+	migrated := false
+	if dialect == "mysql" && len(opt.models) > 0 {
+		db, err := gorm.Open(dialect, sqlDB) // do not close it, because it will close sqlDB
+		if err != nil {
+			return nil, err
+		}
+		if err = setup(db).AutoMigrate(opt.models...).Error; err != nil {
+			return nil, err
+		}
+		migrated = true
+	}
+
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	state := &testDBState{tx: tx}
+	db, err := gorm.Open(dialect, sql.OpenDB(&testDBConnector{state: state}))
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	setup(db)
+	if !migrated && len(opt.models) > 0 {
+		if err = db.AutoMigrate(opt.models...).Error; err != nil {
+			_ = db.Close()
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	return &TestDB{DB: db, state: state, sqlDB: sqlDB}, nil
+}
+
+// Close rolls back the transaction, closes the databases and removes the temp file if exists.
+func (t *TestDB) Close() error {
+	t.state.mu.Lock()
+	t.state.closed = true
+	err := t.state.tx.Rollback()
+	t.state.mu.Unlock()
+
+	if err2 := t.DB.Close(); err == nil {
+		err = err2
+	}
+	if err2 := t.sqlDB.Close(); err == nil {
+		err = err2
+	}
+	if t.file != "" {
+		if err2 := os.Remove(t.file); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// testDBState represents the shared state of all connections of a TestDB.
+type testDBState struct {
+	mu         sync.Mutex
+	tx         *sql.Tx
+	savepoints int
+	closed     bool
+}
+
+// testDBConnector is a driver.Connector used in TestDB, all the connections of which share the same transaction.
+type testDBConnector struct {
+	state *testDBState
+}
+
+var _ driver.Connector = &testDBConnector{}
+
+// Connect implements driver.Connector.
+func (t *testDBConnector) Connect(context.Context) (driver.Conn, error) {
+	return &testDBConn{state: t.state}, nil
+}
+
+// Driver implements driver.Connector.
+func (t *testDBConnector) Driver() driver.Driver {
+	return testDBDriver{}
+}
+
+// testDBDriver is a driver.Driver used in testDBConnector, which can not be opened by name.
+type testDBDriver struct{}
+
+// Open implements driver.Driver.
+func (testDBDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("xgorm: test db driver can not be opened by name")
+}
+
+// testDBConn is a driver.Conn used in TestDB, which executes statements in the shared transaction, and maps transactions to savepoints.
+type testDBConn struct {
+	state *testDBState
+}
+
+var (
+	_ driver.Conn           = &testDBConn{}
+	_ driver.ConnBeginTx    = &testDBConn{}
+	_ driver.ExecerContext  = &testDBConn{}
+	_ driver.QueryerContext = &testDBConn{}
+)
+
+// Prepare implements driver.Conn.
+func (t *testDBConn) Prepare(query string) (driver.Stmt, error) {
+	return &unpreparedStmt{conn: t, query: query}, nil
+}
+
+// PrepareContext implements driver.ConnPrepareContext.
+func (t *testDBConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return &unpreparedStmt{conn: t, query: query}, nil
+}
+
+// Close implements driver.Conn, the shared transaction will not be closed.
+func (t *testDBConn) Close() error {
+	return nil
+}
+
+// Begin implements driver.Conn.
+func (t *testDBConn) Begin() (driver.Tx, error) {
+	return t.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx, which creates a savepoint in the shared transaction.
+func (t *testDBConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if t.state.closed {
+		return nil, errTestDBClosed
+	}
+	t.state.savepoints++
+	name := fmt.Sprintf("xgorm_savepoint_%d", t.state.savepoints)
+	if _, err := t.state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &testDBTx{conn: t, name: name}, nil
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, all the values are passed to the underlying driver as is.
+func (t *testDBConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+// ExecContext implements driver.ExecerContext.
+func (t *testDBConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if t.state.closed {
+		return nil, errTestDBClosed
+	}
+	return t.state.tx.ExecContext(ctx, query, namedValueArgs(args)...)
+}
+
+// QueryContext implements driver.QueryerContext, all the rows will be read into memory, so that the shared transaction can be used by
+// other statements.
+func (t *testDBConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if t.state.closed {
+		return nil, errTestDBClosed
+	}
+	rows, err := t.state.tx.QueryContext(ctx, query, namedValueArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return readBufferedRows(rows)
+}
+
+// testDBTx is a driver.Tx used in TestDB, which represents a savepoint.
+type testDBTx struct {
+	conn *testDBConn
+	name string
+}
+
+// Commit implements driver.Tx, which releases the savepoint.
+func (t *testDBTx) Commit() error {
+	_, err := t.conn.ExecContext(context.Background(), "RELEASE SAVEPOINT "+t.name, nil)
+	return err
+}
+
+// Rollback implements driver.Tx, which rolls back to the savepoint.
+func (t *testDBTx) Rollback() error {
+	_, err := t.conn.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+t.name, nil)
+	return err
+}
@@ -0,0 +1,53 @@
+// +build cgo
+
+package xgorm
+
+import (
+	"database/sql"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"io/ioutil"
+	"os"
+)
+
+// NewTestSQLite creates a new TestDB using a throwaway sqlite3 database with given TestDBOption-s, the database is in memory by default,
+// and can be a temp file by WithTestDBTempFile, which will be removed when closing.
+// Example:
+// 	tdb, err := xgorm.NewTestSQLite(xgorm.WithTestDBModels(&User{}, &Order{}))
+// 	if err != nil {
+// 		t.Fatal(err)
+// 	}
+// 	defer tdb.Close()
+func NewTestSQLite(options ...TestDBOption) (*TestDB, error) {
+	opt := &testDBOptions{deletedAt: DefaultDeletedAtTimestamp}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+
+	dsn, file := ":memory:", ""
+	if opt.tempFile {
+		f, err := ioutil.TempFile("", "xgorm_test_*.db")
+		if err != nil {
+			return nil, err
+		}
+		_ = f.Close()
+		dsn, file = f.Name(), f.Name()
+	}
+	sqlDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1) // in-memory database is per connection
+
+	tdb, err := newTestDB("sqlite3", sqlDB, opt)
+	if err != nil {
+		_ = sqlDB.Close()
+		if file != "" {
+			_ = os.Remove(file)
+		}
+		return nil, err
+	}
+	tdb.file = file
+	return tdb, nil
+}
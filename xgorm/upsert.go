@@ -0,0 +1,209 @@
+package xgorm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Aoi-hosizora/ahlib/xstatus"
+	"github.com/jinzhu/gorm"
+	"regexp"
+	"strings"
+)
+
+const (
+	// UpsertInserted is the status returned by Upsert when the row is inserted.
+	UpsertInserted = xstatus.DbSuccess
+
+	// UpsertUpdated is the status returned by Upsert when the row conflicts with an existing row, and the existing row is updated.
+	UpsertUpdated = xstatus.DbTagB
+
+	// UpsertUnchanged is the status returned by Upsert when the row conflicts with an existing row, and nothing is changed, that is
+	// "do nothing" in all dialects, or updating to the same values in MySQL.
+	UpsertUnchanged = xstatus.DbTagC
+)
+
+// _excludedColumnRegexp is the regexp of "EXCLUDED.column" reference, which is translated to "VALUES(column)" in MySQL.
+var _excludedColumnRegexp = regexp.MustCompile("(?i)\\bEXCLUDED\\.(`[^`]+`|\"[^\"]+\"|\\w+)")
+
+// upsertUpdates builds the update assignments of Upsert, the column without "=" is assigned by the inserting value, and the expression
+// with "=" is used as is, in which "EXCLUDED.column" will be translated to "VALUES(column)" in MySQL.
+func upsertUpdates(scope *gorm.Scope, updateColumns []string, mysql bool) []string {
+	updates := make([]string, 0, len(updateColumns)+1)
+	updatedAt := true
+	for _, column := range updateColumns {
+		if strings.Contains(column, "=") {
+			if mysql {
+				column = _excludedColumnRegexp.ReplaceAllString(column, "VALUES($1)")
+			}
+			updates = append(updates, column)
+			column = strings.TrimSpace(column[:strings.Index(column, "=")])
+		} else if mysql {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", scope.Quote(column), scope.Quote(column)))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", scope.Quote(column), scope.Quote(column)))
+		}
+		if unquoteColumn(column) == "updated_at" {
+			updatedAt = false
+		}
+	}
+
+	// also update UpdatedAt if not specified
+	if field, ok := scope.FieldByName("UpdatedAt"); ok && updatedAt && len(updates) > 0 {
+		if mysql {
+			updates = append(updates, fmt.Sprintf("%s = VALUES(%s)", scope.Quote(field.DBName), scope.Quote(field.DBName)))
+		} else {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", scope.Quote(field.DBName), scope.Quote(field.DBName)))
+		}
+	}
+	return updates
+}
+
+// Upsert inserts given model, or updates the existing row when the row conflicts on conflictColumns, and returns UpsertInserted,
+// UpsertUpdated, UpsertUnchanged, or xstatus.DbFailed with error.
+//
+// The statement is "INSERT ... ON DUPLICATE KEY UPDATE" in MySQL (conflictColumns is ignored, and any unique key may conflict), and
+// "INSERT ... ON CONFLICT (conflictColumns) DO UPDATE SET" in PostgreSQL and SQLite (3.24+). The updateColumns can be column names,
+// which are updated to the inserting values, or expressions such as "count = count + EXCLUDED.count", and empty updateColumns means
+// "do nothing". The UpdatedAt field will also be updated if it exists and is not specified in updateColumns.
+//
+// Just like gorm's Create, the CreatedAt and UpdatedAt fields will be set if blank, BeforeSave and BeforeCreate methods will be invoked,
+// and the blank auto-increment primary key will be back-filled when inserted. Note that for MySQL, updating to the same values returns
+// UpsertUnchanged only if clientFoundRows is not set in dsn.
+// Example:
+// 	status, err := xgorm.Upsert(db, &Counter{Name: "visit", Count: 1}, []string{"name"}, []string{"count = count + EXCLUDED.count"})
+// 	status, err := xgorm.Upsert(db, &User{Name: "user1"}, []string{"name"}, nil) // do nothing
+func Upsert(db *gorm.DB, model interface{}, conflictColumns []string, updateColumns []string) (xstatus.DbStatus, error) {
+	row := newBatchRow(db, model)
+	scope := row.scope
+	if scope.HasError() {
+		return xstatus.DbFailed, scope.DB().Error
+	}
+	if len(row.columns) == 0 {
+		return xstatus.DbFailed, errors.New("xgorm: Upsert with no column")
+	}
+
+	columns := make([]string, 0, len(row.columns))
+	for _, column := range row.columns {
+		columns = append(columns, scope.Quote(column))
+	}
+	targets := make([]string, 0, len(conflictColumns))
+	for _, column := range conflictColumns {
+		targets = append(targets, scope.Quote(column))
+	}
+	updates := upsertUpdates(scope, updateColumns, IsMySQL(db))
+
+	var clause string
+	switch {
+	case IsMySQL(db):
+		if len(updates) == 0 {
+			updates = []string{fmt.Sprintf("%s = %s", columns[0], columns[0])} // do nothing, and affects 0 row
+		}
+		clause = "ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	case len(updates) == 0:
+		clause = "ON CONFLICT DO NOTHING"
+		if len(targets) > 0 {
+			clause = fmt.Sprintf("ON CONFLICT (%s) DO NOTHING", strings.Join(targets, ","))
+		}
+	case len(targets) == 0:
+		return xstatus.DbFailed, errors.New("xgorm: Upsert with update columns but without conflict columns")
+	default:
+		clause = fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(targets, ","), strings.Join(updates, ", "))
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES ? %s", scope.QuotedTableName(), strings.Join(columns, ","), clause)
+
+	status := xstatus.DbUnknown
+	err := transaction(db, func(tx *gorm.DB) (err error) {
+		status, err = upsert(tx, row, conflictColumns, query)
+		return err
+	})
+	if err != nil {
+		return xstatus.DbFailed, err
+	}
+	return status, nil
+}
+
+// upsert executes the upsert statement, checks the result status, and back-fills the primary key if inserted.
+func upsert(tx *gorm.DB, row *batchRow, conflictColumns []string, query string) (xstatus.DbStatus, error) {
+	scope := row.scope
+	primaryField := scope.PrimaryField()
+	backfill := primaryField != nil && primaryField.IsBlank
+
+	// postgres: use xmax to check if the row is inserted
+	if IsPostgreSQL(tx) {
+		returning := " RETURNING (xmax = 0)"
+		if backfill {
+			returning += ", " + scope.Quote(primaryField.DBName)
+		}
+		rows, err := tx.Raw(query+returning, [][]interface{}{row.values}).Rows()
+		if err != nil {
+			return xstatus.DbFailed, err
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			return UpsertUnchanged, rows.Err() // do nothing
+		}
+		inserted := false
+		dest := []interface{}{&inserted}
+		if backfill {
+			dest = append(dest, primaryField.Field.Addr().Interface())
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return xstatus.DbFailed, err
+		}
+		if !inserted {
+			return UpsertUpdated, nil
+		}
+		return UpsertInserted, nil
+	}
+
+	// sqlite: check if the row exists before upsert, in the same transaction
+	existed := false
+	if IsSQLite(tx) && len(conflictColumns) > 0 {
+		values := make(map[string]interface{}, len(row.columns))
+		for i, column := range row.columns {
+			values[column] = row.values[i]
+		}
+		where := make([]string, 0, len(conflictColumns))
+		args := make([]interface{}, 0, len(conflictColumns))
+		for _, column := range conflictColumns {
+			value, ok := values[column]
+			if !ok {
+				where = nil
+				break // blank primary key, never conflicts
+			}
+			where = append(where, scope.Quote(column)+" = ?")
+			args = append(args, value)
+		}
+		if len(where) > 0 {
+			cnt := 0
+			err := tx.Table(scope.TableName()).Unscoped().Where(strings.Join(where, " AND "), args...).Limit(1).Count(&cnt).Error
+			if err != nil {
+				return xstatus.DbFailed, err
+			}
+			existed = cnt > 0
+		}
+	}
+
+	rdb := tx.Exec(query, [][]interface{}{row.values})
+	if rdb.Error != nil {
+		return xstatus.DbFailed, rdb.Error
+	}
+	var status xstatus.DbStatus
+	switch {
+	case rdb.RowsAffected == 0:
+		status = UpsertUnchanged
+	case IsMySQL(tx) && rdb.RowsAffected == 2, existed:
+		status = UpsertUpdated
+	default:
+		status = UpsertInserted
+	}
+	if status == UpsertInserted && backfill {
+		id, err := lastInsertId(tx)
+		if err != nil {
+			return xstatus.DbFailed, err
+		}
+		if err = primaryField.Set(id); err != nil {
+			return xstatus.DbFailed, err
+		}
+	}
+	return status, nil
+}
@@ -3,8 +3,11 @@
 package xgorm
 
 import (
+	"github.com/Aoi-hosizora/ahlib/xtesting"
+	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"os"
 	"testing"
 )
 
@@ -49,3 +52,302 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestTenant(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testTenant(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestResolver(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect      string
+		givePrimaryParam string
+		giveReplicaParam string
+	}{
+		{"sqlite3", sqliteFile, sqliteReplicaFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testResolver(t, tc.giveDialect, tc.givePrimaryParam, tc.giveReplicaParam)
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testExplain(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestNPlusOne(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testNPlusOne(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testQueryStats(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testMigrator(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestSchemaDiff(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testSchemaDiff(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestFixture(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testFixture(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestTestDB(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testTestDB(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestTestSQLite(t *testing.T) {
+	for _, tempFile := range []bool{false, true} {
+		tdb, err := NewTestSQLite(WithTestDBModels(&User{}, &Order{}), WithTestDBTempFile(tempFile))
+		xtesting.Nil(t, err)
+		xtesting.Nil(t, tdb.Create(&User{Name: "user1"}).Error)
+		xtesting.Nil(t, tdb.Transaction(func(tx *gorm.DB) error {
+			return tx.Create(&Order{TenantId: 1, Name: "order1"}).Error
+		}))
+		cnt := 0
+		xtesting.Nil(t, tdb.Model(&Order{}).Count(&cnt).Error)
+		xtesting.Equal(t, cnt, 1)
+		xtesting.Nil(t, tdb.Close())
+		if tempFile {
+			_, err = os.Stat(tdb.file)
+			xtesting.True(t, os.IsNotExist(err))
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testRecorder(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestBatchCreate(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testBatchCreate(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testUpsert(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testBulkUpdate(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestIterate(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testIterate(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestRepository(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testRepository(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testLock(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testAdvisoryLock(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testOutbox(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testJobQueue(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+		{"sqlite3", sqliteFile},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testQueryCache(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
@@ -45,3 +45,250 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestTenant(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testTenant(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testExplain(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestNPlusOne(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testNPlusOne(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testQueryStats(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testMigrator(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestSchemaDiff(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testSchemaDiff(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestFixture(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testFixture(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestTestDB(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testTestDB(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testRecorder(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestBatchCreate(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testBatchCreate(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testUpsert(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestBulkUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testBulkUpdate(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestIterate(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testIterate(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestRepository(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testRepository(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestLock(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testLock(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testAdvisoryLock(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testOutbox(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testJobQueue(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect string
+		giveParam   string
+	}{
+		{"mysql", mysqlDsl},
+	} {
+		t.Run(tc.giveDialect, func(t *testing.T) {
+			testQueryCache(t, tc.giveDialect, tc.giveParam)
+		})
+	}
+}
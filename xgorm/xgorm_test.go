@@ -1,13 +1,29 @@
 package xgorm
 
 import (
+	"container/list"
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/Aoi-hosizora/ahlib/xstatus"
 	"github.com/Aoi-hosizora/ahlib/xtesting"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -15,6 +31,11 @@ import (
 const (
 	mysqlDsl   = "root:123@tcp(localhost:3306)/db_test?charset=utf8&parseTime=True&loc=Local"
 	sqliteFile = "test.sql"
+
+	sqliteReplicaFile = "test_replica.sql"
+
+	redisAddr   = "localhost:6379"
+	redisPasswd = "123"
 )
 
 type User struct {
@@ -186,6 +207,10 @@ func testLogger(t *testing.T, giveDialect, giveParam string) {
 		{"logrus", true, NewLogrusLogger(l1)},
 		{"logrus_no_info", true, NewLogrusLogger(l1, WithLogInfo(false))},
 		{"logrus_no_other", true, NewLogrusLogger(l1, WithLogOther(false))},
+		{"logrus_sql_level", true, NewLogrusLogger(l1, WithSqlLevel(logrus.WarnLevel))},
+		{"logrus_slow", true, NewLogrusLogger(l1, WithSlowThreshold(time.Nanosecond))},
+		{"logrus_only_errors", true, NewLogrusLogger(l1, WithOnlyErrors(true))},
+		{"logrus_dialect", true, NewLogrusLogger(l1, WithDialect(giveDialect))},
 		{"logger", true, NewLoggerLogger(l2)},
 		{"logger_no_info_other", true, NewLoggerLogger(l2, WithLogInfo(false), WithLogOther(false))},
 		{"disable", true, NewLogrusLogger(l1)},
@@ -221,4 +246,2117 @@ func testLogger(t *testing.T, giveDialect, giveParam string) {
 			db.Model(&User{}).Where("deleted_at = $1 OR deleted_at = $2", time.Time{}, nil).First(&User{})      // $
 		})
 	}
+
+	t.Run("options", func(t *testing.T) {
+		EnableLogger()
+		l, hook := logrustest.NewNullLogger()
+		l.SetLevel(logrus.TraceLevel)
+		for _, tc := range []struct {
+			name        string
+			giveOptions []LoggerOption
+			wantLevels  []logrus.Level // levels of [log, sql (insert), sql (select default value)]
+			wantSlow    bool
+		}{
+			{"default", nil, []logrus.Level{logrus.ErrorLevel, logrus.InfoLevel, logrus.InfoLevel}, false},
+			{"sql_level", []LoggerOption{WithSqlLevel(logrus.DebugLevel)}, []logrus.Level{logrus.ErrorLevel, logrus.DebugLevel, logrus.DebugLevel}, false},
+			{"not_slow", []LoggerOption{WithSlowThreshold(time.Hour)}, []logrus.Level{logrus.ErrorLevel, logrus.InfoLevel, logrus.InfoLevel}, false},
+			{"slow", []LoggerOption{WithSlowThreshold(time.Nanosecond)}, []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel, logrus.WarnLevel}, true},
+			{"only_errors", []LoggerOption{WithOnlyErrors(true)}, []logrus.Level{logrus.ErrorLevel}, false},
+			{"only_errors_slow", []LoggerOption{WithOnlyErrors(true), WithSlowThreshold(time.Nanosecond)}, []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel, logrus.WarnLevel}, true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				db, err := gorm.Open(giveDialect, giveParam)
+				if err != nil {
+					log.Println(err)
+					t.FailNow()
+				}
+				db.LogMode(true)
+				db.SetLogger(NewLogrusLogger(l, tc.giveOptions...))
+				db.DropTableIfExists(&User{})
+				if db.AutoMigrate(&User{}).Error != nil {
+					log.Println(err)
+					t.FailNow()
+				}
+				xtesting.Nil(t, db.Create(&User{Uid: 1, Name: "user1"}).Error)
+
+				hook.Reset()
+				xtesting.NotNil(t, db.Create(&User{Uid: 1, Name: "user1"}).Error)
+				entries := hook.AllEntries()
+				xtesting.Equal(t, len(entries), len(tc.wantLevels))
+				for i, entry := range entries {
+					xtesting.Equal(t, entry.Level, tc.wantLevels[i])
+					if entry.Data["type"] == "sql" {
+						_, slow := entry.Data["slow"]
+						xtesting.Equal(t, slow, tc.wantSlow)
+						xtesting.Nil(t, entry.Data["error"])
+					} else {
+						xtesting.Equal(t, entry.Data["type"], "log")
+						xtesting.NotNil(t, entry.Data["error"])
+						xtesting.True(t, strings.HasPrefix(entry.Message, "[Gorm] [log] "))
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("dialect", func(t *testing.T) {
+		l, hook := logrustest.NewNullLogger()
+		db, err := gorm.Open(giveDialect, giveParam)
+		if err != nil {
+			log.Println(err)
+			t.FailNow()
+		}
+		db.LogMode(true)
+		db.SetLogger(NewLogrusLogger(l)) // no WithDialect
+		db.DropTableIfExists(&User{})
+		if db.AutoMigrate(&User{}).Error != nil {
+			log.Println(err)
+			t.FailNow()
+		}
+
+		want := map[string]string{"mysql": `'a\'b\\c'`, "sqlite3": `'a''b\c'`}[giveDialect]
+		hook.Reset()
+		xtesting.Nil(t, db.Create(&User{Uid: 1, Name: "a'b\\c"}).Error)
+		xtesting.True(t, strings.Contains(hook.AllEntries()[0].Data["sql"].(string), want))
+		hook.Reset()
+		xtesting.Nil(t, db.Exec("UPDATE users SET name = ? WHERE uid = 1", "a'b\\c").Error) // raw sql, use the remembered dialect
+		xtesting.True(t, strings.Contains(hook.LastEntry().Data["sql"].(string), want))
+	})
+}
+
+type Order struct {
+	Oid      int `gorm:"primary_key; auto_increment"`
+	TenantId int `gorm:"not null"`
+	Name     string
+	GormTime
+}
+
+func testTenant(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	HookTenant(db)
+	db.DropTableIfExists(&Order{})
+	if db.AutoMigrate(&Order{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	t1, t2 := WithTenant(db, 1), WithTenant(db, 2)
+
+	// create
+	order := &Order{Oid: 1, Name: "order1"}
+	xtesting.Nil(t, t1.Create(order).Error)
+	xtesting.Equal(t, order.TenantId, 1)
+	xtesting.Nil(t, t2.Create(&Order{Oid: 2, Name: "order2"}).Error)
+	xtesting.Equal(t, db.Create(&Order{Oid: 3, Name: "order3"}).Error, ErrMissingTenant)
+	xtesting.Nil(t, db.Create(&Order{Oid: 3, TenantId: 2, Name: "order3"}).Error)
+
+	// query
+	orders := make([]*Order, 0)
+	xtesting.Nil(t, t1.Model(&Order{}).Find(&orders).Error)
+	xtesting.Equal(t, len(orders), 1)
+	xtesting.Nil(t, t2.Model(&Order{}).Find(&orders).Error)
+	xtesting.Equal(t, len(orders), 2)
+	xtesting.Equal(t, db.Model(&Order{}).Find(&orders).Error, ErrMissingTenant)
+	xtesting.Nil(t, UnscopedTenant(db).Model(&Order{}).Find(&orders).Error)
+	xtesting.Equal(t, len(orders), 3)
+	cnt := 0
+	xtesting.Nil(t, t2.Model(&Order{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 2)
+
+	// row query without tenant
+	rows, err := db.Model(&Order{}).Rows()
+	xtesting.Equal(t, err, ErrMissingTenant)
+	xtesting.Nil(t, rows)
+	name := ""
+	xtesting.Equal(t, db.Model(&Order{}).Select("name").Row().Scan(&name), ErrMissingTenant)
+	xtesting.Equal(t, name, "")
+	names := make([]string, 0)
+	xtesting.Equal(t, db.Model(&Order{}).Pluck("name", &names).Error, ErrMissingTenant)
+	xtesting.Equal(t, len(names), 0)
+	cnt = 0
+	xtesting.Equal(t, db.Model(&Order{}).Count(&cnt).Error, ErrMissingTenant)
+	xtesting.Equal(t, cnt, 0)
+	xtesting.Nil(t, t1.Model(&Order{}).Pluck("name", &names).Error)
+	xtesting.Equal(t, names, []string{"order1"})
+	rows, err = t2.Model(&Order{}).Rows()
+	xtesting.Nil(t, err)
+	cnt = 0
+	for rows.Next() {
+		cnt++
+	}
+	xtesting.Nil(t, rows.Close())
+	xtesting.Equal(t, cnt, 2)
+
+	// update
+	rdb := t1.Model(&Order{}).Where("oid = ?", 2).Update("name", "order2_new")
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(0))
+	rdb = t2.Model(&Order{}).Where("oid = ?", 2).Update("name", "order2_new")
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(1))
+
+	// delete
+	rdb = t1.Model(&Order{}).Delete(&Order{Oid: 3})
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(0))
+	rdb = t2.Model(&Order{}).Delete(&Order{Oid: 3})
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(1))
+	xtesting.Equal(t, db.Model(&Order{}).Delete(&Order{Oid: 1}).Error, ErrMissingTenant)
+}
+
+func testResolver(t *testing.T, giveDialect, givePrimaryParam, giveReplicaParam string) {
+	primary, err := sql.Open(giveDialect, givePrimaryParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	replica, err := sql.Open(giveDialect, giveReplicaParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	resolver := NewResolver(primary, RoundRobinPolicy(), replica)
+	defer resolver.Close()
+
+	db, err := gorm.Open(giveDialect, resolver)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	HookResolver(db)
+
+	// prepare tables in both primary and replica
+	rdb, err := gorm.Open(giveDialect, replica)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	for _, d := range []*gorm.DB{db, rdb} {
+		d.DropTableIfExists(&User{})
+		if d.AutoMigrate(&User{}).Error != nil {
+			log.Println(err)
+			t.FailNow()
+		}
+	}
+
+	// write to primary
+	xtesting.Nil(t, db.Create(&User{Uid: 1, Name: "user1"}).Error)
+	xtesting.Nil(t, rdb.Create(&User{Uid: 2, Name: "user2"}).Error)
+
+	// read from replica
+	users := make([]*User, 0)
+	xtesting.Nil(t, db.Model(&User{}).Find(&users).Error)
+	xtesting.Equal(t, len(users), 1)
+	xtesting.Equal(t, users[0].Uid, 2)
+	cnt := 0
+	xtesting.Nil(t, db.Model(&User{}).Where("uid = ?", 2).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 1)
+	xtesting.True(t, resolver.Replicas()[0].Latency() > 0)
+
+	// read from primary
+	xtesting.Nil(t, ForcePrimary(db).Model(&User{}).Find(&users).Error)
+	xtesting.Equal(t, len(users), 1)
+	xtesting.Equal(t, users[0].Uid, 1)
+	tx := db.Begin()
+	xtesting.Nil(t, tx.Model(&User{}).Find(&users).Error)
+	xtesting.Equal(t, len(users), 1)
+	xtesting.Equal(t, users[0].Uid, 1)
+	xtesting.Nil(t, tx.Commit().Error)
+
+	// policy
+	r1, r2 := &Replica{}, &Replica{}
+	r1.observe(2 * time.Millisecond)
+	r2.observe(time.Millisecond)
+	xtesting.Equal(t, LeastLatencyPolicy().Choose([]*Replica{r1, r2}), r2)
+	rr := RoundRobinPolicy()
+	xtesting.Equal(t, rr.Choose([]*Replica{r1, r2}), r1)
+	xtesting.Equal(t, rr.Choose([]*Replica{r1, r2}), r2)
+	xtesting.Equal(t, rr.Choose([]*Replica{r1, r2}), r1)
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	for _, tc := range []struct {
+		give string
+		want string
+	}{
+		{"SELECT * FROM `tbl`  WHERE id IN (1, 2, 3) AND name = 'a''b\\'c'", "select * from `tbl` where id in (?+) and name = ?"},
+		{"SELECT * FROM `tbl` WHERE id IN (?,?) AND name = ?", "select * from `tbl` where id in (?+) and name = ?"},
+		{`SELECT * FROM "tbl" WHERE id = $1 AND age > $12 /* comment */ -- comment` + "\n" + `LIMIT 10`, `select * from "tbl" where id = ? and age > ? limit ?`},
+		{`/* xgorm:replica */ SELECT count(*) FROM "users"  WHERE (uid = ?) AND ("users"."deleted_at" = '1970-01-01 00:00:01')`, `select count(*) from "users" where (uid = ?) and ("users"."deleted_at" = ?)`},
+		{"INSERT INTO t (a,b) VALUES (?,?),(?,?),(?, ?)", "insert into t (a, b) values (?, ?), ..."},
+		{"select x'0aff', 0x1F, 1.5e-3, t1.c2 from t1 where a in(?,?) and b IN ( $1 )", "select ?, ?, ?, t1.c2 from t1 where a in (?+) and b in (?+)"},
+	} {
+		xtesting.Equal(t, NormalizeSQL(tc.give), tc.want)
+	}
+
+	xtesting.Equal(t, FingerprintSQL("SELECT * FROM t WHERE id IN (1, 2)"), FingerprintSQL("select * from t where id in ($1, $2, $3)"))
+	xtesting.NotEqual(t, FingerprintSQL("SELECT * FROM t WHERE id = 1"), FingerprintSQL("SELECT * FROM t WHERE uid = 1"))
+
+	// backslash in string literal
+	xtesting.Equal(t, NormalizeSQL(`SELECT * FROM "t" WHERE path = 'C:\' AND id = $1`), `select * from "t" where path = ? and id = ?`)
+	xtesting.Equal(t, NormalizeSQL(`SELECT * FROM "t" WHERE path = 'C:\' AND id = ?`), `select * from "t" where path = ? and id = ?`)
+	xtesting.Equal(t, NormalizeSQL("SELECT * FROM `t` WHERE path = 'C:\\\\' AND id = ?"), "select * from `t` where path = ? and id = ?")
+	xtesting.NotEqual(t, FingerprintSQL(`SELECT * FROM "t" WHERE path = 'C:\' AND id = $1`), FingerprintSQL(`SELECT * FROM "t" WHERE path = 'C:\' AND uid = $1`))
+	xtesting.Equal(t, NormalizeDialectSQL(`SELECT 'a\'b', 1`, "mysql"), "select ?, ?")
+	xtesting.Equal(t, NormalizeDialectSQL(`SELECT 'a\', 1`, "postgres"), "select ?, ?")
+	xtesting.Equal(t, FingerprintDialectSQL(`SELECT 'a\', 1`, "sqlite3"), FingerprintSQL(`SELECT 'b', 2`))
+}
+
+type secret string
+
+func (secret) Redacted() {}
+
+func TestRedact(t *testing.T) {
+	opt := &loggerOptions{}
+	WithRedactColumns("password", "*_token")(opt)
+	WithRedactValues(regexp.MustCompile(`^\d{4}-\d{4}$`))(opt)
+
+	for _, tc := range []struct {
+		giveSql    string
+		giveParams []interface{}
+		want       string
+	}{
+		{"SELECT * FROM `user` WHERE `user`.`password` = ? AND name = ?", []interface{}{"pwd", "user1"}, "SELECT * FROM `user` WHERE `user`.`password` = '***' AND name = 'user1'"},
+		{`SELECT * FROM "user" WHERE access_token IN ($1, $2) AND name LIKE $3`, []interface{}{"a", "b", "c"}, `SELECT * FROM "user" WHERE access_token IN ('***', '***') AND name LIKE 'c'`},
+		{"INSERT INTO `user` (`name`,`password`) VALUES (?,?),(?,?)", []interface{}{"u1", "p1", "u2", "p2"}, "INSERT INTO `user` (`name`,`password`) VALUES ('u1','***'),('u2','***')"},
+		{"UPDATE `user` SET `password` = ?, `phone` = ? WHERE uid = ?", []interface{}{"p", "1234-5678", 1}, "UPDATE `user` SET `password` = '***', `phone` = '***' WHERE uid = 1"},
+		{"SELECT * FROM `user` WHERE name = ? OR name = ?", []interface{}{secret("s"), nil}, "SELECT * FROM `user` WHERE name = '***' OR name = NULL"},
+	} {
+		xtesting.Equal(t, render(tc.giveSql, redactParams(tc.giveSql, tc.giveParams, opt.redact), "mysql"), tc.want)
+	}
+	xtesting.Equal(t, render("SELECT ? AS password", redactParams("SELECT ? AS password", []interface{}{secret("s")}, nil), ""), "SELECT '***' AS password")
+}
+
+func TestRender(t *testing.T) {
+	tm := time.Date(2021, 1, 2, 11, 4, 5, 6000, time.FixedZone("", 8*60*60))
+	str := "a'b\\c"
+	for _, tc := range []struct {
+		giveSql     string
+		giveParams  []interface{}
+		giveDialect string
+		want        string
+	}{
+		{"SELECT ?, ?, ?, ?", []interface{}{str, &str, nil, (*string)(nil)}, "mysql", `SELECT 'a\'b\\c', 'a\'b\\c', NULL, NULL`},
+		{"SELECT ?, ?, ?, ?", []interface{}{str, true, 1.5, []byte{0x00, 0xff}}, "sqlite3", `SELECT 'a''b\c', 1, 1.5, X'00ff'`},
+		{"SELECT $1, $2, $3, $4", []interface{}{str, false, []byte{0x00, 0xff}, "$2a$1"}, "postgres", `SELECT 'a''b\c', FALSE, '\x00ff', '$2a$1'`},
+		{"SELECT ?, ?, ?", []interface{}{tm, time.Time{}, sql.NullInt64{Int64: 3, Valid: true}}, "mysql", "SELECT '2021-01-02 03:04:05.000006', '0000-00-00 00:00:00', 3"},
+		{"SELECT $1, $2", []interface{}{tm, sql.NullString{}}, "postgres", "SELECT '2021-01-02 03:04:05.000006Z', NULL"},
+		{"SELECT ?", []interface{}{tm}, "sqlite3", "SELECT '2021-01-02 03:04:05.000006+00:00'"},
+		{"SELECT ?, ?", []interface{}{tm, true}, "", "SELECT '2021-01-02 03:04:05', TRUE"},
+	} {
+		xtesting.Equal(t, render(tc.giveSql, tc.giveParams, tc.giveDialect), tc.want)
+	}
+
+	opt := &loggerOptions{}
+	for _, tc := range []struct {
+		giveSql string
+		want    string
+	}{
+		{"SELECT 1", ""},
+		{"SELECT * FROM `users` WHERE name = ?", "mysql"},
+		{"SELECT ?", "mysql"}, // remembered
+		{`SELECT * FROM "users" WHERE name = $1`, "postgres"},
+		{`SELECT * FROM "users" WHERE name = ?`, "sqlite3"},
+		{"SELECT ?", "sqlite3"},
+	} {
+		xtesting.Equal(t, opt.dialectOf(tc.giveSql), tc.want)
+	}
+	opt = &loggerOptions{dialect: "postgres"}
+	xtesting.Equal(t, opt.dialectOf("SELECT * FROM `users` WHERE name = ?"), "postgres")
+}
+
+func testExplain(t *testing.T, giveDialect, giveParam string) {
+	l, hook := logrustest.NewNullLogger()
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	logger := NewLogrusLogger(l, WithExplain(db, time.Nanosecond, time.Hour))
+	db.SetLogger(logger)
+	EnableLogger()
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&User{})
+	if db.AutoMigrate(&User{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	explained := func(wait time.Duration) *logrus.Entry {
+		for deadline := time.Now().Add(wait); ; time.Sleep(10 * time.Millisecond) {
+			for _, entry := range hook.AllEntries() {
+				if entry.Data["type"] == "explain" {
+					return entry
+				}
+			}
+			if time.Now().After(deadline) {
+				return nil
+			}
+		}
+	}
+	waitWorkers := func() {
+		for len(logger.options.explain.workers) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// explain asynchronously
+	waitWorkers() // statements of AutoMigrate
+	hook.Reset()
+	xtesting.Nil(t, db.Model(&User{}).Where("name = ?", "user1").Find(&[]*User{}).Error)
+	entry := explained(3 * time.Second)
+	xtesting.NotNil(t, entry)
+	plan := entry.Data["explain"].(*ExplainPlan)
+	xtesting.Equal(t, plan.Dialect, giveDialect)
+	xtesting.True(t, len(plan.Rows) > 0)
+	xtesting.Equal(t, plan.Rows[0].Key, "uk_name")
+	xtesting.Equal(t, entry.Data["fingerprint"], FingerprintSQL(hook.AllEntries()[0].Data["sql"].(string)))
+	xtesting.True(t, strings.HasPrefix(entry.Message, "[Gorm] [explain] "))
+	log.Println(plan)
+
+	// rate limited
+	waitWorkers()
+	hook.Reset()
+	xtesting.Nil(t, db.Model(&User{}).Where("name = ?", "user2").Find(&[]*User{}).Error)
+	xtesting.Nil(t, explained(100*time.Millisecond))
+
+	// resolver is not supported
+	resolverDB, err := gorm.Open(giveDialect, NewResolver(db.DB(), RandomPolicy()))
+	xtesting.Nil(t, err)
+	xtesting.Nil(t, NewLogrusLogger(l, WithExplain(resolverDB, time.Nanosecond, time.Hour)).options.explain)
+}
+
+func TestExplainer(t *testing.T) {
+	e := &explainer{threshold: time.Millisecond, dialect: "sqlite3", workers: make(chan struct{}, 1), capacity: 2, lru: list.New(), explains: map[string]*list.Element{}}
+	xtesting.True(t, e.allow("a"))
+	xtesting.True(t, e.allow("b"))
+	xtesting.False(t, e.allow("a"))
+	xtesting.True(t, e.allow("c")) // evict b
+	xtesting.Equal(t, e.lru.Len(), 2)
+	xtesting.True(t, e.allow("b")) // evict a
+	xtesting.True(t, e.allow("a"))
+
+	e.interval = 10 * time.Millisecond
+	xtesting.False(t, e.allow("a"))
+	time.Sleep(20 * time.Millisecond)
+	xtesting.True(t, e.allow("a"))
+
+	e.workers <- struct{}{} // busy
+	stmt := &explainedStatement{fingerprint: "d", duration: time.Second}
+	xtesting.False(t, e.submit("SELECT 1", nil, stmt))
+	_, remembered := e.explains["d"]
+	xtesting.False(t, remembered)
+	<-e.workers
+	xtesting.False(t, e.submit("SELECT 1", nil, &explainedStatement{fingerprint: "e", duration: time.Microsecond})) // fast
+	xtesting.False(t, e.submit("CREATE TABLE t (id int)", nil, &explainedStatement{fingerprint: "f", duration: time.Second}))
+}
+
+func TestExplainParser(t *testing.T) {
+	mysqlRows := parseMySQLExplain([]map[string]string{
+		{"id": "1", "select_type": "SIMPLE", "table": "users", "type": "ref", "key": "uk_name", "rows": "1", "extra": "Using index"},
+	})
+	xtesting.Equal(t, (&ExplainPlan{Rows: mysqlRows}).String(), "users:ref[uk_name](rows=1)")
+
+	postgresRows := parsePostgresExplain([]map[string]string{{"query plan": `[{"Plan": {"Node Type": "Nested Loop", "Plan Rows": 10, "Total Cost": 20.5, "Plans": [
+		{"Node Type": "Seq Scan", "Relation Name": "users", "Plan Rows": 10, "Total Cost": 1.1, "Filter": "(uid > 1)"},
+		{"Node Type": "Index Scan", "Relation Name": "orders", "Index Name": "orders_pkey", "Plan Rows": 1, "Total Cost": 2.2}]}}]`}})
+	xtesting.Equal(t, len(postgresRows), 3)
+	xtesting.Equal(t, postgresRows[1].Parent, 1)
+	xtesting.Equal(t, postgresRows[1].Detail, "cost=1.1 filter=(uid > 1)")
+	xtesting.Equal(t, (&ExplainPlan{Rows: postgresRows}).String(), "Nested Loop(rows=10), users:Seq Scan(rows=10), orders:Index Scan[orders_pkey](rows=1)")
+
+	sqliteRows := parseSQLiteExplain([]map[string]string{
+		{"id": "2", "parent": "0", "detail": "SEARCH TABLE users USING COVERING INDEX uk_name (name=?)"},
+		{"id": "3", "parent": "0", "detail": "SEARCH users USING INTEGER PRIMARY KEY (rowid=?)"},
+	})
+	xtesting.Equal(t, (&ExplainPlan{Rows: sqliteRows}).String(), "users:SEARCH[uk_name], users:SEARCH[PRIMARY KEY]")
+}
+
+func testNPlusOne(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	HookNPlusOne(db)
+	db.DropTableIfExists(&User{})
+	if db.AutoMigrate(&User{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	for i := 1; i <= 5; i++ {
+		db.Create(&User{Uid: i, Name: fmt.Sprintf("user%d", i)})
+	}
+
+	// report
+	reported := make([]*NPlusOneReport, 0)
+	detector := NewNPlusOneDetector(3, WithNPlusOneReporter(func(r *NPlusOneReport) { reported = append(reported, r) }))
+	rdb := detector.Attach(db)
+	for i := 1; i <= 5; i++ {
+		xtesting.Nil(t, rdb.Model(&User{}).Where("uid = ?", i).First(&User{}).Error)
+	}
+	cnt := 0
+	xtesting.Nil(t, rdb.Model(&User{}).Count(&cnt).Error)
+	xtesting.Nil(t, db.Model(&User{}).Where("uid = ?", 1).First(&User{}).Error) // not attached
+	xtesting.Equal(t, len(reported), 1)
+	xtesting.Equal(t, len(detector.Reports()), 1)
+	xtesting.Equal(t, reported[0].Count, 4)
+	xtesting.Equal(t, len(reported[0].Sources), 1)
+	xtesting.True(t, strings.Contains(reported[0].Sources[0], "xgorm_test.go"))
+	log.Println(reported[0])
+
+	// panic
+	detector.Reset()
+	xtesting.Equal(t, len(detector.Reports()), 0)
+	rdb = NewNPlusOneDetector(1, WithNPlusOnePanic(true)).Attach(db)
+	xtesting.Nil(t, rdb.Model(&User{}).Where("uid = ?", 1).First(&User{}).Error)
+	xtesting.Panic(t, func() { rdb.Model(&User{}).Where("uid = ?", 2).First(&User{}) })
+}
+
+func testQueryStats(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	HookQueryStats(db)
+	db.DropTableIfExists(&User{}, &Order{})
+	if db.AutoMigrate(&User{}, &Order{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	// stats
+	stats := NewQueryStats()
+	rdb := stats.Attach(db)
+	for i := 1; i <= 3; i++ {
+		xtesting.Nil(t, rdb.Create(&User{Uid: i, Name: fmt.Sprintf("user%d", i)}).Error)
+	}
+	xtesting.Nil(t, rdb.Create(&Order{Oid: 1, TenantId: 1, Name: "order1"}).Error)
+	xtesting.Nil(t, rdb.Find(&[]*User{}).Error)
+	xtesting.Nil(t, rdb.Model(&User{}).Where("uid > ?", 1).Update("name", gorm.Expr("name")).Error)
+	xtesting.Nil(t, rdb.Where("uid = ?", 3).Delete(&User{}).Error)
+	cnt := 0
+	xtesting.Nil(t, rdb.Model(&User{}).Count(&cnt).Error)
+	xtesting.Nil(t, db.Find(&[]*User{}).Error) // not attached
+
+	snapshot := stats.Snapshot()
+	xtesting.Equal(t, snapshot.Statements, 12) // including reloading after create
+	xtesting.True(t, snapshot.Duration > 0)
+	xtesting.Equal(t, snapshot.RowsRead, int64(4+3))
+	xtesting.Equal(t, snapshot.RowsAffected, int64(3+1+2+1))
+	xtesting.Equal(t, len(snapshot.Tables), 2)
+	xtesting.Equal(t, snapshot.Tables["users"].Statements, 10)
+	xtesting.Equal(t, snapshot.Tables["orders"].Statements, 2)
+	xtesting.Equal(t, snapshot.Tables["orders"].RowsAffected, int64(1))
+	xtesting.Equal(t, snapshot.Refused, 0)
+	stats.Reset()
+	xtesting.Equal(t, stats.Snapshot().Statements, 0)
+
+	// budget
+	stats = NewQueryStats(WithStatementBudget(2))
+	rdb = stats.Attach(db)
+	xtesting.Nil(t, rdb.Find(&[]*User{}).Error)
+	xtesting.Nil(t, rdb.Model(&User{}).Count(&cnt).Error)
+	err = rdb.Find(&[]*User{}).Error
+	xtesting.True(t, IsQueryBudgetExceededError(err))
+	log.Println(err)
+	xtesting.True(t, IsQueryBudgetExceededError(rdb.Create(&User{Uid: 4, Name: "user4"}).Error))
+	xtesting.True(t, IsQueryBudgetExceededError(rdb.Model(&User{}).Count(&cnt).Error))
+	_, err = rdb.Model(&User{}).Rows()
+	xtesting.True(t, IsQueryBudgetExceededError(err))
+	xtesting.True(t, IsQueryBudgetExceededError(rdb.Model(&User{}).Select("count(*)").Row().Scan(&cnt)))
+	var names []string
+	xtesting.True(t, IsQueryBudgetExceededError(rdb.Model(&User{}).Pluck("name", &names).Error))
+	xtesting.Equal(t, len(names), 0)
+	xtesting.Nil(t, db.Find(&[]*User{}).Error) // not attached
+	xtesting.Equal(t, stats.Snapshot().Statements, 2)
+	xtesting.Equal(t, stats.Snapshot().Refused, 6)
+	xtesting.False(t, IsQueryBudgetExceededError(errors.New("test")))
+
+	// budget with resolver
+	resolver, err := gorm.Open(giveDialect, NewResolver(db.DB(), RandomPolicy()))
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	HookDeletedAt(resolver, DefaultDeletedAtTimestamp)
+	HookQueryStats(resolver)
+	stats = NewQueryStats(WithStatementBudget(1))
+	rdb = stats.Attach(resolver)
+	xtesting.Nil(t, rdb.Model(&User{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 2)
+	xtesting.True(t, IsQueryBudgetExceededError(rdb.Model(&User{}).Count(&cnt).Error))
+	xtesting.True(t, IsQueryBudgetExceededError(rdb.Model(&User{}).Select("count(*)").Row().Scan(&cnt)))
+	_, err = rdb.Model(&User{}).Rows()
+	xtesting.True(t, IsQueryBudgetExceededError(err))
+	xtesting.Equal(t, stats.Snapshot().Refused, 3)
+}
+
+func testMigrator(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&User{}, &Order{}, DefaultMigrationTable, DefaultMigrationTable+"_lock")
+
+	dir, err := ioutil.TempDir("", "xgorm")
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	_ = ioutil.WriteFile(filepath.Join(dir, "0002_create_orders.up.sql"), []byte(`
+		-- create table
+		CREATE TABLE orders (oid INT PRIMARY KEY, tenant_id INT NOT NULL, name VARCHAR(255), created_at DATETIME, updated_at DATETIME,
+			deleted_at DATETIME DEFAULT '1970-01-01 00:00:01');
+		INSERT INTO orders (oid, tenant_id, name) VALUES (1, 1, 'a;b');`), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "0002_create_orders.down.sql"), []byte(`DROP TABLE orders;`), 0644)
+
+	register := func(m *Migrator) *Migrator {
+		xtesting.Nil(t, m.Register(&Migration{
+			ID:   "0001_create_users",
+			Up:   func(db *gorm.DB) error { return db.CreateTable(&User{}).Error },
+			Down: func(db *gorm.DB) error { return db.DropTable(&User{}).Error },
+		}, &Migration{
+			ID: "0003_insert_users",
+			Up: func(db *gorm.DB) error { return db.Create(&User{Uid: 1, Name: "user1"}).Error },
+		}))
+		xtesting.Nil(t, m.RegisterSQL(http.Dir(dir), "."))
+		return m
+	}
+	m := register(NewMigrator(db))
+	xtesting.True(t, errors.Is(m.Register(&Migration{ID: "0001_create_users", Up: func(*gorm.DB) error { return nil }}), ErrDuplicateMigration))
+	xtesting.NotNil(t, m.Register(&Migration{ID: "0004"}))
+	xtesting.Equal(t, len(m.Migrations()), 3)
+	xtesting.Equal(t, m.Migrations()[1].ID, "0002_create_orders")
+
+	applied := func() []string {
+		statuses, err := m.Status()
+		xtesting.Nil(t, err)
+		ids := make([]string, 0)
+		for _, status := range statuses {
+			if status.Applied {
+				ids = append(ids, status.ID)
+			}
+		}
+		return ids
+	}
+
+	// lock
+	unlock, err := m.lock()
+	xtesting.Nil(t, err)
+	locked := register(NewMigrator(db, WithMigrationLockTimeout(200*time.Millisecond)))
+	xtesting.True(t, errors.Is(locked.Migrate(), ErrMigrationLocked))
+	xtesting.Equal(t, len(applied()), 0)
+	unlock()
+	unlock, err = locked.lock()
+	xtesting.Nil(t, err)
+	unlock()
+
+	// dry run
+	l, hook := logrustest.NewNullLogger()
+	xtesting.Nil(t, register(NewMigrator(db, WithMigrationDryRun(NewLogrusLogger(l)))).Migrate())
+	xtesting.False(t, db.HasTable(&User{}))
+	xtesting.False(t, db.HasTable(DefaultMigrationTable))
+	statements := make([]string, 0)
+	for _, entry := range hook.AllEntries() {
+		if sql, ok := entry.Data["sql"].(string); ok {
+			statements = append(statements, sql)
+		}
+	}
+	xtesting.Equal(t, len(statements), 11) // migration table, 3 migrations and their version records
+	for _, statement := range statements {
+		log.Println("[dry run]", statement)
+	}
+
+	// migrate
+	xtesting.Equal(t, len(applied()), 0)
+	xtesting.True(t, errors.Is(m.MigrateTo("0000"), ErrMigrationNotFound))
+	xtesting.Nil(t, m.MigrateTo("0002_create_orders"))
+	xtesting.Equal(t, applied(), []string{"0001_create_users", "0002_create_orders"})
+	order := &Order{}
+	xtesting.Nil(t, db.Where("oid = ?", 1).First(order).Error)
+	xtesting.Equal(t, order.Name, "a;b")
+	xtesting.Nil(t, m.Migrate())
+	xtesting.Equal(t, len(applied()), 3)
+	xtesting.Nil(t, m.Migrate())
+
+	// rollback
+	xtesting.NotNil(t, m.Rollback(-1))
+	xtesting.Equal(t, len(applied()), 3)
+	xtesting.True(t, errors.Is(m.Rollback(1), ErrIrreversibleMigration))
+	xtesting.Equal(t, len(applied()), 3)
+	xtesting.Nil(t, db.Exec("DELETE FROM "+DefaultMigrationTable+" WHERE id = ?", "0003_insert_users").Error)
+	xtesting.Nil(t, m.Rollback(1))
+	xtesting.Equal(t, applied(), []string{"0001_create_users"})
+	xtesting.False(t, db.HasTable(&Order{}))
+	xtesting.Nil(t, m.MigrateTo("0002_create_orders"))
+	xtesting.Nil(t, m.MigrateTo("0001_create_users"))
+	xtesting.Equal(t, applied(), []string{"0001_create_users"})
+	xtesting.Nil(t, m.Rollback(5))
+	xtesting.Equal(t, len(applied()), 0)
+	xtesting.False(t, db.HasTable(&User{}))
+
+	// failed
+	failed := NewMigrator(db)
+	_ = failed.Register(&Migration{ID: "0001_failed", Up: func(db *gorm.DB) error { return db.Exec("SELECT * FROM not_existed").Error }})
+	xtesting.NotNil(t, failed.Migrate())
+	statuses, err := failed.Status()
+	xtesting.Nil(t, err)
+	xtesting.False(t, statuses[0].Applied)
+}
+
+func TestSplitSQLStatements(t *testing.T) {
+	for _, tc := range []struct {
+		give string
+		want []string
+	}{
+		{"", []string{}},
+		{" ; ;", []string{}},
+		{"SELECT 1", []string{"SELECT 1"}},
+		{"SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"-- comment; \nSELECT 1; /* ; */ SELECT 2; -- end", []string{"SELECT 1", "SELECT 2"}},
+		{`INSERT INTO t VALUES ('a;b', "c;d", 'e''f;', 'g\';h'); SELECT 1`, []string{`INSERT INTO t VALUES ('a;b', "c;d", 'e''f;', 'g\';h')`, "SELECT 1"}},
+		{"SELECT `a;b` FROM t", []string{"SELECT `a;b` FROM t"}},
+		{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql; SELECT $1", []string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", "SELECT $1"}},
+		{"DO $body$ BEGIN PERFORM 1; END $body$; SELECT 1", []string{"DO $body$ BEGIN PERFORM 1; END $body$", "SELECT 1"}},
+	} {
+		xtesting.Equal(t, splitSQLStatements(tc.give), tc.want)
+	}
+}
+
+func TestIsReadStatement(t *testing.T) {
+	for _, tc := range []struct {
+		give string
+		want bool
+	}{
+		{"", false},
+		{"SELECT count(*) FROM users", true},
+		{"  select * from `users` where name = 'into'", true},
+		{"SHOW TABLES", true},
+		{"EXPLAIN SELECT 1", true},
+		{"SELECT * FROM users FOR UPDATE", false},
+		{"SELECT * FROM users LOCK IN SHARE MODE", false},
+		{"SELECT * INTO backup FROM users", false},
+		{"EXPLAIN ANALYZE DELETE FROM users", false},
+		{"WITH t AS (DELETE FROM users RETURNING *) SELECT * FROM t", false},
+		{"PRAGMA foreign_keys = OFF", false},
+		{"INSERT INTO users (uid) VALUES (1) RETURNING uid", false},
+		{"DESCRIBE users", false},
+	} {
+		xtesting.Equal(t, isReadStatement(tc.give), tc.want)
+	}
+}
+
+type DiffUser struct {
+	Uid  int    `gorm:"primary_key; auto_increment"`
+	Name string `gorm:"not null; size:64; unique_index:uk_diff_name"`
+	Age  int    `gorm:"default:18"`
+	GormTime
+}
+
+func testSchemaDiff(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	db.DropTableIfExists(&User{}, &Order{}, &DiffUser{})
+	if db.AutoMigrate(&User{}, &Order{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	// no diff
+	diffs, err := DiffSchema(db, &User{}, &Order{})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(diffs), 0)
+
+	// missing table
+	diffs, err = DiffSchema(db, &User{}, &DiffUser{})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(diffs), 1)
+	xtesting.True(t, diffs[0].MissingTable)
+	xtesting.Equal(t, len(diffs[0].MissingColumns), 6)
+	xtesting.Equal(t, len(diffs[0].MissingIndexes), 2)
+	for _, statement := range diffs[0].MigrationSQL() {
+		xtesting.Nil(t, db.Exec(statement).Error)
+	}
+	diffs, err = DiffSchema(db, &DiffUser{})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(diffs), 0)
+
+	// changed
+	db.DropTableIfExists(&DiffUser{})
+	xtesting.Nil(t, db.Exec(`CREATE TABLE diff_users (uid INTEGER PRIMARY KEY, name VARCHAR(255), extra VARCHAR(255),
+		created_at DATETIME, updated_at DATETIME, deleted_at DATETIME DEFAULT '1970-01-01 00:00:01')`).Error)
+	xtesting.Nil(t, db.Exec(`CREATE INDEX idx_extra ON diff_users (extra)`).Error)
+	diffs, err = DiffSchema(db, &DiffUser{})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(diffs), 1)
+	diff := diffs[0]
+	log.Println(diff)
+	xtesting.False(t, diff.MissingTable)
+	xtesting.Equal(t, len(diff.MissingColumns), 1)
+	xtesting.Equal(t, diff.MissingColumns[0].Name, "age")
+	xtesting.Equal(t, len(diff.ExtraColumns), 1)
+	xtesting.Equal(t, diff.ExtraColumns[0].Name, "extra")
+	xtesting.Equal(t, len(diff.ChangedColumns), 1)
+	xtesting.Equal(t, diff.ChangedColumns[0].Name, "name")
+	xtesting.Equal(t, diff.ChangedColumns[0].Changes, []string{"type", "nullable"})
+	xtesting.Equal(t, len(diff.MissingIndexes), 2)
+	xtesting.Equal(t, len(diff.ExtraIndexes), 1)
+	xtesting.Equal(t, diff.ExtraIndexes[0].Name, "idx_extra")
+
+	for _, statement := range diff.MigrationSQL() {
+		log.Println(statement)
+		if !strings.HasPrefix(statement, "--") {
+			xtesting.Nil(t, db.Exec(statement).Error)
+		}
+	}
+	diffs, err = DiffSchema(db, &DiffUser{})
+	xtesting.Nil(t, err)
+	if IsSQLite(db) {
+		xtesting.Equal(t, len(diffs), 1) // altering column is not supported
+		xtesting.Equal(t, len(diffs[0].ChangedColumns), 1)
+		xtesting.Equal(t, len(diffs[0].MissingColumns)+len(diffs[0].MissingIndexes)+len(diffs[0].ExtraIndexes), 0)
+	} else {
+		xtesting.Equal(t, len(diffs), 0)
+	}
+}
+
+func testFixture(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&User{}, &Order{}, "tags")
+	if db.AutoMigrate(&User{}, &Order{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	xtesting.Nil(t, db.Table("tags").CreateTable(&struct { // no model registered
+		Id      int `gorm:"primary_key; auto_increment"`
+		Name    string
+		OrderId int
+	}{}).Error)
+
+	dir, err := ioutil.TempDir("", "xgorm_fixture")
+	xtesting.Nil(t, err)
+	defer os.RemoveAll(dir)
+	xtesting.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fixture.yml"), []byte(`
+orders:
+  order1:
+    tenant_id: "{{ ref user2 }}"
+    name: "order{{ seq }} of {{ ref user2 name }}"
+  order2:
+    tenant_id: "{{ ref users.user1 uid }}"
+    name: "order{{ seq }}"
+    created_at: "{{ now -24h }}"
+User:
+  user1:
+    name: "user1"
+  user2:
+    name: "user2-{{ seq }}" # inserted first, before order1
+    created_at: "2020-01-01 00:00:00"
+`), 0644), nil)
+
+	// parse
+	fixtures := NewFixtures(db, WithFixtureModels(&User{}, &Order{}))
+	xtesting.Nil(t, fixtures.AddFiles(filepath.Join(dir, "fixture.yml")))
+	xtesting.Nil(t, fixtures.AddBytes([]byte(`{"tags": [{"name": "tag1", "order_id": "{{ ref order2 }}"}, {"name": "tag2"}]}`)))
+	xtesting.NotNil(t, fixtures.AddFiles(filepath.Join(dir, "not_found.yml")))
+	xtesting.NotNil(t, NewFixtures(db).AddBytes([]byte(`users: {user1: {name: "{{ unknown }}"}}`)))
+
+	// load
+	for i := 0; i < 2; i++ {
+		xtesting.Nil(t, fixtures.Load())
+		users := make([]*User, 0)
+		xtesting.Nil(t, db.Model(&User{}).Order("uid").Find(&users).Error)
+		xtesting.Equal(t, len(users), 2)
+		xtesting.Equal(t, users[0].Name, "user2-1")
+		xtesting.Equal(t, users[0].CreatedAt.Year(), 2020)
+		xtesting.Equal(t, users[1].Name, "user1")
+		orders := make([]*Order, 0)
+		xtesting.Nil(t, db.Model(&Order{}).Order("oid").Find(&orders).Error)
+		xtesting.Equal(t, len(orders), 2)
+		xtesting.Equal(t, orders[0].Name, "order1 of user2-1")
+		xtesting.Equal(t, orders[0].TenantId, users[0].Uid)
+		xtesting.Equal(t, orders[1].Name, "order2")
+		xtesting.Equal(t, orders[1].TenantId, users[1].Uid)
+		xtesting.True(t, time.Since(orders[1].CreatedAt) > 23*time.Hour)
+		cnt := 0
+		xtesting.Nil(t, db.Table("tags").Where("order_id = ?", orders[1].Oid).Count(&cnt).Error)
+		xtesting.Equal(t, cnt, 1)
+
+		uid, ok := fixtures.Value("user1", "")
+		xtesting.True(t, ok)
+		xtesting.Equal(t, uid, users[1].Uid)
+		name, ok := fixtures.Value("orders.order1", "name")
+		xtesting.True(t, ok)
+		xtesting.Equal(t, name, "order1 of user2-1")
+		_, ok = fixtures.Value("tags_2", "id")
+		xtesting.True(t, ok)
+		_, ok = fixtures.Value("user3", "")
+		xtesting.False(t, ok)
+	}
+
+	// reset
+	xtesting.Nil(t, fixtures.Reset())
+	cnt := 0
+	xtesting.Nil(t, db.Model(&User{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 0)
+	_, ok := fixtures.Value("user1", "")
+	xtesting.False(t, ok)
+
+	// circular and missing reference
+	circular := NewFixtures(db)
+	xtesting.Nil(t, circular.AddBytes([]byte(`tags: {tag1: {order_id: "{{ ref tag2 }}"}, tag2: {order_id: "{{ ref tag1 }}"}}`)))
+	xtesting.NotNil(t, circular.Load())
+	missing := NewFixtures(db)
+	xtesting.Nil(t, missing.AddBytes([]byte(`tags: [{order_id: "{{ ref tag3 }}"}]`)))
+	xtesting.NotNil(t, missing.Load())
+
+	// foreign key checks are restored after failure
+	db.DB().SetMaxOpenConns(1) // reuse the connection of the failed transaction
+	defer db.DB().SetMaxOpenConns(0)
+	failed := NewFixtures(db)
+	xtesting.Nil(t, failed.AddBytes([]byte(`tags: [{unknown_column: 1}]`)))
+	xtesting.NotNil(t, failed.Load())
+	checks := 0
+	if IsMySQL(db) {
+		xtesting.Nil(t, db.Raw("SELECT @@FOREIGN_KEY_CHECKS").Row().Scan(&checks))
+		xtesting.Equal(t, checks, 1)
+	} else if IsSQLite(db) {
+		xtesting.Nil(t, db.Raw("PRAGMA defer_foreign_keys").Row().Scan(&checks))
+		xtesting.Equal(t, checks, 0)
+	}
+}
+
+func testTestDB(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.DropTableIfExists(&User{})
+	if IsMySQL(db) {
+		xtesting.Nil(t, db.AutoMigrate(&User{}).Error) // DDL is not transactional in mysql
+	}
+
+	tdb, err := NewTestDB(giveDialect, giveParam, WithTestDBModels(&User{}), WithTestDBLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags))))
+	xtesting.Nil(t, err)
+	xtesting.Nil(t, tdb.Create(&User{Uid: 1, Name: "user1"}).Error)
+
+	// commit, mapped to release savepoint
+	tx := tdb.Begin()
+	xtesting.Nil(t, tx.Error)
+	xtesting.Nil(t, tx.Create(&User{Uid: 2, Name: "user2"}).Error)
+	xtesting.Nil(t, tx.Commit().Error)
+
+	// rollback, mapped to rollback to savepoint
+	tx = tdb.Begin()
+	xtesting.Nil(t, tx.Create(&User{Uid: 3, Name: "user3"}).Error)
+	xtesting.Nil(t, tx.Rollback().Error)
+
+	// nested
+	xtesting.NotNil(t, tdb.Transaction(func(tx *gorm.DB) error {
+		xtesting.Nil(t, tx.Create(&User{Uid: 4, Name: "user4"}).Error)
+		return tdb.Transaction(func(tx *gorm.DB) error {
+			xtesting.Nil(t, tx.Create(&User{Uid: 5, Name: "user5"}).Error)
+			return errors.New("test")
+		})
+	}))
+	users := make([]*User, 0)
+	xtesting.Nil(t, tdb.Model(&User{}).Order("uid").Find(&users).Error)
+	xtesting.Equal(t, len(users), 2)
+
+	// deleted_at hooked
+	xtesting.Nil(t, tdb.Delete(&User{Uid: 2}).Error)
+	cnt := 0
+	xtesting.Nil(t, tdb.Model(&User{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 1)
+	xtesting.Nil(t, tdb.Unscoped().Model(&User{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 2)
+
+	// rolled back after closing
+	xtesting.Nil(t, tdb.Close())
+	xtesting.NotNil(t, tdb.Create(&User{Uid: 6, Name: "user6"}).Error)
+	if IsMySQL(db) {
+		xtesting.Nil(t, db.Model(&User{}).Count(&cnt).Error)
+		xtesting.Equal(t, cnt, 0)
+	} else {
+		xtesting.False(t, db.HasTable(&User{}))
+	}
+}
+
+func testRecorder(t *testing.T, giveDialect, giveParam string) {
+	dir, err := ioutil.TempDir("", "xgorm_recorder")
+	xtesting.Nil(t, err)
+	defer os.RemoveAll(dir)
+	golden := filepath.Join(dir, "testdata", giveDialect+".golden.json")
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	run := func(db *gorm.DB) {
+		HookDeletedAt(db, DefaultDeletedAtTimestamp)
+		db.DropTableIfExists(&User{})
+		xtesting.Nil(t, db.AutoMigrate(&User{}).Error)
+		user := &User{Name: "user1"}
+		xtesting.Nil(t, db.Create(user).Error)
+		xtesting.Equal(t, user.Uid, 1)
+		status, err := CreateErr(db.Create(&User{Name: "user1"}))
+		xtesting.Equal(t, status, xstatus.DbExisted)
+		xtesting.NotNil(t, err)
+		xtesting.Nil(t, db.Create(&User{Name: "user2"}).Error)
+		users := make([]*User, 0)
+		xtesting.Nil(t, db.Model(&User{}).Where("uid IN (?)", []int{1, 2}).Order("uid").Find(&users).Error)
+		xtesting.Equal(t, len(users), 2)
+		xtesting.Equal(t, users[1].Name, "user2")
+		status, _ = DeleteErr(db.Delete(&User{Uid: 2}))
+		xtesting.Equal(t, status, xstatus.DbSuccess)
+		status, _ = QueryErr(db.Model(&User{}).Where("uid = ?", 2).First(&User{}))
+		xtesting.Equal(t, status, xstatus.DbNotFound)
+	}
+
+	// record
+	rec := NewRecorder(golden, WithRecorderMode(RecordMode))
+	xtesting.Nil(t, rec.Register("xgorm_record_"+suffix, giveDialect))
+	xtesting.NotNil(t, rec.Register("xgorm_record_"+suffix, giveDialect))
+	xtesting.NotNil(t, rec.Register("xgorm_record_unknown_"+suffix, "unknown"))
+	db, err := gorm.Open("xgorm_record_"+suffix, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	xtesting.True(t, IsMySQL(db) || IsSQLite(db))
+	run(db)
+	xtesting.Nil(t, db.Close())
+	xtesting.Nil(t, rec.Close())
+	_, err = os.Stat(golden)
+	xtesting.Nil(t, err)
+
+	// replay
+	for _, strict := range []bool{true, false} {
+		rec = NewRecorder(golden, WithRecorderStrict(strict))
+		name := fmt.Sprintf("xgorm_replay_%t_%s", strict, suffix)
+		xtesting.Nil(t, rec.Register(name, giveDialect))
+		db, err = gorm.Open(name, "")
+		xtesting.Nil(t, err)
+		run(db)
+		xtesting.Equal(t, rec.Unused(), 0)
+		xtesting.Nil(t, db.Close())
+	}
+
+	// mismatch
+	for _, strict := range []bool{true, false} {
+		rec = NewRecorder(golden, WithRecorderStrict(strict))
+		name := fmt.Sprintf("xgorm_mismatch_%t_%s", strict, suffix)
+		xtesting.Nil(t, rec.Register(name, giveDialect))
+		db, err = gorm.Open(name, "")
+		xtesting.Nil(t, err)
+		HookDeletedAt(db, DefaultDeletedAtTimestamp)
+		err = db.Model(&User{}).Where("uid IN (?)", []int{1, 2}).Order("uid").Find(&[]*User{}).Error
+		if strict {
+			xtesting.NotNil(t, err)
+		} else {
+			xtesting.Nil(t, err)
+		}
+		xtesting.NotNil(t, db.Model(&User{}).Where("name = ?", "user3").Find(&[]*User{}).Error)
+		xtesting.Nil(t, db.Close())
+	}
+	xtesting.NotNil(t, NewRecorder(filepath.Join(dir, "not_found.json")).Register("xgorm_not_found_"+suffix, giveDialect))
+}
+
+func testBatchCreate(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&User{})
+	if db.AutoMigrate(&User{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	// create
+	users := make([]*User, 0, 1200)
+	for i := 1; i <= 1200; i++ {
+		users = append(users, &User{Name: fmt.Sprintf("user%d", i)})
+	}
+	rdb := BatchCreate(db, users, 500)
+	status, err := CreateErr(rdb)
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, rdb.RowsAffected, int64(1200))
+	for i, user := range users {
+		xtesting.Equal(t, user.Uid, i+1)
+		xtesting.False(t, user.CreatedAt.IsZero())
+	}
+	cnt := 0
+	xtesting.Nil(t, db.Model(&User{}).Count(&cnt).Error) // deleted_at defaults to sentinel
+	xtesting.Equal(t, cnt, 1200)
+	found := &User{}
+	xtesting.Nil(t, db.Model(&User{}).Where("uid = ?", 600).First(found).Error)
+	xtesting.Equal(t, found.Name, "user600")
+
+	// mixed primary keys and slice of struct
+	values := []User{{Name: "user1201"}, {Uid: 2000, Name: "user2000"}, {Name: "user2001"}}
+	rdb = BatchCreate(db, &values, 0)
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(3))
+	xtesting.Equal(t, values[0].Uid, 1201)
+	xtesting.Equal(t, values[2].Uid, 2001)
+
+	// duplicate and rollback
+	status, err = CreateErr(BatchCreate(db, []*User{{Name: "user3000"}, {Name: "user1"}}, 10))
+	xtesting.Equal(t, status, xstatus.DbExisted)
+	xtesting.NotNil(t, err)
+	xtesting.Equal(t, db.Model(&User{}).Where("name = ?", "user3000").First(&User{}).Error, gorm.ErrRecordNotFound)
+
+	// in transaction
+	xtesting.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		return BatchCreate(tx, []*User{{Name: "user3001"}, {Name: "user3002"}}, 1).Error
+	}))
+	xtesting.Nil(t, db.Model(&User{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 1205)
+
+	// invalid
+	rdb = BatchCreate(db, []*User{}, 10)
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(0))
+	xtesting.NotNil(t, BatchCreate(db, &User{}, 10).Error)
+	xtesting.NotNil(t, BatchCreate(db, []int{1}, 10).Error)
+}
+
+type Counter struct {
+	Id    int    `gorm:"primary_key; auto_increment"`
+	Name  string `gorm:"not null; unique_index:uk_counter_name"`
+	Count int    `gorm:"not null"`
+	GormTime
+}
+
+func testUpsert(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&Counter{})
+	if db.AutoMigrate(&Counter{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	get := func(name string) *Counter {
+		counter := &Counter{}
+		xtesting.Nil(t, db.Model(&Counter{}).Where("name = ?", name).First(counter).Error)
+		return counter
+	}
+
+	// insert
+	counter := &Counter{Name: "visit", Count: 1}
+	status, err := Upsert(db, counter, []string{"name"}, []string{"count = count + EXCLUDED.count"})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, status, UpsertInserted)
+	xtesting.Equal(t, counter.Id, 1)
+	status, err = Upsert(db, &Counter{Name: "like", Count: 5}, []string{"name"}, []string{"count"})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, status, UpsertInserted)
+	xtesting.Equal(t, get("like").Id, 2)
+
+	// update by expression
+	updatedAt := get("visit").UpdatedAt
+	time.Sleep(time.Second)
+	status, err = Upsert(db, &Counter{Name: "visit", Count: 2}, []string{"name"}, []string{"count = count + EXCLUDED.count"})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, status, UpsertUpdated)
+	xtesting.Equal(t, get("visit").Count, 3)
+	xtesting.True(t, get("visit").UpdatedAt.After(updatedAt))
+
+	// update by column
+	status, err = Upsert(db, &Counter{Name: "like", Count: 10}, []string{"name"}, []string{"count"})
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, status, UpsertUpdated)
+	xtesting.Equal(t, get("like").Count, 10)
+
+	// do nothing
+	status, err = Upsert(db, &Counter{Name: "like", Count: 20}, []string{"name"}, nil)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, status, UpsertUnchanged)
+	xtesting.Equal(t, get("like").Count, 10)
+	status, err = Upsert(db, &Counter{Name: "share", Count: 1}, []string{"name"}, nil)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, status, UpsertInserted)
+	cnt := 0
+	xtesting.Nil(t, db.Model(&Counter{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 3)
+
+	// failed
+	status, err = Upsert(db, &Counter{Name: "like"}, []string{"name"}, []string{"unknown"})
+	xtesting.Equal(t, status, xstatus.DbFailed)
+	xtesting.NotNil(t, err)
+	if !IsMySQL(db) {
+		status, err = Upsert(db, &Counter{Name: "like"}, nil, []string{"count"})
+		xtesting.Equal(t, status, xstatus.DbFailed)
+		xtesting.NotNil(t, err)
+	}
+}
+
+func testBulkUpdate(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&Counter{})
+	if db.AutoMigrate(&Counter{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	counters := make([]*Counter, 0, 10)
+	for i := 1; i <= 10; i++ {
+		counters = append(counters, &Counter{Name: fmt.Sprintf("counter%d", i), Count: i})
+	}
+	xtesting.Nil(t, BatchCreate(db, counters, 0).Error)
+	get := func(id int) *Counter {
+		counter := &Counter{}
+		xtesting.Nil(t, db.Model(&Counter{}).Where("id = ?", id).First(counter).Error)
+		return counter
+	}
+
+	// slice of models
+	updatedAt := get(1).UpdatedAt
+	time.Sleep(time.Second)
+	for _, counter := range counters {
+		counter.Name += "_new"
+		counter.Count *= 10
+	}
+	counts, err := BulkUpdate(db, &Counter{}, counters, 4)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, counts, []int64{4, 4, 2})
+	for i := 1; i <= 10; i++ {
+		counter := get(i)
+		xtesting.Equal(t, counter.Name, fmt.Sprintf("counter%d_new", i))
+		xtesting.Equal(t, counter.Count, i*10)
+	}
+	xtesting.True(t, get(1).UpdatedAt.After(updatedAt))
+
+	// specific columns
+	counts, err = BulkUpdate(db, &Counter{}, []Counter{{Id: 1, Name: "ignored", Count: 1}, {Id: 2, Count: 2}}, 0, "Count")
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, counts, []int64{2})
+	xtesting.Equal(t, get(1).Name, "counter1_new")
+	xtesting.Equal(t, get(1).Count, 1)
+	xtesting.Equal(t, get(2).Count, 2)
+
+	// slice of maps and map by primary key
+	counts, err = BulkUpdate(db, &Counter{}, []map[string]interface{}{{"id": 3, "count": 3}, {"id": 4, "name": "counter4"}}, 0)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, counts, []int64{2})
+	xtesting.Equal(t, get(3).Count, 3)
+	xtesting.Equal(t, get(3).Name, "counter3_new")
+	xtesting.Equal(t, get(4).Count, 40)
+	xtesting.Equal(t, get(4).Name, "counter4")
+	counts, err = BulkUpdate(db, &Counter{}, map[int]map[string]interface{}{5: {"count": 5}, 6: {"Count": 6}, 100: {"count": 100}}, 0)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, counts, []int64{2})
+	xtesting.Equal(t, get(5).Count, 5)
+	xtesting.Equal(t, get(6).Count, 6)
+
+	// soft deleted
+	xtesting.Nil(t, db.Delete(&Counter{Id: 7}).Error)
+	counts, err = BulkUpdate(db, &Counter{}, []map[string]interface{}{{"id": 7, "count": 7}, {"id": 8, "count": 8}}, 0)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, counts, []int64{1})
+	xtesting.Equal(t, get(8).Count, 8)
+
+	// failed and rollback
+	_, err = BulkUpdate(db, &Counter{}, []map[string]interface{}{{"id": 9, "name": "counter9"}, {"id": 10, "name": "counter1_new"}}, 1)
+	xtesting.NotNil(t, err)
+	xtesting.Equal(t, get(9).Name, "counter9_new")
+
+	// invalid
+	counts, err = BulkUpdate(db, &Counter{}, []*Counter{}, 0)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(counts), 0)
+	_, err = BulkUpdate(db, &Counter{}, []Counter{{Name: "counter"}}, 0)
+	xtesting.NotNil(t, err)
+	_, err = BulkUpdate(db, &Counter{}, []map[string]interface{}{{"count": 1}}, 0)
+	xtesting.NotNil(t, err)
+	_, err = BulkUpdate(db, &Counter{}, []map[string]interface{}{{"id": 1, "unknown": 1}}, 0)
+	xtesting.NotNil(t, err)
+	_, err = BulkUpdate(db, &Counter{}, counters, 0, "unknown")
+	xtesting.NotNil(t, err)
+	_, err = BulkUpdate(db, &Counter{}, []int{1}, 0)
+	xtesting.NotNil(t, err)
+}
+
+func testIterate(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&User{})
+	if db.AutoMigrate(&User{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	users := make([]*User, 0, 25)
+	for i := 1; i <= 25; i++ {
+		users = append(users, &User{Name: fmt.Sprintf("user%d", i)})
+	}
+	xtesting.Nil(t, BatchCreate(db, users, 0).Error)
+	xtesting.Nil(t, db.Delete(&User{Uid: 5}).Error)
+
+	// find in batches
+	batch := make([]*User, 0)
+	sizes := make([]int, 0)
+	uids := make([]int, 0)
+	rdb := FindInBatches(db.Order("name DESC"), &batch, 10, func(n int) error {
+		xtesting.Equal(t, n, len(sizes)+1)
+		sizes = append(sizes, len(batch))
+		for _, user := range batch {
+			uids = append(uids, user.Uid)
+		}
+		return nil
+	})
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(24))
+	xtesting.Equal(t, sizes, []int{10, 10, 4})
+	xtesting.Equal(t, uids[3:5], []int{4, 6}) // soft deleted is skipped
+	xtesting.Equal(t, uids[23], 25)
+
+	// conditions, early stop and error
+	sizes = sizes[:0]
+	rdb = FindInBatches(db.Where("uid > ?", 20), &batch, 2, func(int) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	})
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, sizes, []int{2, 2, 1})
+	rdb = FindInBatches(db.Offset(1).Limit(3), &batch, 10, func(int) error { return nil })
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(24)) // offset and limit are cleared
+	rdb = FindInBatches(db, &batch, 10, func(n int) error {
+		if n == 2 {
+			return ErrStopBatches
+		}
+		return nil
+	})
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(20))
+	xtesting.Equal(t, batch[9].Uid, 21)
+	rdb = FindInBatches(db, &batch, 10, func(int) error {
+		return errors.New("test")
+	})
+	xtesting.Equal(t, rdb.Error.Error(), "test")
+	xtesting.Equal(t, rdb.RowsAffected, int64(10))
+	xtesting.NotNil(t, FindInBatches(db, batch, 10, func(int) error { return nil }).Error)
+	xtesting.NotNil(t, FindInBatches(db, &batch, 0, func(int) error { return nil }).Error)
+
+	// row iterator
+	it, err := NewRowIterator(db.Model(&User{}).Where("uid <= ?", 10).Order("uid"))
+	xtesting.Nil(t, err)
+	uids = uids[:0]
+	for it.Next() {
+		user := &User{}
+		xtesting.Nil(t, it.Scan(user))
+		xtesting.Equal(t, user.Name, fmt.Sprintf("user%d", user.Uid))
+		uids = append(uids, user.Uid)
+	}
+	xtesting.Nil(t, it.Err())
+	xtesting.Nil(t, it.Close())
+	xtesting.Equal(t, uids, []int{1, 2, 3, 4, 6, 7, 8, 9, 10})
+	_, err = NewRowIterator(db.Table("not_existed"))
+	xtesting.NotNil(t, err)
+	edb := db.Model(&User{}).Where("uid = ?", 1)
+	_ = edb.AddError(errors.New("test"))
+	_, err = NewRowIterator(edb)
+	xtesting.Equal(t, err.Error(), "test")
+	tdb, err := gorm.Open(giveDialect, db.DB())
+	xtesting.Nil(t, err)
+	HookDeletedAt(tdb, DefaultDeletedAtTimestamp)
+	HookTenant(tdb)
+	tdb.DropTableIfExists(&Order{})
+	xtesting.Nil(t, tdb.AutoMigrate(&Order{}).Error)
+	xtesting.Nil(t, WithTenant(tdb, 2).Create(&Order{Oid: 1, Name: "order1"}).Error)
+	it, err = NewRowIterator(tdb.Model(&Order{}))
+	xtesting.Nil(t, it)
+	xtesting.Equal(t, err, ErrMissingTenant)
+	it, err = NewRowIterator(WithTenant(tdb, 2).Model(&Order{}))
+	xtesting.Nil(t, err)
+	xtesting.True(t, it.Next())
+	xtesting.False(t, it.Next())
+	xtesting.Nil(t, it.Close())
+}
+
+func testRepository(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&User{})
+	if db.AutoMigrate(&User{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	repo := NewRepository(db, &User{}, PropertyDict{
+		"uid":  NewPropertyValue(false, "uid"),
+		"name": NewPropertyValue(false, "name"),
+	})
+
+	// create
+	for i := 1; i <= 5; i++ {
+		status, err := repo.Create(&User{Name: fmt.Sprintf("user%d", i)})
+		xtesting.Equal(t, status, xstatus.DbSuccess)
+		xtesting.Nil(t, err)
+	}
+	status, err := repo.Create(&User{Name: "user1"})
+	xtesting.Equal(t, status, xstatus.DbExisted)
+	xtesting.NotNil(t, err)
+
+	// get
+	user := &User{}
+	status, err = repo.Get(user, "name = ?", "user2")
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, user.Uid, 2)
+	status, err = repo.Get(&User{}, "name = ?", "user6")
+	xtesting.Equal(t, status, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+
+	// list
+	users := make([]*User, 0)
+	total := int64(0)
+	status, err = repo.List(&users, WithListWhere("uid > ?", 1), WithListOrder("uid desc"), WithListPage(2, 2), WithListTotal(&total))
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, total, int64(4))
+	xtesting.Equal(t, len(users), 2)
+	xtesting.Equal(t, users[0].Uid, 3)
+	xtesting.Equal(t, users[1].Uid, 2)
+	values := make([]User, 0)
+	status, err = repo.List(&values, WithListWhere("uid > ?", 10))
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(values), 0)
+
+	// update
+	status, err = repo.Update(map[string]interface{}{"name": "user2_new"}, "uid = ?", 2)
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	status, err = repo.Update(&User{Name: "user2_new"}, "uid = ?", 3)
+	xtesting.Equal(t, status, xstatus.DbExisted)
+	xtesting.NotNil(t, err)
+	status, err = repo.Update(map[string]interface{}{"name": "user100"}, "uid = ?", 100)
+	xtesting.Equal(t, status, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+
+	// delete, exists and count
+	status, err = repo.Delete("uid = ?", 5)
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	status, err = repo.Delete("uid = ?", 5)
+	xtesting.Equal(t, status, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+	status, err = repo.Exists("name = ?", "user5")
+	xtesting.Equal(t, status, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+	status, err = repo.Exists("name = ?", "user4")
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	status, err = repo.Count(&total)
+	xtesting.Equal(t, status, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, total, int64(4))
+	cnt := 0
+	xtesting.Nil(t, db.Unscoped().Model(&User{}).Count(&cnt).Error) // soft deleted
+	xtesting.Equal(t, cnt, 5)
+
+	// transaction
+	xtesting.NotNil(t, db.Transaction(func(tx *gorm.DB) error {
+		txRepo := repo.WithTx(tx)
+		if _, err := txRepo.Create(&User{Name: "user6"}); err != nil {
+			return err
+		}
+		status, err := txRepo.Exists("name = ?", "user6")
+		xtesting.Equal(t, status, xstatus.DbSuccess)
+		xtesting.Nil(t, err)
+		return errors.New("rollback")
+	}))
+	status, err = repo.Exists("name = ?", "user6")
+	xtesting.Equal(t, status, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+
+	// invalid
+	_, err = repo.Get(User{})
+	xtesting.NotNil(t, err)
+	_, err = repo.Get(&Counter{})
+	xtesting.NotNil(t, err)
+	_, err = repo.List(&[]*Counter{})
+	xtesting.NotNil(t, err)
+	_, err = repo.Create(&Counter{})
+	xtesting.NotNil(t, err)
+	_, err = repo.Update(map[string]interface{}{"name": "user"})
+	xtesting.NotNil(t, err)
+	_, err = repo.Delete()
+	xtesting.NotNil(t, err)
+	xtesting.Panic(t, func() { NewRepository(db, 0, nil) })
+}
+
+func testLock(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&Counter{})
+	if db.AutoMigrate(&Counter{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	xtesting.Nil(t, BatchCreate(db, []*Counter{{Name: "counter1"}, {Name: "counter2"}}, 0).Error)
+
+	// lock clause
+	xtesting.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		ldb := Lock(tx, LockForUpdate, WithLockSkipLocked(true))
+		option, ok := ldb.Get("gorm:query_option")
+		if IsSQLite(db) {
+			xtesting.False(t, ok) // not supported
+		} else {
+			xtesting.True(t, ok)
+			xtesting.Equal(t, option, "FOR UPDATE SKIP LOCKED")
+		}
+		counter := &Counter{}
+		status, err := QueryErr(ldb.Where("name = ?", "counter1").First(counter))
+		xtesting.Equal(t, status, xstatus.DbSuccess)
+		xtesting.Nil(t, err)
+		xtesting.Equal(t, counter.Id, 1)
+		return nil
+	}))
+
+	// lock unavailable
+	db2, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	defer db2.Close()
+	HookDeletedAt(db2, DefaultDeletedAtTimestamp)
+	tx1 := db.Begin()
+	defer tx1.Rollback()
+	if IsSQLite(db) {
+		xtesting.Nil(t, tx1.Model(&Counter{}).Where("id = ?", 1).Update("count", 1).Error) // database is locked
+	} else {
+		xtesting.Nil(t, Lock(tx1, LockForUpdate).Where("id = ?", 1).First(&Counter{}).Error)
+	}
+	tx2 := db2.Begin()
+	defer tx2.Rollback()
+	xtesting.Nil(t, SetLockTimeout(tx2, 100*time.Millisecond))
+	if !IsSQLite(db) {
+		status, err := QueryErr(Lock(tx2, LockForUpdate, WithLockNoWait(true)).Where("id = ?", 1).First(&Counter{}))
+		xtesting.Equal(t, status, LockUnavailable)
+		xtesting.NotNil(t, err)
+		counter := &Counter{}
+		status, err = QueryErr(Lock(tx2, LockForUpdate, WithLockSkipLocked(true)).First(counter))
+		xtesting.Equal(t, status, xstatus.DbSuccess)
+		xtesting.Equal(t, counter.Id, 2)
+	}
+	status, err := UpdateErr(tx2.Model(&Counter{}).Where("id = ?", 1).Update("count", 2))
+	xtesting.Equal(t, status, LockUnavailable)
+	xtesting.NotNil(t, err)
+	status, err = DeleteErr(tx2.Where("id = ?", 1).Delete(&Counter{}))
+	xtesting.Equal(t, status, LockUnavailable)
+	xtesting.NotNil(t, err)
+}
+
+func TestLockClause(t *testing.T) {
+	for _, tc := range []struct {
+		giveDialect  string
+		giveStrength LockStrength
+		giveOptions  []LockOption
+		want         string
+	}{
+		{"mysql", LockForUpdate, nil, "FOR UPDATE"},
+		{"mysql", LockForShare, []LockOption{WithLockNoWait(true)}, "FOR SHARE NOWAIT"},
+		{"postgres", LockForUpdate, []LockOption{WithLockSkipLocked(true)}, "FOR UPDATE SKIP LOCKED"},
+		{"postgres", LockForShare, []LockOption{WithLockNoWait(true), WithLockSkipLocked(true)}, "FOR SHARE SKIP LOCKED"},
+		{"postgres", LockForUpdate, []LockOption{WithLockNoWait(true), WithLockNoWait(false)}, "FOR UPDATE"},
+		{"sqlite3", LockForUpdate, []LockOption{WithLockNoWait(true)}, ""},
+	} {
+		opt := &lockOptions{}
+		for _, op := range tc.giveOptions {
+			op(opt)
+		}
+		xtesting.Equal(t, lockClause(tc.giveDialect, tc.giveStrength, opt), tc.want)
+	}
+
+	xtesting.Equal(t, SQLiteImmediateDSN("test.sql"), "test.sql?_txlock=immediate")
+	xtesting.Equal(t, SQLiteImmediateDSN("file:test.sql?cache=shared"), "file:test.sql?cache=shared&_txlock=immediate")
+	xtesting.False(t, IsMySQLLockError(errors.New("test")))
+	xtesting.True(t, IsMySQLLockError(&mysql.MySQLError{Number: MySQLLockNoWaitErrno}))
+	xtesting.True(t, IsPostgreSQLLockError(&pq.Error{Code: PostgreSQLLockNotAvailableErrno}))
+	xtesting.False(t, IsPostgreSQLLockError(&pq.Error{Code: PostgreSQLUniqueViolationErrno}))
+}
+
+func testAdvisoryLock(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	db.DropTableIfExists(DefaultAdvisoryLockTable)
+	ctx := context.Background()
+
+	// try lock
+	lock1 := NewAdvisoryLock(db, "test:lock")
+	lock2 := NewAdvisoryLock(db, "test:lock", WithAdvisoryLockInterval(20*time.Millisecond))
+	xtesting.Equal(t, lock1.Name(), "test:lock")
+	ok, err := lock1.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.True(t, ok)
+	_, err = lock1.TryLock(ctx)
+	xtesting.Equal(t, err, ErrAdvisoryLockHeld)
+	ok, err = lock2.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.False(t, ok)
+	another := NewAdvisoryLock(db, "test:another")
+	ok, err = another.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.True(t, ok)
+	xtesting.Nil(t, another.Unlock(ctx))
+
+	// timeout and context
+	start := time.Now()
+	ok, err = lock2.LockTimeout(ctx, time.Second)
+	xtesting.Nil(t, err)
+	xtesting.False(t, ok)
+	xtesting.True(t, time.Since(start) >= time.Second)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	err = lock2.Lock(timeoutCtx)
+	cancel()
+	xtesting.NotNil(t, err)
+
+	// blocking lock and unlock
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- lock2.Lock(ctx)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	xtesting.Equal(t, len(acquired), 0)
+	xtesting.Nil(t, lock1.Unlock(ctx))
+	xtesting.Nil(t, <-acquired)
+	ok, err = lock1.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.False(t, ok)
+	xtesting.Nil(t, lock2.Unlock(ctx))
+	xtesting.Equal(t, lock2.Unlock(ctx), ErrAdvisoryLockNotHeld)
+	ok, err = lock1.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.True(t, ok)
+	xtesting.Nil(t, lock1.Unlock(ctx))
+
+	// unlock with cancelled context
+	ok, err = lock1.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.True(t, ok)
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	xtesting.Nil(t, lock1.Unlock(cancelledCtx))
+	ok, err = lock2.TryLock(ctx)
+	xtesting.Nil(t, err)
+	xtesting.True(t, ok)
+	xtesting.Nil(t, lock2.Unlock(ctx))
+}
+
+func testOutbox(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&OutboxEvent{}, &Counter{})
+	if db.AutoMigrate(&OutboxEvent{}, &Counter{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	count := func(status OutboxStatus) int {
+		cnt := 0
+		xtesting.Nil(t, db.Model(&OutboxEvent{}).Where("status = ?", status).Count(&cnt).Error)
+		return cnt
+	}
+	get := func(key string) *OutboxEvent {
+		event := &OutboxEvent{}
+		xtesting.Nil(t, db.Where(db.Dialect().Quote("key")+" = ?", key).First(event).Error)
+		return event
+	}
+
+	// enqueue
+	xtesting.NotNil(t, db.Transaction(func(tx *gorm.DB) error {
+		_ = tx.Create(&Counter{Name: "counter1"})
+		_, err := EnqueueOutbox(tx, "counter.created", "rollback", "{}")
+		xtesting.Nil(t, err)
+		return errors.New("rollback")
+	}))
+	xtesting.Equal(t, count(OutboxPending), 0)
+	xtesting.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		counter := &Counter{Name: "counter1"}
+		xtesting.Nil(t, tx.Create(counter).Error)
+		event, err := EnqueueOutbox(tx, "counter.created", "ok1", counter)
+		xtesting.Nil(t, err)
+		xtesting.True(t, event.Id > 0)
+		xtesting.Equal(t, event.Status, OutboxPending)
+		if _, err = EnqueueOutbox(tx, "counter.created", "fail", []byte("payload")); err != nil {
+			return err
+		}
+		_, err = EnqueueOutbox(tx, "counter.created", "ok2", "payload")
+		return err
+	}))
+	xtesting.Equal(t, count(OutboxPending), 3)
+	xtesting.True(t, strings.Contains(get("ok1").Payload, `"Name":"counter1"`))
+	xtesting.Equal(t, get("fail").Payload, "payload")
+	_, err = EnqueueOutbox(db, "counter.created", "invalid", make(chan int))
+	xtesting.NotNil(t, err)
+
+	// dispatch and retry
+	published := make([]string, 0)
+	publisher := func(ctx context.Context, event *OutboxEvent) error {
+		published = append(published, event.Key)
+		if event.Key == "fail" {
+			return errors.New("publish failed")
+		}
+		return nil
+	}
+	dispatcher := NewOutboxDispatcher(db, publisher, WithOutboxMaxAttempts(2), WithOutboxBackoff(func(int) time.Duration { return 0 }))
+	n, err := dispatcher.DispatchOnce(context.Background())
+	xtesting.Equal(t, n, 3)
+	xtesting.Equal(t, err.Error(), "publish failed")
+	xtesting.Equal(t, published, []string{"ok1", "fail", "ok2"})
+	xtesting.Equal(t, count(OutboxDelivered), 2)
+	xtesting.NotNil(t, get("ok1").DeliveredAt)
+	failed := get("fail")
+	xtesting.Equal(t, failed.Status, OutboxPending)
+	xtesting.Equal(t, failed.Attempts, 1)
+	xtesting.Equal(t, failed.LastError, "publish failed")
+	n, _ = dispatcher.DispatchOnce(context.Background())
+	xtesting.Equal(t, n, 1)
+	xtesting.Equal(t, get("fail").Status, OutboxFailed)
+	xtesting.Equal(t, get("fail").Attempts, 2)
+	n, err = dispatcher.DispatchOnce(context.Background())
+	xtesting.Equal(t, n, 0)
+	xtesting.Nil(t, err)
+
+	// backoff and claim timeout
+	_, err = EnqueueOutbox(db, "counter.updated", "fail", "payload")
+	xtesting.Nil(t, err)
+	dispatcher = NewOutboxDispatcher(db, publisher, WithOutboxBackoff(func(int) time.Duration { return time.Hour }))
+	n, _ = dispatcher.DispatchOnce(context.Background())
+	xtesting.Equal(t, n, 1)
+	n, _ = dispatcher.DispatchOnce(context.Background())
+	xtesting.Equal(t, n, 0) // wait for backoff
+	_, err = EnqueueOutbox(db, "counter.updated", "ok3", "payload")
+	xtesting.Nil(t, err)
+	claimed, err := dispatcher.claim()
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(claimed), 1)
+	n, _ = dispatcher.DispatchOnce(context.Background())
+	xtesting.Equal(t, n, 0) // claimed by others
+
+	// graceful stop
+	xtesting.Nil(t, db.Model(&OutboxEvent{}).Where("status = ?", OutboxPending).Update("status", OutboxDelivered).Error)
+	for i := 1; i <= 3; i++ {
+		_, err = EnqueueOutbox(db, "counter.deleted", fmt.Sprintf("stop%d", i), "payload")
+		xtesting.Nil(t, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatcher = NewOutboxDispatcher(db, func(ctx context.Context, event *OutboxEvent) error {
+		cancel()
+		return nil
+	}, WithOutboxErrorHandler(func(err error) {
+		t.Error("unexpected error:", err)
+	}))
+	stopped := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("dispatcher is not stopped")
+	}
+	xtesting.Equal(t, get("stop1").Status, OutboxDelivered)
+	xtesting.Equal(t, get("stop2").Status, OutboxPending)
+	xtesting.Equal(t, get("stop2").Attempts, 0)
+	xtesting.Equal(t, count(OutboxPending), 2)
+
+	xtesting.Equal(t, DefaultOutboxBackoff(0), time.Second)
+	xtesting.Equal(t, DefaultOutboxBackoff(3), 4*time.Second)
+	xtesting.Equal(t, DefaultOutboxBackoff(13), time.Hour)
+	xtesting.Equal(t, DefaultOutboxBackoff(100), time.Hour)
+}
+
+func testJobQueue(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	db.DropTableIfExists(&QueueJob{})
+	if db.AutoMigrate(&QueueJob{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	noBackoff := WithJobQueueBackoff(func(int) time.Duration { return 0 })
+
+	// enqueue
+	queue := NewJobQueue(db, "test", WithJobQueueMaxAttempts(2), noBackoff)
+	xtesting.Equal(t, queue.Name(), "test")
+	jobA, err := queue.Enqueue("a")
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, jobA.MaxAttempts, 2)
+	_, err = queue.Enqueue([]byte("b"), WithJobPriority(10), WithJobMaxAttempts(3))
+	xtesting.Nil(t, err)
+	_, err = queue.Enqueue("c", WithJobDelay(time.Hour))
+	xtesting.Nil(t, err)
+	_, err = queue.Enqueue(map[string]string{"d": "d"}, WithJobRunAt(time.Now().Add(-time.Second)))
+	xtesting.Nil(t, err)
+	_, err = NewJobQueue(db, "another").Enqueue("e")
+	xtesting.Nil(t, err)
+	_, err = queue.Enqueue(make(chan int))
+	xtesting.NotNil(t, err)
+	stats, err := queue.Stats()
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, *stats, JobQueueStats{Ready: 3, Delayed: 1})
+
+	// dequeue, ack and nack
+	jobs, err := queue.Dequeue(2)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(jobs), 2)
+	xtesting.Equal(t, jobs[0].Payload, "b") // priority
+	xtesting.Equal(t, jobs[1].Payload, `{"d":"d"}`)
+	xtesting.Equal(t, jobs[0].State, JobRunning)
+	xtesting.Equal(t, jobs[0].Attempts, 1)
+	xtesting.NotNil(t, jobs[0].LeasedUntil)
+	xtesting.Nil(t, queue.Ack(jobs[0]))
+	xtesting.Equal(t, jobs[0].State, JobSucceeded)
+	xtesting.Equal(t, queue.Ack(jobs[0]), ErrJobLeaseLost)
+	xtesting.Nil(t, queue.Ack(jobs[1]))
+	jobs, err = queue.Dequeue(10)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(jobs), 1)
+	xtesting.Equal(t, jobs[0].Id, jobA.Id)
+	xtesting.Nil(t, queue.Nack(jobs[0], errors.New("failed")))
+	xtesting.Equal(t, jobs[0].State, JobPending)
+	xtesting.Equal(t, jobs[0].LastError, "failed")
+	jobs, err = queue.Dequeue(10)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(jobs), 1)
+	xtesting.Equal(t, jobs[0].Attempts, 2)
+	xtesting.Nil(t, queue.Nack(jobs[0], errors.New("failed again")))
+	xtesting.Equal(t, jobs[0].State, JobDead)
+	jobs, err = queue.Dequeue(10)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(jobs), 0)
+	stats, err = queue.Stats()
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, *stats, JobQueueStats{Delayed: 1, Succeeded: 2, Dead: 1})
+	xtesting.Equal(t, stats.String(), "ready: 0, delayed: 1, running: 0, succeeded: 2, dead: 1")
+
+	// reap expired leases
+	queue = NewJobQueue(db, "reap", WithJobQueueVisibilityTimeout(100*time.Millisecond))
+	_, err = queue.Enqueue("f")
+	xtesting.Nil(t, err)
+	_, err = queue.Enqueue("g", WithJobMaxAttempts(1))
+	xtesting.Nil(t, err)
+	jobs, err = queue.Dequeue(10)
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, len(jobs), 2)
+	reaped, err := queue.Reap()
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, reaped, int64(0))
+	time.Sleep(200 * time.Millisecond)
+	reaped, err = queue.Reap()
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, reaped, int64(2))
+	xtesting.Equal(t, queue.Ack(jobs[0]), ErrJobLeaseLost)
+	xtesting.Equal(t, queue.Nack(jobs[1], nil), ErrJobLeaseLost)
+	stats, err = queue.Stats()
+	xtesting.Nil(t, err)
+	xtesting.Equal(t, *stats, JobQueueStats{Ready: 1, Dead: 1})
+
+	// work with concurrency limit
+	queue = NewJobQueue(db, "work", WithJobQueueMaxAttempts(1), WithJobQueuePollInterval(50*time.Millisecond), WithJobQueueErrorHandler(func(err error) {
+		if err.Error() != "failed" {
+			t.Error("unexpected error:", err)
+		}
+	}))
+	for i := 1; i <= 10; i++ {
+		_, err = queue.Enqueue(strconv.Itoa(i))
+		xtesting.Nil(t, err)
+	}
+	mu, running, maxRunning := sync.Mutex{}, 0, 0
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		queue.Work(ctx, 3, func(ctx context.Context, job *QueueJob) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			if job.Payload == "5" {
+				return errors.New("failed")
+			}
+			return nil
+		})
+		close(stopped)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if stats, err = queue.Stats(); err == nil && stats.Succeeded+stats.Dead == 10 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	<-stopped
+	xtesting.Equal(t, *stats, JobQueueStats{Succeeded: 9, Dead: 1})
+	xtesting.Equal(t, maxRunning, 3)
+}
+
+func testQueryCache(t *testing.T, giveDialect, giveParam string) {
+	db, err := gorm.Open(giveDialect, giveParam)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	db.LogMode(true)
+	db.SetLogger(NewLoggerLogger(log.New(os.Stderr, "", log.LstdFlags), WithSlowThreshold(time.Second), WithOnlyErrors(true)))
+	HookDeletedAt(db, DefaultDeletedAtTimestamp)
+	db.DropTableIfExists(&Counter{})
+	if db.AutoMigrate(&Counter{}).Error != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: redisAddr, Password: redisPasswd})
+	defer client.Close()
+	prefix := fmt.Sprintf("xgorm:test:%d:", time.Now().UnixNano())
+	defer func() {
+		keys, _ := client.Keys(context.Background(), prefix+"*").Result()
+		if len(keys) > 0 {
+			client.Del(context.Background(), keys...)
+		}
+	}()
+	var cacheErr error
+	cache := NewQueryCache(client, WithQueryCachePrefix(prefix), WithQueryCacheErrorHandler(func(err error) { cacheErr = err }))
+	HookQueryCache(db, cache)
+	for i := 1; i <= 3; i++ {
+		xtesting.Nil(t, db.Create(&Counter{Name: "counter" + strconv.Itoa(i), Count: i}).Error)
+	}
+	get := func(db *gorm.DB, id int) *Counter {
+		counter := &Counter{}
+		xtesting.Nil(t, db.Where("id = ?", id).First(counter).Error)
+		return counter
+	}
+	exec := func(query string, args ...interface{}) {
+		xtesting.Nil(t, db.Exec(query, args...).Error) // not tracked by callbacks
+	}
+
+	// cached
+	xtesting.Equal(t, get(db, 1).Count, 1)
+	exec("UPDATE counters SET count = 10 WHERE id = 1")
+	counter := get(db, 1)
+	xtesting.Equal(t, counter.Count, 1)
+	xtesting.Equal(t, counter.Name, "counter1")
+	xtesting.False(t, counter.CreatedAt.IsZero())
+	xtesting.Equal(t, get(db, 2).Count, 2) // different args
+	xtesting.Equal(t, get(SkipCache(db), 1).Count, 10)
+	xtesting.Nil(t, db.Transaction(func(tx *gorm.DB) error {
+		xtesting.Equal(t, get(tx, 1).Count, 10)
+		return nil
+	}))
+	xtesting.Nil(t, cache.Invalidate(context.Background(), "counters"))
+	xtesting.Equal(t, get(db, 1).Count, 10)
+
+	// slice, limit and not found
+	counters := make([]*Counter, 0)
+	xtesting.Nil(t, db.Order("id").Limit(2).Find(&counters).Error)
+	xtesting.Equal(t, len(counters), 2)
+	counters2 := make([]Counter, 0)
+	rdb := db.Order("id").Limit(3).Find(&counters2)
+	xtesting.Nil(t, rdb.Error)
+	xtesting.Equal(t, rdb.RowsAffected, int64(3))
+	xtesting.Equal(t, counters2[2].Name, "counter3")
+	xtesting.True(t, db.Where("name = ?", "counter4").First(&Counter{}).RecordNotFound())
+	exec("UPDATE counters SET name = 'counter4' WHERE id = 3")
+	xtesting.True(t, db.Where("name = ?", "counter4").First(&Counter{}).RecordNotFound())
+	xtesting.False(t, SkipCache(db).Where("name = ?", "counter4").First(&Counter{}).RecordNotFound())
+	exec("UPDATE counters SET name = 'counter3' WHERE id = 3")
+	names := make([]string, 0)
+	xtesting.Nil(t, db.Model(&Counter{}).Order("id").Pluck("name", &names).Error)
+	xtesting.Equal(t, names, []string{"counter1", "counter2", "counter3"})
+
+	// invalidated by callbacks
+	xtesting.Nil(t, db.Model(&Counter{}).Where("id = ?", 1).Update("count", 20).Error)
+	xtesting.Equal(t, get(db, 1).Count, 20)
+	xtesting.Nil(t, db.Create(&Counter{Name: "counter4", Count: 4}).Error)
+	xtesting.False(t, db.Where("name = ?", "counter4").First(&Counter{}).RecordNotFound())
+	xtesting.Nil(t, db.Where("id = ?", 2).Delete(&Counter{}).Error)
+	xtesting.True(t, db.Where("id = ?", 2).First(&Counter{}).RecordNotFound())
+	cnt := 0
+	xtesting.Nil(t, db.Model(&Counter{}).Count(&cnt).Error)
+	xtesting.Equal(t, cnt, 3)
+
+	// invalidated after committing
+	xtesting.Nil(t, cache.Transaction(db, func(tx *gorm.DB) error {
+		xtesting.Nil(t, tx.Model(&Counter{}).Where("id = ?", 1).Update("count", 21).Error)
+		xtesting.Equal(t, get(db, 1).Count, 20) // read and cached by others before committing
+		return cache.Transaction(tx, func(tx *gorm.DB) error {
+			return tx.Model(&Counter{}).Where("id = ?", 3).Update("count", 31).Error
+		})
+	}))
+	xtesting.Equal(t, get(db, 1).Count, 21)
+	xtesting.Equal(t, get(db, 3).Count, 31)
+	xtesting.NotNil(t, cache.Transaction(db, func(tx *gorm.DB) error {
+		xtesting.Nil(t, tx.Model(&Counter{}).Where("id = ?", 1).Update("count", 22).Error)
+		return errors.New("test")
+	}))
+	xtesting.Equal(t, get(db, 1).Count, 21)
+
+	// evicted version
+	xtesting.Nil(t, client.Del(context.Background(), cache.versionKey("counters")).Err())
+	xtesting.Equal(t, get(db, 1).Count, 21)
+	exec("UPDATE counters SET count = 23 WHERE id = 1")
+	xtesting.Equal(t, get(db, 1).Count, 21)
+	xtesting.Nil(t, client.Del(context.Background(), cache.versionKey("counters")).Err())
+	xtesting.Equal(t, get(db, 1).Count, 23)
+
+	// ttl
+	xtesting.Equal(t, get(WithCacheTTL(db, 50*time.Millisecond), 3).Count, 31)
+	exec("UPDATE counters SET count = 30 WHERE id = 3")
+	xtesting.Equal(t, get(db, 3).Count, 31)
+	time.Sleep(100 * time.Millisecond)
+	xtesting.Equal(t, get(db, 3).Count, 30)
+	xtesting.Nil(t, cacheErr)
+
+	// singleflight
+	calls := int32(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := cache.do("key", func() (*bufferedRows, error) {
+				atomic.AddInt32(&calls, 1)
+				time.Sleep(100 * time.Millisecond)
+				return &bufferedRows{columns: []string{"id"}}, nil
+			})
+			xtesting.Nil(t, err)
+			xtesting.Equal(t, rows.columns, []string{"id"})
+		}()
+	}
+	wg.Wait()
+	xtesting.Equal(t, calls, int32(1))
+}
+
+func TestNormalizeColumn(t *testing.T) {
+	for _, tc := range []struct {
+		give string
+		want string
+	}{
+		{"int AUTO_INCREMENT", "int"},
+		{"int(11)", "int"},
+		{"INTEGER PRIMARY KEY AUTOINCREMENT", "int"},
+		{"serial", "int"},
+		{"bigint(20) unsigned", "bigint unsigned"},
+		{"tinyint(1)", "boolean"},
+		{"bool", "boolean"},
+		{"character varying(255)", "varchar(255)"},
+		{"VARCHAR(255) NOT NULL UNIQUE", "varchar(255)"},
+		{"DATETIME NULL", "datetime"},
+		{"timestamp with time zone", "timestamptz"},
+		{"double precision", "double"},
+		{"integers", "integers"},
+	} {
+		xtesting.Equal(t, normalizeColumnType(tc.give), tc.want)
+	}
+
+	for _, tc := range []struct {
+		give string
+		want string
+	}{
+		{"", ""},
+		{"NULL", ""},
+		{"18", "18"},
+		{"'1970-01-01 00:00:01'", "1970-01-01 00:00:01"},
+		{"'1970-01-01 00:00:01'::timestamp with time zone", "1970-01-01 00:00:01"},
+		{"'a''b'::character varying(255)", "a'b"},
+		{"nextval('users_uid_seq'::regclass)", ""},
+		{"(0)", "0"},
+	} {
+		xtesting.Equal(t, normalizeColumnDefault(tc.give), tc.want)
+	}
 }
@@ -0,0 +1,78 @@
+package xgormv2
+
+import (
+	"errors"
+	"github.com/Aoi-hosizora/ahlib/xstatus"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// IsMySQL checks if the dialector of given gorm.DB is "mysql".
+func IsMySQL(db *gorm.DB) bool {
+	return db.Dialector.Name() == "mysql"
+}
+
+// IsSQLite checks if the dialector of given gorm.DB is "sqlite".
+func IsSQLite(db *gorm.DB) bool {
+	return db.Dialector.Name() == "sqlite"
+}
+
+// IsPostgreSQL checks if the dialector of given gorm.DB is "postgres".
+func IsPostgreSQL(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+// Reference from http://go-database-sql.org/errors.html.
+//
+// MySQL: https://github.com/VividCortex/mysqlerr/blob/master/mysqlerr.go and https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.htm,
+// SQLite: https://github.com/mattn/go-sqlite3/blob/master/error.go and http://www.sqlite.org/c3ref/c_abort_rollback.html,
+// PostgreSQL: https://github.com/lib/pq/blob/master/error.go and https://www.postgresql.org/docs/10/errcodes-appendix.html.
+const (
+	MySQLDuplicateEntryErrno       = 1062      // MySQLDuplicateEntryErrno is MySQL's ER_DUP_ENTRY errno.
+	SQLiteUniqueConstraintErrno    = 19 | 8<<8 // SQLiteUniqueConstraintErrno is SQLite's CONSTRAINT_UNIQUE extended errno.
+	PostgreSQLUniqueViolationErrno = "23505"   // PostgreSQLUniqueViolationErrno is PostgreSQL's unique_violation errno.
+)
+
+// IsMySQLDuplicateEntryError checks if err is MySQL's ER_DUP_ENTRY error.
+func IsMySQLDuplicateEntryError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == MySQLDuplicateEntryErrno
+}
+
+// IsPostgreSQLUniqueViolationError checks if err is PostgreSQL's unique_violation error, both lib/pq's error and pgx's error (which is
+// used by gorm.io/driver/postgres) are supported.
+func IsPostgreSQLUniqueViolationError(err error) bool {
+	switch postgresErr := err.(type) {
+	case *pq.Error:
+		return postgresErr.Code == PostgreSQLUniqueViolationErrno
+	case pq.Error:
+		return postgresErr.Code == PostgreSQLUniqueViolationErrno
+	case interface{ SQLState() string }: // *pgconn.PgError
+		return postgresErr.SQLState() == PostgreSQLUniqueViolationErrno
+	}
+	return false
+}
+
+// QueryErr checks gorm.DB query result, will only return xstatus.DbNotFound, xstatus.DbFailed and xstatus.DbSuccess. Note that only First,
+// Take and Last return gorm.ErrRecordNotFound in gorm v2, Find with empty result is regarded as xstatus.DbSuccess.
+func QueryErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
+	switch {
+	case errors.Is(rdb.Error, gorm.ErrRecordNotFound):
+		return xstatus.DbNotFound, nil // not found
+	case rdb.Error != nil:
+		return xstatus.DbFailed, rdb.Error // failed
+	}
+	return xstatus.DbSuccess, nil
+}
+
+// DeleteErr checks gorm.DB delete result, will only return xstatus.DbFailed, xstatus.DbNotFound and xstatus.DbSuccess.
+func DeleteErr(rdb *gorm.DB) (xstatus.DbStatus, error) {
+	switch {
+	case rdb.Error != nil:
+		return xstatus.DbFailed, rdb.Error // failed
+	case rdb.RowsAffected == 0:
+		return xstatus.DbNotFound, nil // not found
+	}
+	return xstatus.DbSuccess, nil
+}
@@ -0,0 +1,189 @@
+package xgormv2
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"time"
+)
+
+const (
+	// deletedAtFieldName represents the struct field name of "DeletedAt".
+	deletedAtFieldName = "DeletedAt"
+
+	// softDeleteEnabledClause represents the statement clause name used to mark the soft-delete condition has been added.
+	softDeleteEnabledClause = "xgormv2:soft_delete_enabled"
+
+	// DefaultDeletedAtTimestamp represents the default value of GormTime.DeletedAt.
+	DefaultDeletedAtTimestamp = "1970-01-01 00:00:01"
+)
+
+// GormTime represents a structure of CreatedAt, UpdatedAt, DeletedAt (defaults to "1970-01-01 00:00:01"), is a replacement of gorm.Model.
+// Note that DeletedAt is *time.Time rather than gorm.DeletedAt, which is used with SoftDeletePlugin.
+type GormTime struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time `gorm:"index; default:'1970-01-01 00:00:01'"`
+}
+
+// GormTime2 represents a structure of CreatedAt, UpdatedAt, which allow you to customize the DeletedAt field, is a replacement of gorm.Model.
+type GormTime2 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// SoftDeletePlugin represents a gorm.Plugin for soft-delete using the deletedAt timestamp, which has the same semantics as xgorm.HookDeletedAt,
+// that is, the rows whose "DeletedAt" field equals to the timestamp are regarded as not deleted, and deleting sets the field to current time.
+// Note that the "DeletedAt" field should not be gorm.DeletedAt, otherwise gorm's builtin soft-delete will also be applied.
+type SoftDeletePlugin struct {
+	deletedAtTimestamp string
+}
+
+var _ gorm.Plugin = &SoftDeletePlugin{}
+
+// NewSoftDeletePlugin creates a new SoftDeletePlugin with given deletedAt timestamp.
+// Example:
+// 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// 	err = db.Use(xgormv2.NewSoftDeletePlugin(xgormv2.DefaultDeletedAtTimestamp))
+func NewSoftDeletePlugin(deletedAtTimestamp string) *SoftDeletePlugin {
+	return &SoftDeletePlugin{deletedAtTimestamp: deletedAtTimestamp}
+}
+
+// Name implements gorm.Plugin.
+func (s *SoftDeletePlugin) Name() string {
+	return "xgormv2:soft_delete"
+}
+
+// Initialize implements gorm.Plugin, it registers the soft-delete callbacks (including query, row, update, delete) to gorm.DB.
+func (s *SoftDeletePlugin) Initialize(db *gorm.DB) error {
+	// query
+	err := db.Callback().Query().
+		Before("gorm:query").
+		Register("new_deleted_at_before_query_callback", s.queryCallback)
+	if err != nil {
+		return err
+	}
+
+	// row query
+	err = db.Callback().Row().
+		Before("gorm:row").
+		Register("new_deleted_at_before_row_query_callback", s.queryCallback)
+	if err != nil {
+		return err
+	}
+
+	// update
+	err = db.Callback().Update().
+		Before("gorm:update").
+		Register("new_deleted_at_before_update_callback", s.updateCallback)
+	if err != nil {
+		return err
+	}
+
+	// delete
+	return db.Callback().Delete().
+		Before("gorm:delete").
+		Register("new_deleted_at_before_delete_callback", s.deleteCallback)
+}
+
+// deletedAtField returns the "DeletedAt" field of given gorm.DB's statement, ok is false when the statement is unscoped, raw or the model
+// has no such field.
+func deletedAtField(db *gorm.DB) (*schema.Field, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Unscoped || stmt.Schema == nil || stmt.SQL.Len() != 0 {
+		return nil, false
+	}
+	field := stmt.Schema.LookUpField(deletedAtFieldName)
+	return field, field != nil
+}
+
+// addDeletedAtCondition adds `deleted_at = 'xxx'` condition to the statement, the existing conditions are grouped if there is any OR
+// condition, just like gorm.SoftDeleteQueryClause.
+func (s *SoftDeletePlugin) addDeletedAtCondition(stmt *gorm.Statement, field *schema.Field) {
+	if _, ok := stmt.Clauses[softDeleteEnabledClause]; ok {
+		return
+	}
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 1 {
+			for _, expr := range where.Exprs {
+				if orCond, ok := expr.(clause.OrConditions); ok && len(orCond.Exprs) == 1 {
+					where.Exprs = []clause.Expression{clause.And(where.Exprs...)}
+					c.Expression = where
+					stmt.Clauses["WHERE"] = c
+					break
+				}
+			}
+		}
+	}
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: s.deletedAtTimestamp},
+	}})
+	stmt.Clauses[softDeleteEnabledClause] = clause.Clause{}
+}
+
+// queryCallback is a callback before gorm:query, gorm:row used in SoftDeletePlugin.
+func (s *SoftDeletePlugin) queryCallback(db *gorm.DB) {
+	if field, ok := deletedAtField(db); ok {
+		s.addDeletedAtCondition(db.Statement, field)
+	}
+}
+
+// updateCallback is a callback before gorm:update used in SoftDeletePlugin, the condition is only added when the statement has conditions
+// (including the primary keys of model, which will be added by gorm:update) or global update is allowed, to keep gorm's ErrMissingWhereClause
+// checking.
+func (s *SoftDeletePlugin) updateCallback(db *gorm.DB) {
+	field, ok := deletedAtField(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	_, hasWhere := stmt.Clauses["WHERE"]
+	if !hasWhere && stmt.Model != nil {
+		_, queryValues := schema.GetIdentityFieldValuesMap(reflect.ValueOf(stmt.Model), stmt.Schema.PrimaryFields)
+		hasWhere = len(queryValues) > 0
+	}
+	if !hasWhere && stmt.ReflectValue.IsValid() {
+		_, queryValues := schema.GetIdentityFieldValuesMap(stmt.ReflectValue, stmt.Schema.PrimaryFields)
+		hasWhere = len(queryValues) > 0
+	}
+	if hasWhere || db.AllowGlobalUpdate {
+		s.addDeletedAtCondition(stmt, field)
+	}
+}
+
+// deleteCallback is a callback before gorm:delete used in SoftDeletePlugin, it builds `UPDATE ... SET deleted_at = ?` statement, which will
+// be executed by gorm:delete.
+//
+// Reference: https://github.com/go-gorm/gorm/blob/master/soft_delete.go.
+func (s *SoftDeletePlugin) deleteCallback(db *gorm.DB) {
+	field, ok := deletedAtField(db)
+	if !ok {
+		return
+	}
+
+	stmt := db.Statement
+	now := db.NowFunc()
+	stmt.AddClause(clause.Set{{Column: clause.Column{Name: field.DBName}, Value: now}})
+	stmt.SetColumn(field.DBName, now, true)
+	_, queryValues := schema.GetIdentityFieldValuesMap(stmt.ReflectValue, stmt.Schema.PrimaryFields)
+	column, values := schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+	if len(values) > 0 {
+		stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+	}
+	if stmt.ReflectValue.CanAddr() && stmt.Dest != stmt.Model && stmt.Model != nil {
+		_, queryValues = schema.GetIdentityFieldValuesMap(reflect.ValueOf(stmt.Model), stmt.Schema.PrimaryFields)
+		column, values = schema.ToQueryValues(stmt.Table, stmt.Schema.PrimaryFieldDBNames, queryValues)
+		if len(values) > 0 {
+			stmt.AddClause(clause.Where{Exprs: []clause.Expression{clause.IN{Column: column, Values: values}}})
+		}
+	}
+
+	if _, ok := stmt.Clauses["WHERE"]; !db.AllowGlobalUpdate && !ok {
+		_ = db.AddError(gorm.ErrMissingWhereClause)
+		return
+	}
+	s.addDeletedAtCondition(stmt, field)
+	stmt.AddClauseIfNotExists(clause.Update{})
+	stmt.Build("UPDATE", "SET", "WHERE")
+}
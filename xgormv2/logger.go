@@ -0,0 +1,305 @@
+package xgormv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// some variables used in callerSource.
+var (
+	_gormSrcRegexp  = regexp.MustCompile(`gorm.io/(gorm|driver/[^/@]+)(@.*)?/.*.go`)
+	_gormTestRegexp = regexp.MustCompile(`gorm.io/(gorm|driver/[^/@]+)(@.*)?/.*test.go`)
+	_xgormv2SrcDir  = func() string {
+		_, file, _, _ := runtime.Caller(0)
+		return filepath.Dir(file)
+	}()
+)
+
+// callerSource returns the caller source location like "file:line", which skips the gorm, gorm's drivers and xgormv2's source files, note that gorm's
+// utils.FileWithLineNum can not be used in the loggers, because it only skips gorm's source files.
+func callerSource() string {
+	for i := 2; i < 20; i++ {
+		_, file, line, ok := runtime.Caller(i)
+		if !ok {
+			break
+		}
+		if _gormSrcRegexp.MatchString(file) && !_gormTestRegexp.MatchString(file) {
+			continue
+		}
+		if filepath.Dir(file) == _xgormv2SrcDir && !strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return ""
+}
+
+// loggerOptions represents some options for logger, set by LoggerOption.
+type loggerOptions struct {
+	logLevel      logger.LogLevel
+	logInfo       bool
+	logOther      bool
+	sqlLevel      logrus.Level
+	slowThreshold time.Duration
+	onlyErrors    bool
+}
+
+// LoggerOption represents an option for logger, created by WithXXX functions.
+type LoggerOption func(*loggerOptions)
+
+// WithLogLevel returns a LoggerOption with gorm's logger.LogLevel, defaults to logger.Info, which can also be changed by gorm.DB's
+// Logger.LogMode. Note that logger.Silent hides all messages, logger.Error only logs errors, and logger.Warn logs errors, warnings and
+// slow sql.
+func WithLogLevel(level logger.LogLevel) LoggerOption {
+	return func(o *loggerOptions) {
+		o.logLevel = level
+	}
+}
+
+// WithLogInfo returns a LoggerOption with logInfo switcher to do log for [info], defaults to true.
+func WithLogInfo(logInfo bool) LoggerOption {
+	return func(o *loggerOptions) {
+		o.logInfo = logInfo
+	}
+}
+
+// WithLogOther returns a LoggerOption with logOther switcher to do log for other type, such as [warn] and [error], defaults to true.
+func WithLogOther(logOther bool) LoggerOption {
+	return func(o *loggerOptions) {
+		o.logOther = logOther
+	}
+}
+
+// WithSqlLevel returns a LoggerOption with the logrus.Level for "SQL" message, defaults to logrus.InfoLevel, only used in LogrusLogger.
+func WithSqlLevel(level logrus.Level) LoggerOption {
+	return func(o *loggerOptions) {
+		o.sqlLevel = level
+	}
+}
+
+// WithSlowThreshold returns a LoggerOption with slow sql threshold, defaults to 0 which means disable slow sql checking. Note that "SQL"
+// message whose duration is over this threshold will be logged as logrus.WarnLevel with a `slow: true` field.
+func WithSlowThreshold(threshold time.Duration) LoggerOption {
+	return func(o *loggerOptions) {
+		o.slowThreshold = threshold
+	}
+}
+
+// WithOnlyErrors returns a LoggerOption with onlyErrors switcher to only do log for errors and slow sql, defaults to false. Note that the
+// error of a failed statement is logged by a separate [log] message, which will be logged as logrus.ErrorLevel with an `error` field.
+func WithOnlyErrors(onlyErrors bool) LoggerOption {
+	return func(o *loggerOptions) {
+		o.onlyErrors = onlyErrors
+	}
+}
+
+// newLoggerOptions creates loggerOptions with default values and given LoggerOption-s.
+func newLoggerOptions(options []LoggerOption) *loggerOptions {
+	opt := &loggerOptions{
+		logLevel: logger.Info,
+		logInfo:  true,
+		logOther: true,
+		sqlLevel: logrus.InfoLevel,
+	}
+	for _, op := range options {
+		if op != nil {
+			op(opt)
+		}
+	}
+	return opt
+}
+
+// withLevel returns a copied loggerOptions with given logger.LogLevel, used in LogMode.
+func (o *loggerOptions) withLevel(level logger.LogLevel) *loggerOptions {
+	copied := *o
+	copied.logLevel = level
+	return &copied
+}
+
+// LogrusLogger represents a gorm's logger.Interface, used to log "SQL", [info], [warn] and [error] message to logrus.Logger.
+type LogrusLogger struct {
+	logger  *logrus.Logger
+	options *loggerOptions
+}
+
+var _ logger.Interface = &LogrusLogger{}
+
+// NewLogrusLogger creates a new LogrusLogger using given logrus.Logger and LoggerOption-s.
+// Example:
+// 	l := logrus.New()
+// 	l.SetFormatter(&logrus.TextFormatter{})
+// 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: xgormv2.NewLogrusLogger(l)})
+func NewLogrusLogger(logger *logrus.Logger, options ...LoggerOption) *LogrusLogger {
+	return &LogrusLogger{logger: logger, options: newLoggerOptions(options)}
+}
+
+// LoggerLogger represents a gorm's logger.Interface, used to log "SQL", [info], [warn] and [error] message to logrus.StdLogger.
+type LoggerLogger struct {
+	logger  logrus.StdLogger
+	options *loggerOptions
+}
+
+var _ logger.Interface = &LoggerLogger{}
+
+// NewLoggerLogger creates a new LoggerLogger using given logrus.StdLogger and LoggerOption-s.
+// Example:
+// 	l := log.New(os.Stderr, "", log.LstdFlags)
+// 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: xgormv2.NewLoggerLogger(l)})
+func NewLoggerLogger(logger logrus.StdLogger, options ...LoggerOption) *LoggerLogger {
+	return &LoggerLogger{logger: logger, options: newLoggerOptions(options)}
+}
+
+// LogMode implements logger.Interface, returns a new LogrusLogger with given logger.LogLevel.
+func (g *LogrusLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &LogrusLogger{logger: g.logger, options: g.options.withLevel(level)}
+}
+
+// Info implements logger.Interface, logs [info] message to logrus.Logger.
+func (g *LogrusLogger) Info(_ context.Context, msg string, data ...interface{}) {
+	g.print(formatMessage("info", fmt.Sprintf(msg, data...), g.options))
+}
+
+// Warn implements logger.Interface, logs [warn] message to logrus.Logger.
+func (g *LogrusLogger) Warn(_ context.Context, msg string, data ...interface{}) {
+	g.print(formatMessage("warn", fmt.Sprintf(msg, data...), g.options))
+}
+
+// Error implements logger.Interface, logs [error] message to logrus.Logger.
+func (g *LogrusLogger) Error(_ context.Context, msg string, data ...interface{}) {
+	g.print(formatMessage("error", fmt.Sprintf(msg, data...), g.options))
+}
+
+// Trace implements logger.Interface, logs "SQL" message and [log] message for error to logrus.Logger.
+func (g *LogrusLogger) Trace(_ context.Context, begin time.Time, fc func() (string, int64), err error) {
+	source := callerSource()
+	g.print(formatError(err, source, g.options))
+	g.print(formatSql(begin, fc, source, g.options))
+}
+
+// print logs to logrus.Logger if the message is not empty.
+func (g *LogrusLogger) print(msg string, fields logrus.Fields, level logrus.Level) {
+	if msg != "" && len(fields) != 0 {
+		g.logger.WithFields(fields).Log(level, msg)
+	}
+}
+
+// LogMode implements logger.Interface, returns a new LoggerLogger with given logger.LogLevel.
+func (g *LoggerLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &LoggerLogger{logger: g.logger, options: g.options.withLevel(level)}
+}
+
+// Info implements logger.Interface, logs [info] message to logrus.StdLogger.
+func (g *LoggerLogger) Info(_ context.Context, msg string, data ...interface{}) {
+	g.print(formatMessage("info", fmt.Sprintf(msg, data...), g.options))
+}
+
+// Warn implements logger.Interface, logs [warn] message to logrus.StdLogger.
+func (g *LoggerLogger) Warn(_ context.Context, msg string, data ...interface{}) {
+	g.print(formatMessage("warn", fmt.Sprintf(msg, data...), g.options))
+}
+
+// Error implements logger.Interface, logs [error] message to logrus.StdLogger.
+func (g *LoggerLogger) Error(_ context.Context, msg string, data ...interface{}) {
+	g.print(formatMessage("error", fmt.Sprintf(msg, data...), g.options))
+}
+
+// Trace implements logger.Interface, logs "SQL" message and [log] message for error to logrus.StdLogger.
+func (g *LoggerLogger) Trace(_ context.Context, begin time.Time, fc func() (string, int64), err error) {
+	source := callerSource()
+	g.print(formatError(err, source, g.options))
+	g.print(formatSql(begin, fc, source, g.options))
+}
+
+// print logs to logrus.StdLogger if the message is not empty.
+func (g *LoggerLogger) print(msg string, _ logrus.Fields, _ logrus.Level) {
+	if msg != "" {
+		g.logger.Print(msg)
+	}
+}
+
+// formatMessage formats [info], [warn] and [error] message to logger string, logrus.Fields and logrus.Level, in the same format as xgorm,
+// that is, [info] message is logged without type tag.
+// Logs like:
+// 	[Gorm] replacing callback `gorm:query` from F:/Projects/ahlib-db/xgormv2/xgormv2_test.go:36
+// 	[Gorm] [error] failed to initialize database, got error dial tcp 127.0.0.1:3306: connect: connection refused
+func formatMessage(typ string, s string, options *loggerOptions) (string, logrus.Fields, logrus.Level) {
+	s = strings.TrimSpace(s)
+	var fields logrus.Fields
+	var level logrus.Level
+	switch typ {
+	case "info":
+		if options.logLevel < logger.Info || !options.logInfo || options.onlyErrors {
+			return "", nil, 0
+		}
+		return fmt.Sprintf("[Gorm] %s", s), logrus.Fields{"module": "gorm", "type": typ, "info": s}, logrus.InfoLevel // no type tag
+	case "warn":
+		if options.logLevel < logger.Warn || !options.logOther || options.onlyErrors {
+			return "", nil, 0
+		}
+		fields = logrus.Fields{"module": "gorm", "type": typ, "message": s}
+		level = logrus.WarnLevel
+	default:
+		if options.logLevel < logger.Error || !options.logOther {
+			return "", nil, 0
+		}
+		fields = logrus.Fields{"module": "gorm", "type": typ, "message": s}
+		level = logrus.ErrorLevel
+	}
+	return fmt.Sprintf("[Gorm] [%s] %s", typ, s), fields, level
+}
+
+// formatError formats the error of a failed statement to logger string, logrus.Fields and logrus.Level, gorm.ErrRecordNotFound is ignored.
+// Logs like:
+// 	[Gorm] [log] Error 1062: Duplicate entry '1' for key 'PRIMARY'
+func formatError(err error, source string, options *loggerOptions) (string, logrus.Fields, logrus.Level) {
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) || options.logLevel < logger.Error || !options.logOther {
+		return "", nil, 0
+	}
+	fields := logrus.Fields{
+		"module":  "gorm",
+		"type":    "log",
+		"message": err.Error(),
+		"error":   err,
+		"source":  source,
+	}
+	return fmt.Sprintf("[Gorm] [log] %v", err), fields, logrus.ErrorLevel
+}
+
+// formatSql formats "SQL" message to logger string, logrus.Fields and logrus.Level, in the same format as xgorm. Note that the sql string
+// has been rendered with variables by gorm's Dialector.
+// Logs like:
+// 	[Gorm]       1 |     1.9957ms | SELECT * FROM `tbl_test` ORDER BY `tbl_test`.`id` LIMIT 1 | F:/Projects/ahlib-db/xgormv2/xgormv2_test.go:48
+// 	      |-------| |------------| |-------------------------------------------------------------| |---------------------------------------------|
+// 	          7           12                                     ...                                                       ...
+func formatSql(begin time.Time, fc func() (string, int64), source string, options *loggerOptions) (string, logrus.Fields, logrus.Level) {
+	duration := time.Since(begin)
+	slow := options.slowThreshold > 0 && duration >= options.slowThreshold
+	if options.logLevel <= logger.Silent || (options.logLevel < logger.Info || options.onlyErrors) && !(slow && options.logLevel >= logger.Warn) {
+		return "", nil, 0
+	}
+
+	sql, rows := fc()
+	level := options.sqlLevel
+	fields := logrus.Fields{
+		"module":   "gorm",
+		"type":     "sql",
+		"sql":      sql,
+		"rows":     rows,
+		"duration": duration,
+		"source":   source,
+	}
+	if slow {
+		level = logrus.WarnLevel
+		fields["slow"] = true
+	}
+	return fmt.Sprintf("[Gorm] %7d | %12s | %s | %s", rows, duration, sql, source), fields, level
+}
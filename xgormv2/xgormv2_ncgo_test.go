@@ -0,0 +1,48 @@
+// +build !cgo
+
+package xgormv2
+
+import (
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestHook(t *testing.T) {
+	for _, tc := range []struct {
+		giveName      string
+		giveDialector gorm.Dialector
+	}{
+		{"mysql", mysql.Open(mysqlDsl)},
+	} {
+		t.Run(tc.giveName, func(t *testing.T) {
+			testHook(t, tc.giveDialector)
+		})
+	}
+}
+
+func TestHelper(t *testing.T) {
+	for _, tc := range []struct {
+		giveName      string
+		giveDialector gorm.Dialector
+	}{
+		{"mysql", mysql.Open(mysqlDsl)},
+	} {
+		t.Run(tc.giveName, func(t *testing.T) {
+			testHelper(t, tc.giveDialector)
+		})
+	}
+}
+
+func TestLogger(t *testing.T) {
+	for _, tc := range []struct {
+		giveName      string
+		giveDialector gorm.Dialector
+	}{
+		{"mysql", mysql.Open(mysqlDsl)},
+	} {
+		t.Run(tc.giveName, func(t *testing.T) {
+			testLogger(t, tc.giveDialector)
+		})
+	}
+}
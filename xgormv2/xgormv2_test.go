@@ -0,0 +1,291 @@
+package xgormv2
+
+import (
+	"errors"
+	"github.com/Aoi-hosizora/ahlib/xstatus"
+	"github.com/Aoi-hosizora/ahlib/xtesting"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	mysqlDsl   = "root:123@tcp(localhost:3306)/db_test?charset=utf8&parseTime=True&loc=Local"
+	sqliteFile = "test.sql"
+)
+
+type User struct {
+	Uid  int    `gorm:"primaryKey; autoIncrement"`
+	Name string `gorm:"not null; size:255; uniqueIndex:uk_name"`
+	GormTime
+}
+
+func testHook(t *testing.T, giveDialector gorm.Dialector) {
+	l := logrus.New()
+	l.SetFormatter(&logrus.TextFormatter{ForceColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339})
+	check := func(db *gorm.DB, write bool) error {
+		if db.Error != nil {
+			return db.Error
+		}
+		if write && db.RowsAffected == 0 {
+			return errors.New("rows affected is zero")
+		}
+		return nil
+	}
+
+	db, err := gorm.Open(giveDialector, &gorm.Config{Logger: NewLogrusLogger(l)})
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	if err = db.Use(NewSoftDeletePlugin(DefaultDeletedAtTimestamp)); err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	_ = db.Migrator().DropTable(&User{})
+	if err = db.AutoMigrate(&User{}); err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	// create
+	xtesting.Nil(t, check(db.Create(&User{Uid: 1, Name: "user1"}), true))
+	xtesting.Nil(t, check(db.Create(&User{Uid: 2, Name: "user2"}), true))
+
+	// query
+	user := &User{}
+	xtesting.Nil(t, check(db.Where(&User{Uid: 1}).First(user), false))
+	xtesting.Equal(t, user.Uid, 1)
+	xtesting.Equal(t, user.Name, "user1")
+	xtesting.Equal(t, user.DeletedAt.UTC().Format("2006-01-02 15:04:05"), DefaultDeletedAtTimestamp)
+	var count int64
+	xtesting.Nil(t, check(db.Model(&User{}).Where("uid = ?", 1).Or("uid = ?", 2).Count(&count), false))
+	xtesting.Equal(t, count, int64(2))
+
+	// update
+	xtesting.Nil(t, check(db.Model(&User{Uid: 1}).Updates(&User{Name: "user1_new"}), true))
+	user = &User{}
+	xtesting.Nil(t, check(db.Where(&User{Uid: 1}).First(user), false))
+	xtesting.Equal(t, user.Name, "user1_new")
+	xtesting.True(t, errors.Is(db.Model(&User{}).Updates(&User{Name: "user"}).Error, gorm.ErrMissingWhereClause))
+
+	// soft delete
+	xtesting.Nil(t, check(db.Delete(&User{Uid: 1}), true))
+	xtesting.True(t, errors.Is(db.Where(&User{Uid: 1}).First(&User{}).Error, gorm.ErrRecordNotFound))
+	xtesting.Equal(t, db.Model(&User{Uid: 1}).Updates(&User{Name: "user1"}).RowsAffected, int64(0))
+	xtesting.Equal(t, db.Delete(&User{Uid: 1}).RowsAffected, int64(0))
+	user = &User{}
+	xtesting.Nil(t, check(db.Unscoped().Where(&User{Uid: 1}).First(user), false))
+	xtesting.NotEqual(t, user.DeletedAt.UTC().Format("2006-01-02 15:04:05"), DefaultDeletedAtTimestamp)
+	xtesting.Nil(t, check(db.Model(&User{}).Where("uid = ?", 1).Or("uid = ?", 2).Count(&count), false))
+	xtesting.Equal(t, count, int64(1))
+	xtesting.True(t, errors.Is(db.Delete(&User{}).Error, gorm.ErrMissingWhereClause))
+
+	// hard delete
+	xtesting.Nil(t, check(db.Unscoped().Delete(&User{Uid: 1}), true))
+	xtesting.True(t, errors.Is(db.Unscoped().Where(&User{Uid: 1}).First(&User{}).Error, gorm.ErrRecordNotFound))
+}
+
+func testHelper(t *testing.T, giveDialector gorm.Dialector) {
+	l := logrus.New()
+	l.SetFormatter(&logrus.TextFormatter{ForceColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339})
+
+	db, err := gorm.Open(giveDialector, &gorm.Config{Logger: NewLogrusLogger(l)})
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	if err = db.Use(NewSoftDeletePlugin(DefaultDeletedAtTimestamp)); err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	_ = db.Migrator().DropTable(&User{})
+	if err = db.AutoMigrate(&User{}); err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+
+	// dialect
+	xtesting.Equal(t, IsMySQL(db), giveDialector.Name() == "mysql")
+	xtesting.Equal(t, IsSQLite(db), giveDialector.Name() == "sqlite")
+	xtesting.False(t, IsPostgreSQL(db))
+
+	// create
+	sts, err := CreateErr(db.Create(&User{Uid: 1, Name: "user1"}))
+	xtesting.Equal(t, sts, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	sts, err = CreateErr(db.Create(&User{Uid: 2, Name: "user1"})) // existed
+	xtesting.Equal(t, sts, xstatus.DbExisted)
+	xtesting.NotNil(t, err)
+	log.Println(sts, err)
+	log.Printf("%T", err)
+	sts, err = CreateErr(db.Create(&User{Uid: 2, Name: "user2"}))
+	xtesting.Equal(t, sts, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+
+	// query
+	sts, err = QueryErr(db.Where(&User{Uid: 1}).First(&User{}))
+	xtesting.Equal(t, sts, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	sts, err = QueryErr(db.Where(&User{Uid: 2, Name: "user1"}).First(&User{})) // not found
+	xtesting.Equal(t, sts, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+	sts, err = QueryErr(db.Where(&User{Uid: 3}).Find(&[]*User{})) // empty
+	xtesting.Equal(t, sts, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	sts, err = QueryErr(db.Table("tbl_not_existed").First(&User{})) // failed
+	xtesting.Equal(t, sts, xstatus.DbFailed)
+	xtesting.NotNil(t, err)
+
+	// update
+	sts, err = UpdateErr(db.Model(&User{}).Where(&User{Uid: 1}).Updates(&User{Name: "user1_new"}))
+	xtesting.Equal(t, sts, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	sts, err = UpdateErr(db.Model(&User{}).Where(&User{Uid: 3}).Updates(&User{Name: "user3"})) // not found
+	xtesting.Equal(t, sts, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+	sts, err = UpdateErr(db.Model(&User{}).Where(&User{Uid: 2}).Updates(&User{Name: "user1_new"})) // existed
+	xtesting.Equal(t, sts, xstatus.DbExisted)
+	xtesting.NotNil(t, err)
+	log.Println(sts, err)
+	log.Printf("%T", err)
+
+	// delete
+	sts, err = DeleteErr(db.Delete(&User{Uid: 1}))
+	xtesting.Equal(t, sts, xstatus.DbSuccess)
+	xtesting.Nil(t, err)
+	sts, err = DeleteErr(db.Delete(&User{Uid: 1})) // deleted
+	xtesting.Equal(t, sts, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+	sts, err = DeleteErr(db.Delete(&User{Uid: 3})) // not found
+	xtesting.Equal(t, sts, xstatus.DbNotFound)
+	xtesting.Nil(t, err)
+	sts, err = DeleteErr(db.Delete(&User{})) // missing where
+	xtesting.Equal(t, sts, xstatus.DbFailed)
+	xtesting.NotNil(t, err)
+}
+
+func testLogger(t *testing.T, giveDialector gorm.Dialector) {
+	l1 := logrus.New()
+	l1.SetFormatter(&logrus.TextFormatter{ForceColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339})
+	l2 := log.New(os.Stderr, "", log.LstdFlags)
+
+	for _, tc := range []struct {
+		name   string
+		logger logger.Interface
+	}{
+		{"default", logger.Default},
+		{"silent", logger.Default.LogMode(logger.Silent)},
+		{"logrus", NewLogrusLogger(l1)},
+		{"logrus_no_info", NewLogrusLogger(l1, WithLogInfo(false))},
+		{"logrus_no_other", NewLogrusLogger(l1, WithLogOther(false))},
+		{"logrus_sql_level", NewLogrusLogger(l1, WithSqlLevel(logrus.WarnLevel))},
+		{"logrus_slow", NewLogrusLogger(l1, WithLogLevel(logger.Warn), WithSlowThreshold(time.Nanosecond))},
+		{"logrus_only_errors", NewLogrusLogger(l1, WithOnlyErrors(true))},
+		{"logger", NewLoggerLogger(l2)},
+		{"logger_no_info_other", NewLoggerLogger(l2, WithLogInfo(false), WithLogOther(false))},
+		{"logger_silent", NewLoggerLogger(l2).LogMode(logger.Silent)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := gorm.Open(giveDialector, &gorm.Config{Logger: tc.logger})
+			if err != nil {
+				log.Println(err)
+				t.FailNow()
+			}
+			_ = db.Use(NewSoftDeletePlugin(DefaultDeletedAtTimestamp))
+			_ = db.Callback().Query().Replace("gorm:query", db.Callback().Query().Get("gorm:query")) // log [info]
+			_ = db.Migrator().DropTable(&User{})
+			if err = db.AutoMigrate(&User{}); err != nil {
+				log.Println(err)
+				t.FailNow()
+			}
+
+			db.Create(&User{Uid: 1, Name: "user1"})
+			db.Create(&User{Uid: 1, Name: "user1"}) // log [log]
+			db.Where(&User{Uid: 1}).First(&User{})
+			db.Where(&User{Uid: 2}).First(&User{}) // record not found
+			db.Where("name = ? OR name = ?", []byte("user1"), []byte{0x00, 0x01}).First(&User{})
+		})
+	}
+
+	t.Run("format", func(t *testing.T) {
+		l, hook := logrustest.NewNullLogger()
+		db, err := gorm.Open(giveDialector, &gorm.Config{Logger: NewLogrusLogger(l)})
+		if err != nil {
+			log.Println(err)
+			t.FailNow()
+		}
+		_ = db.Migrator().DropTable(&User{})
+		if err = db.AutoMigrate(&User{}); err != nil {
+			log.Println(err)
+			t.FailNow()
+		}
+		hook.Reset()
+
+		_ = db.Callback().Query().Replace("gorm:query", db.Callback().Query().Get("gorm:query"))
+		xtesting.Equal(t, len(hook.AllEntries()), 1)
+		xtesting.Equal(t, hook.LastEntry().Level, logrus.InfoLevel)
+		xtesting.Equal(t, hook.LastEntry().Data["type"], "info")
+		xtesting.True(t, strings.HasPrefix(hook.LastEntry().Message, "[Gorm] replacing callback `gorm:query` from "))
+		xtesting.True(t, strings.Contains(hook.LastEntry().Message, "xgormv2_test.go:"))
+
+		hook.Reset()
+		db.Where(&User{Uid: 1}).First(&User{})
+		xtesting.Equal(t, len(hook.AllEntries()), 1) // record not found is not logged
+		entry := hook.LastEntry()
+		xtesting.Equal(t, entry.Level, logrus.InfoLevel)
+		xtesting.Equal(t, entry.Data["type"], "sql")
+		xtesting.Equal(t, entry.Data["rows"], int64(0))
+		xtesting.True(t, strings.HasPrefix(entry.Message, "[Gorm]       0 | "))
+		xtesting.True(t, strings.Contains(entry.Message, " | SELECT * FROM "))
+		xtesting.True(t, strings.Contains(entry.Message, "LIMIT 1 | "))
+		xtesting.True(t, strings.Contains(entry.Message, "xgormv2_test.go:"))
+
+		hook.Reset()
+		db.Create(&User{Uid: 1, Name: "user1"})
+		db.Create(&User{Uid: 1, Name: "user1"})
+		entries := hook.AllEntries()
+		xtesting.Equal(t, len(entries), 3) // sql, log, sql
+		xtesting.Equal(t, entries[1].Level, logrus.ErrorLevel)
+		xtesting.Equal(t, entries[1].Data["type"], "log")
+		xtesting.True(t, strings.HasPrefix(entries[1].Message, "[Gorm] [log] "))
+		xtesting.Equal(t, entries[2].Data["type"], "sql")
+		xtesting.Equal(t, entries[2].Data["rows"], int64(0))
+
+		hook.Reset()
+		db.Logger = db.Logger.LogMode(logger.Silent)
+		db.Where(&User{Uid: 1}).First(&User{})
+		xtesting.Equal(t, len(hook.AllEntries()), 0)
+	})
+}
+
+func TestIsPostgreSQLUniqueViolationError(t *testing.T) {
+	for _, tc := range []struct {
+		giveError error
+		want      bool
+	}{
+		{nil, false},
+		{errors.New("xxx"), false},
+		{&mysql.MySQLError{Number: MySQLDuplicateEntryErrno}, false},
+		{&pq.Error{Code: "23503"}, false},
+		{&pq.Error{Code: PostgreSQLUniqueViolationErrno}, true},
+		{pq.Error{Code: PostgreSQLUniqueViolationErrno}, true},
+		{&pgError{code: PostgreSQLUniqueViolationErrno}, true},
+		{&pgError{code: "23503"}, false},
+	} {
+		xtesting.Equal(t, IsPostgreSQLUniqueViolationError(tc.giveError), tc.want)
+	}
+}
+
+type pgError struct{ code string }
+
+func (p *pgError) Error() string    { return "ERROR: pg error (SQLSTATE " + p.code + ")" }
+func (p *pgError) SQLState() string { return p.code }
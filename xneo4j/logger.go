@@ -3,6 +3,7 @@ package xneo4j
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/Aoi-hosizora/ahlib-db/internal/redact"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"github.com/sirupsen/logrus"
 	"reflect"
@@ -16,6 +17,7 @@ import (
 type loggerOptions struct {
 	skip         int  // runtime skip
 	counterField bool // write counter fields
+	redact       *redact.Rules
 }
 
 // LoggerOption represents an option for logger, created by WithXXX functions.
@@ -35,6 +37,31 @@ func WithCounterField(switcher bool) LoggerOption {
 	}
 }
 
+// Redactable represents a type whose values will always be rendered as '***' in logs, see WithRedactParams and WithRedactValues.
+type Redactable = redact.Redactable
+
+// WithRedactParams returns a LoggerOption with parameter name patterns (in glob syntax, case-insensitive) whose values will be rendered
+// as '***' in logs, such as "password" and "*_token".
+func WithRedactParams(names ...string) LoggerOption {
+	return func(o *loggerOptions) {
+		if o.redact == nil {
+			o.redact = &redact.Rules{}
+		}
+		o.redact.AddNames(names...)
+	}
+}
+
+// WithRedactValues returns a LoggerOption with value regexps, parameter values that match any of these regexps will be rendered as '***' in logs.
+// Note that values whose type implements Redactable will always be redacted.
+func WithRedactValues(regexps ...*regexp.Regexp) LoggerOption {
+	return func(o *loggerOptions) {
+		if o.redact == nil {
+			o.redact = &redact.Rules{}
+		}
+		o.redact.AddRegexps(regexps...)
+	}
+}
+
 // _enable is a global switcher to control xneo4j logger behavior.
 var _enable = true
 
@@ -179,7 +206,7 @@ func formatLoggerAndFields(result neo4j.Result, err error, source string, option
 	} else {
 		stat := summary.Statement()
 		counters := summary.Counters()
-		cypher := render(stat.Text(), stat.Params())
+		cypher := render(stat.Text(), redactParams(stat.Params(), options.redact))
 		du := summary.ResultAvailableAfter() + summary.ResultConsumedAfter()
 
 		fields = logrus.Fields{
@@ -210,10 +237,30 @@ func formatLoggerAndFields(result neo4j.Result, err error, source string, option
 	return msg, fields, isErr
 }
 
+// redactedValue represents a redacted parameter, used in redactParams and render.
+type redactedValue struct{}
+
+// redactParams checks given parameters using redact.Rules, and returns new parameters with redacted values replaced by redactedValue.
+func redactParams(params map[string]interface{}, rules *redact.Rules) map[string]interface{} {
+	result := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if rules.MatchName(k) || rules.MatchValue(v) {
+			result[k] = redactedValue{}
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
 // render renders cypher string and parameters to complete cypher expression.
 func render(cypher string, params map[string]interface{}) string {
 	values := make(map[string]string, len(params))
 	for k, v := range params {
+		if _, ok := v.(redactedValue); ok {
+			values[k] = fmt.Sprintf("'%s'", redact.Mask)
+			continue
+		}
 		indirectValue := reflect.Indirect(reflect.ValueOf(v))
 		if !indirectValue.IsValid() {
 			values[k] = "NULL"
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -266,3 +267,26 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+type secret string
+
+func (secret) Redacted() {}
+
+func TestRedact(t *testing.T) {
+	opt := &loggerOptions{}
+	WithRedactParams("password", "*_token")(opt)
+	WithRedactValues(regexp.MustCompile(`^\d{4}-\d{4}$`))(opt)
+
+	for _, tc := range []struct {
+		giveCypher string
+		giveParams map[string]interface{}
+		want       string
+	}{
+		{`MATCH (n {name: $name, password: $password}) RETURN n`, P{"name": "n", "password": "p"}, `MATCH (n {name: 'n', password: '***'}) RETURN n`},
+		{`MATCH (n {token: $access_token, phone: $phone}) RETURN n`, P{"access_token": "t", "phone": "1234-5678"}, `MATCH (n {token: '***', phone: '***'}) RETURN n`},
+		{`MATCH (n {name: $name}) RETURN n`, P{"name": secret("n")}, `MATCH (n {name: '***'}) RETURN n`},
+		{`MATCH (n {id: $id}) RETURN n`, P{"id": 1}, `MATCH (n {id: 1}) RETURN n`},
+	} {
+		xtesting.Equal(t, render(tc.giveCypher, redactParams(tc.giveParams, opt.redact)), tc.want)
+	}
+}
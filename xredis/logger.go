@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/Aoi-hosizora/ahlib-db/internal/redact"
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -20,6 +22,7 @@ type ILogger interface {
 // loggerOptions represents some options for logger, set by LoggerOption.
 type loggerOptions struct {
 	logErr bool
+	redact *redact.Rules
 }
 
 // LoggerOption represents an option for logger, created by WithXXX functions.
@@ -32,6 +35,32 @@ func WithLogErr(logErr bool) LoggerOption {
 	}
 }
 
+// Redactable represents a type whose values will always be rendered as *** in logs, see WithRedactKeys and WithRedactValues.
+type Redactable = redact.Redactable
+
+// WithRedactKeys returns a LoggerOption with key patterns (in glob syntax, case-insensitive) whose values will be rendered as *** in logs,
+// such as "session:*" (note that "*" also matches "/"). Only the value arguments of a matched key will be redacted, options such as EX and NX
+// of SET command will be kept, see redactArgs for details. Note that the arguments of AUTH command will always be redacted.
+func WithRedactKeys(patterns ...string) LoggerOption {
+	return func(o *loggerOptions) {
+		if o.redact == nil {
+			o.redact = &redact.Rules{}
+		}
+		o.redact.AddNames(patterns...)
+	}
+}
+
+// WithRedactValues returns a LoggerOption with value regexps, arguments that match any of these regexps will be rendered as *** in logs.
+// Note that arguments whose type implements Redactable will always be redacted.
+func WithRedactValues(regexps ...*regexp.Regexp) LoggerOption {
+	return func(o *loggerOptions) {
+		if o.redact == nil {
+			o.redact = &redact.Rules{}
+		}
+		o.redact.AddRegexps(regexps...)
+	}
+}
+
 // _enable is a global switcher to control xredis logger behavior.
 var _enable = true
 
@@ -138,7 +167,7 @@ func (l *LogrusLogger) AfterProcess(ctx context.Context, cmd redis.Cmder) error
 
 	_, file, line, _ := runtime.Caller(4)
 	source := fmt.Sprintf("%s:%d", file, line)
-	msg, fields, isErr := formatLoggerAndFields(cmd, endTime.Sub(startTime), source)
+	msg, fields, isErr := formatLoggerAndFields(cmd, endTime.Sub(startTime), source, l.options)
 	if isErr {
 		if l.options.logErr {
 			l.logger.WithFields(fields).Error(msg)
@@ -173,7 +202,7 @@ func (l *LoggerLogger) AfterProcess(ctx context.Context, cmd redis.Cmder) error
 
 	_, file, line, _ := runtime.Caller(4)
 	source := fmt.Sprintf("%s:%d", file, line)
-	msg, _, isErr := formatLoggerAndFields(cmd, endTime.Sub(startTime), source)
+	msg, _, isErr := formatLoggerAndFields(cmd, endTime.Sub(startTime), source, l.options)
 	if !isErr || l.options.logErr {
 		l.logger.Print(msg)
 	}
@@ -181,7 +210,7 @@ func (l *LoggerLogger) AfterProcess(ctx context.Context, cmd redis.Cmder) error
 	return nil
 }
 
-// formatLoggerAndFields formats redis.Cmder and time.Duration to logger string, logrus.Fields and isError flag.
+// formatLoggerAndFields formats redis.Cmder, time.Duration, source and loggerOptions to logger string, logrus.Fields and isError flag.
 // Logs like:
 // 	[Redis] ERR invalid password | SET test_a test_aaa | F:/Projects/ahlib-db/xredis/redis_test.go:41
 // 	[Redis]    Nil |   305.9909ms | GET test | F:/Projects/ahlib-db/xredis/redis_test.go:126
@@ -191,7 +220,7 @@ func (l *LoggerLogger) AfterProcess(ctx context.Context, cmd redis.Cmder) error
 // 	[Redis]     OK |    25.9306ms | SET 'test num' 1 | F:/Projects/ahlib-db/xredis/redis_test.go:59
 // 	       |------| |------------| |----------------| |--------------------------------------------|
 // 	          6           12               ...                               ...
-func formatLoggerAndFields(cmd redis.Cmder, duration time.Duration, source string) (string, logrus.Fields, bool) {
+func formatLoggerAndFields(cmd redis.Cmder, duration time.Duration, source string, options *loggerOptions) (string, logrus.Fields, bool) {
 	var msg string
 	var fields logrus.Fields
 	var isErr bool
@@ -201,7 +230,7 @@ func formatLoggerAndFields(cmd redis.Cmder, duration time.Duration, source strin
 	if err != nil && !isnil {
 		// error
 		isErr = true
-		command := render(cmd.Args())
+		command := render(redactArgs(cmd.Args(), options.redact))
 		fields = logrus.Fields{
 			"module":  "redis",
 			"command": command,
@@ -211,7 +240,7 @@ func formatLoggerAndFields(cmd redis.Cmder, duration time.Duration, source strin
 		msg = fmt.Sprintf("[Redis] %v | %s | %s", err, command, source)
 	} else {
 		// result
-		command := render(cmd.Args())
+		command := render(redactArgs(cmd.Args(), options.redact))
 		rows, status := parseCmd(cmd)
 		first := "" // first field
 		if isnil {
@@ -237,13 +266,103 @@ func formatLoggerAndFields(cmd redis.Cmder, duration time.Duration, source strin
 	return msg, fields, isErr
 }
 
+// redactedValue represents a redacted argument, used in redactArgs and render.
+type redactedValue struct{}
+
+// redactArgs checks given command arguments using redact.Rules, and returns new arguments with redacted values replaced by redactedValue.
+// For a command whose key matches the rules, only the value positions (such as "value" in "SET key value EX 10") are redacted if the
+// command is known, otherwise all the arguments after the key are redacted.
+func redactArgs(args []interface{}, rules *redact.Rules) []interface{} {
+	result := make([]interface{}, len(args))
+	copy(result, args)
+	if len(args) <= 1 {
+		return result
+	}
+	redactFrom := func(start, count int) {
+		for i := start; i < len(result) && (count < 0 || i < start+count); i++ {
+			result[i] = redactedValue{}
+		}
+	}
+	argString := func(idx int) string {
+		s, _ := args[idx].(string)
+		return strings.ToUpper(s)
+	}
+
+	switch command := argString(0); command {
+	case "AUTH":
+		redactFrom(1, -1) // AUTH [username] password
+	case "HELLO", "MIGRATE":
+		for i := 1; i < len(args); i++ {
+			switch argString(i) {
+			case "AUTH":
+				redactFrom(i+1, 1) // MIGRATE ... AUTH password
+				if command == "HELLO" {
+					redactFrom(i+1, 2) // HELLO protover AUTH username password
+				}
+			case "AUTH2":
+				redactFrom(i+1, 2) // MIGRATE ... AUTH2 username password
+			}
+		}
+	case "CONFIG":
+		if len(args) >= 4 && argString(1) == "SET" && (argString(2) == "REQUIREPASS" || argString(2) == "MASTERAUTH") {
+			redactFrom(3, 1) // CONFIG SET requirepass password
+		}
+	case "MSET", "MSETNX":
+		for i := 1; i+1 < len(args); i += 2 {
+			if key, ok := args[i].(string); ok && rules.MatchName(key) {
+				redactFrom(i+1, 1) // MSET key value [key value ...]
+			}
+		}
+	default:
+		if key, ok := args[1].(string); !ok || !rules.MatchName(key) {
+			break
+		}
+		switch command {
+		case "SET", "SETNX", "GETSET", "APPEND", "PUBLISH":
+			redactFrom(2, 1) // SET key value [EX seconds|PX milliseconds|NX|XX|KEEPTTL|GET]
+		case "SETEX", "PSETEX", "SETRANGE", "LSET", "HSETNX", "LREM":
+			redactFrom(3, 1) // SETEX key seconds value
+		case "LINSERT":
+			redactFrom(3, 2) // LINSERT key BEFORE|AFTER pivot element
+		case "HSET", "HMSET":
+			for i := 3; i < len(args); i += 2 {
+				redactFrom(i, 1) // HSET key field value [field value ...]
+			}
+		case "ZADD":
+			i := 2
+			for ; i < len(args); i++ {
+				if s := argString(i); s != "NX" && s != "XX" && s != "GT" && s != "LT" && s != "CH" && s != "INCR" {
+					break
+				}
+			}
+			for i++; i < len(args); i += 2 {
+				redactFrom(i, 1) // ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+			}
+		case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "GETEX", "INCRBY", "DECRBY", "INCRBYFLOAT", "GETRANGE", "LRANGE", "LINDEX",
+			"LTRIM", "ZRANGE", "ZREVRANGE", "HGET", "HMGET", "HDEL", "HEXISTS", "HINCRBY":
+			// no value argument, such as EXPIRE key seconds
+		default:
+			redactFrom(2, -1) // COMMAND key values..., such as LPUSH and SADD, which is also the fallback for unknown commands
+		}
+	}
+
+	for i := 1; i < len(result); i++ {
+		if rules.MatchValue(result[i]) {
+			result[i] = redactedValue{}
+		}
+	}
+	return result
+}
+
 // render renders command parameters to complete redis command expression.
 func render(args []interface{}) string {
 	sp := strings.Builder{}
 	sp.WriteString(strings.ToUpper(args[0].(string)))
 	for _, arg := range args[1:] {
 		argStr := ""
-		if s, ok := arg.(string); ok && strings.Contains(s, " ") {
+		if _, ok := arg.(redactedValue); ok {
+			argStr = redact.Mask
+		} else if s, ok := arg.(string); ok && strings.Contains(s, " ") {
 			argStr = fmt.Sprintf("'%s'", s)
 		} else {
 			argStr = fmt.Sprintf("%v", arg)
@@ -7,6 +7,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"log"
 	"os"
+	"regexp"
 	"testing"
 	"time"
 )
@@ -197,3 +198,41 @@ func TestLogger(t *testing.T) {
 		})
 	}
 }
+
+type secret string
+
+func (secret) Redacted() {}
+
+func TestRedact(t *testing.T) {
+	opt := &loggerOptions{}
+	WithRedactKeys("session:*", "token")(opt)
+	WithRedactValues(regexp.MustCompile(`^\d{4}-\d{4}$`))(opt)
+
+	for _, tc := range []struct {
+		give []interface{}
+		want string
+	}{
+		{[]interface{}{"auth", "123"}, "AUTH ***"},
+		{[]interface{}{"auth", "user", "123"}, "AUTH *** ***"},
+		{[]interface{}{"hello", 3, "AUTH", "user", "123", "SETNAME", "xxx"}, "HELLO 3 AUTH *** *** SETNAME xxx"},
+		{[]interface{}{"config", "set", "requirepass", "123"}, "CONFIG set requirepass ***"},
+		{[]interface{}{"set", "session:1", "abc", "ex", 10}, "SET session:1 *** ex 10"},
+		{[]interface{}{"set", "session:1", "abc", "px", 100, "nx"}, "SET session:1 *** px 100 nx"},
+		{[]interface{}{"set", "session:user/1", "abc"}, "SET session:user/1 ***"},
+		{[]interface{}{"setex", "session:1", 10, "abc"}, "SETEX session:1 10 ***"},
+		{[]interface{}{"hset", "session:1", "name", "abc", "role", "admin"}, "HSET session:1 name *** role ***"},
+		{[]interface{}{"zadd", "session:1", "nx", "ch", 1, "abc", 2, "def"}, "ZADD session:1 nx ch 1 *** 2 ***"},
+		{[]interface{}{"linsert", "session:1", "before", "abc", "def"}, "LINSERT session:1 before *** ***"},
+		{[]interface{}{"lpush", "session:1", "abc", "def"}, "LPUSH session:1 *** ***"},
+		{[]interface{}{"expire", "session:1", 10}, "EXPIRE session:1 10"},
+		{[]interface{}{"xadd", "session:1", "*", "name", "abc"}, "XADD session:1 *** *** ***"},
+		{[]interface{}{"get", "session:1"}, "GET session:1"},
+		{[]interface{}{"set", "test", "abc"}, "SET test abc"},
+		{[]interface{}{"mset", "a", "1", "token", "2", "phone", "1234-5678"}, "MSET a 1 token *** phone ***"},
+		{[]interface{}{"set", "test", secret("abc")}, "SET test ***"},
+	} {
+		xtesting.Equal(t, render(redactArgs(tc.give, opt.redact)), tc.want)
+	}
+	xtesting.Equal(t, render(redactArgs([]interface{}{"auth", "123"}, nil)), "AUTH ***")
+	xtesting.Equal(t, render(redactArgs([]interface{}{"set", "test", "test 1"}, nil)), "SET test 'test 1'")
+}